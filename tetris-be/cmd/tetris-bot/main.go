@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"tetris-be/internal/loadtest"
+	"time"
+)
+
+// tetris-bot spins up simulated players against a running server and prints what they measured:
+//
+//	go run ./cmd/tetris-bot -url http://localhost:8080 -clients 100 -duration 1m
+func main() {
+	var cfg loadtest.Config
+	flag.StringVar(&cfg.URL, "url", "http://localhost:8080", "Server base URL")
+	flag.IntVar(&cfg.Clients, "clients", 10, "Simulated players, two per room")
+	flag.DurationVar(&cfg.Duration, "duration", 30*time.Second, "How long each match is played")
+	flag.Float64Var(&cfg.KeysPerSecond, "kps", 4, "Key presses per second per player")
+	flag.Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "Seed of the random key presses")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	report, err := loadtest.Run(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(report)
+	if len(report.Errors) > 0 || report.Dropped > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"tetris-be/internal/game"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+)
+
+// tetris-tui plays a match from the terminal, speaking the same protocol as the frontend:
+//
+//	go run ./cmd/tetris-tui -player alice                  # new room, share the room id
+//	go run ./cmd/tetris-tui -player bob -room ABC12        # join it
+//	go run ./cmd/tetris-tui -player alice -bot normal      # new room against a server bot
+//
+// Arrows move, up rotates, z rotates left, c holds, space drops, r is ready, q quits
+func main() {
+	serverURL := flag.String("url", "http://localhost:8080", "Server base URL")
+	playerID := flag.String("player", "", "Player id")
+	roomID := flag.String("room", "", "Room to join, a new one is created if empty")
+	key := flag.String("key", "", "Room key")
+	bot := flag.String("bot", "", "Add a bot of this difficulty to the room (easy, normal, hard)")
+	flag.Parse()
+	if *playerID == "" {
+		*playerID = fmt.Sprintf("tui-%d", time.Now().UnixNano()%100000)
+	}
+	//anything logged would be drawn over the boards
+	log.SetOutput(os.Stderr)
+
+	base, err := url.Parse(*serverURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	room, query, err := joinRoom(base, *roomID, *playerID, *key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheme := "ws"
+	if base.Scheme == "https" {
+		scheme = "wss"
+	}
+	wsURL := url.URL{Scheme: scheme, Host: base.Host, Path: "/ws/match", RawQuery: query}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL.String(), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if *bot != "" {
+		if err := addBot(base, room.ID, *key, *bot); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer term.Restore(fd, oldState)
+	fmt.Print("\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\r\n")
+
+	c := newClient(conn, *playerID, room)
+	c.run()
+}
+
+func joinRoom(base *url.URL, roomID, playerID, key string) (game.RoomDTO, string, error) {
+	body, _ := json.Marshal(map[string]string{"playerID": playerID, "key": key})
+	resp, err := http.Post(base.String()+"/rooms?roomid="+url.QueryEscape(roomID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return game.RoomDTO{}, "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Room  game.RoomDTO `json:"room"`
+		WsURL string       `json:"ws_url"`
+		Error any          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return game.RoomDTO{}, "", err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return game.RoomDTO{}, "", fmt.Errorf("POST /rooms: %v", out.Error)
+	}
+	u, err := url.Parse(out.WsURL)
+	if err != nil {
+		return game.RoomDTO{}, "", err
+	}
+	//only the ticket matters, the host in ws_url is the one the server thinks it has
+	return out.Room, u.RawQuery, nil
+}
+
+func addBot(base *url.URL, roomID, key, difficulty string) error {
+	body, _ := json.Marshal(map[string]string{"key": key, "difficulty": difficulty})
+	resp, err := http.Post(base.String()+"/rooms/bots?roomid="+url.QueryEscape(roomID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.New("POST /rooms/bots: " + resp.Status)
+	}
+	return nil
+}
+
+const (
+	softDropFrames = 6  // a terminal has no key up: soft drop stops this long after the last down
+	opponentBoards = 16 // opponent boards kept by seq, deltas apply on the one they name
+)
+
+type client struct {
+	conn     *websocket.Conn
+	room     game.RoomDTO
+	pred     *game.Predictor
+	delay    int
+	startAt  time.Time
+	seq      int
+	sentTo   int              // last frame an input batch was sent for
+	pending  map[int][]string // keys by the frame they apply on, input delay included
+	lastDown int
+	boards   map[int][][]int // opponent boards by seq
+	v        view
+	started  bool
+	over     bool
+}
+
+func newClient(conn *websocket.Conn, me string, room game.RoomDTO) *client {
+	return &client{
+		conn:    conn,
+		room:    room,
+		pending: map[int][]string{},
+		boards:  map[int][][]int{},
+		v: view{
+			me:     me,
+			hidden: room.Settings.Board.Hidden,
+			status: fmt.Sprintf("room %s, press r when everyone is in", room.ID),
+			help:   "←→ move  ↑/z rotate  ↓ soft drop  space drop  c hold  r ready  q quit",
+		},
+	}
+}
+
+func (c *client) run() {
+	msgs := make(chan game.Message, 64)
+	go func() {
+		defer close(msgs)
+		for {
+			var msg game.Message
+			if err := c.conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	keys := make(chan []string, 16)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- parseKeys(buf[:n])
+		}
+	}()
+	ticker := time.NewTicker(time.Second / game.TICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				c.v.status = "disconnected from the server"
+				render(os.Stdout, c.v)
+				return
+			}
+			c.handle(msg)
+		case actions, ok := <-keys:
+			if !ok {
+				return
+			}
+			for _, action := range actions {
+				switch action {
+				case "quit":
+					return
+				case "ready":
+					if !c.started {
+						c.send(game.NewMessage("ready"))
+					}
+				default:
+					c.press(action)
+				}
+			}
+		case <-ticker.C:
+			c.tick()
+			render(os.Stdout, c.v)
+		}
+	}
+}
+
+func (c *client) send(msg game.Message) {
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.v.status = "send: " + err.Error()
+	}
+}
+
+// frame the local frame now, negative before startAt
+func (c *client) frame() int {
+	return int(time.Since(c.startAt) / (time.Second / game.TICK))
+}
+
+// press put a key on the first frame after the input delay that has none yet, so two keys never
+// share a frame (left and right together are refused) and nothing lands on an already sent frame
+func (c *client) press(k string) {
+	if !c.started || c.over {
+		return
+	}
+	frame := max(c.frame()+c.delay, c.sentTo+1)
+	for len(c.pending[frame]) > 0 {
+		frame++
+	}
+	c.pending[frame] = []string{k}
+	if k == "down" {
+		c.lastDown = c.frame()
+	}
+}
+
+// tick send the input batch of this frame and predict our board up to it
+func (c *client) tick() {
+	if !c.started || c.over {
+		return
+	}
+	now := c.frame()
+	if now < 0 {
+		c.v.status = fmt.Sprintf("starting in %.1fs", -time.Since(c.startAt).Seconds())
+		return
+	}
+	c.v.status = "playing"
+	if c.lastDown > 0 && now-c.lastDown >= softDropFrames {
+		c.lastDown = 0
+		c.press("downOff")
+	}
+	for frame := c.sentTo + 1; frame <= now+c.delay; frame++ {
+		msg := game.NewMessage("inputs")
+		c.seq++
+		msg.Payload.Seq = c.seq
+		msg.Payload.LatestFrame = frame
+		if keys := c.pending[frame]; len(keys) > 0 {
+			msg.Payload.Inputs = []game.Input{{Frame: frame, Keys: keys}}
+		}
+		c.send(msg)
+		c.sentTo = frame
+	}
+	for c.pred.Frame() < now {
+		frame := c.pred.Frame() + 1
+		if err := c.pred.Step(c.pending[frame]); err != nil {
+			c.v.status = "topped out, waiting for the server"
+			break
+		}
+		delete(c.pending, frame-game.QUEUE_SIZE)
+	}
+	c.v.mine, c.v.haveMine = c.pred.State(), true
+	c.v.frame = now
+}
+
+func (c *client) handle(msg game.Message) {
+	switch msg.Type {
+	case "start":
+		if msg.Error != "" {
+			c.v.status = "cannot start: waiting for players"
+			return
+		}
+		c.pred = game.NewPredictor(c.room.Settings, msg.Payload.ListBlock)
+		c.delay = msg.Payload.InputDelay
+		c.startAt = time.UnixMilli(msg.Payload.StartAt)
+		c.started = true
+		c.send(game.NewMessage("start"))
+	case "server-state", "garbage-sync", "resync":
+		if c.pred == nil {
+			return
+		}
+		before := countGarbage(c.v.mine.Board)
+		if err := c.pred.Adopt(msg.Payload.LatestFrame, msg.Payload.BoardState); err != nil {
+			c.v.status = "topped out, waiting for the server"
+		}
+		c.v.mine = c.pred.State()
+		if msg.Type == "garbage-sync" {
+			c.v.incoming = max(0, c.v.incoming-(countGarbage(c.v.mine.Board)-before))
+		}
+	case "opponent", "opponent-delta":
+		c.opponentBoard(msg)
+	case "attack-incoming", "attack-cancelled":
+		if msg.PlayerId != c.v.me || msg.Payload.Attack == nil {
+			return
+		}
+		if msg.Type == "attack-incoming" {
+			c.v.incoming += msg.Payload.Attack.Amount
+		} else {
+			c.v.incoming = max(0, c.v.incoming-msg.Payload.Attack.Amount)
+		}
+	case "stats":
+		if s := msg.Payload.Stats; s != nil && msg.PlayerId == c.v.me {
+			c.v.stats = fmt.Sprintf("%.2f pps  %.1f apm  %d lines  finesse %d", s.PPS, s.APM, s.Lines, s.Finesse)
+		}
+	case "ping":
+		pong := game.NewMessage("pong")
+		pong.Timestamp = msg.Timestamp
+		pong.Payload.ClientTime = time.Now().UnixMilli()
+		c.send(pong)
+	case "gameover":
+		c.over = true
+		c.v.status = "game over, q to quit"
+	case "disconnected", "reconnected":
+		c.v.status = msg.PlayerId + " " + msg.Type
+	case "error":
+		c.v.status = "error: " + msg.Error
+	}
+}
+
+// opponentBoard keep the boards we acked by seq, a delta carries the rows changed since BaseSeq
+func (c *client) opponentBoard(msg game.Message) {
+	state := msg.Payload.BoardState
+	if msg.Type == "opponent-delta" {
+		base, ok := c.boards[msg.Payload.BaseSeq]
+		if !ok {
+			req := game.NewMessage("keyframe")
+			req.PlayerId = msg.PlayerId
+			c.send(req)
+			return
+		}
+		board := make([][]int, len(base))
+		copy(board, base)
+		for _, row := range msg.Payload.Rows {
+			if row.Row >= 0 && row.Row < len(board) {
+				board[row.Row] = row.Cells
+			}
+		}
+		state.Board = board
+	}
+	seq := msg.Payload.Seq
+	c.boards[seq] = state.Board
+	delete(c.boards, seq-opponentBoards)
+	c.v.opponent, c.v.theirs, c.v.haveTheirs = msg.PlayerId, state, true
+
+	ack := game.NewMessage("opponent-ack")
+	ack.PlayerId = msg.PlayerId
+	ack.Payload.Seq = seq
+	c.send(ack)
+}
+
+func countGarbage(board [][]int) int {
+	n := 0
+	for _, row := range board {
+		for _, cell := range row {
+			if cell == 8 {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// parseKeys turn what the terminal sent in raw mode into protocol keys, plus ready and quit
+func parseKeys(buf []byte) []string {
+	var keys []string
+	for i := 0; i < len(buf); i++ {
+		if buf[i] == 0x1b && i+2 < len(buf) && buf[i+1] == '[' {
+			switch buf[i+2] {
+			case 'A':
+				keys = append(keys, "rotate")
+			case 'B':
+				keys = append(keys, "down")
+			case 'C':
+				keys = append(keys, "right")
+			case 'D':
+				keys = append(keys, "left")
+			}
+			i += 2
+			continue
+		}
+		switch buf[i] {
+		case ' ':
+			keys = append(keys, "space")
+		case 'z', 'Z':
+			keys = append(keys, "rrotate")
+		case 'x', 'X':
+			keys = append(keys, "rotate")
+		case 'c', 'C':
+			keys = append(keys, "hold")
+		case 'r', 'R':
+			keys = append(keys, "ready")
+		case 'q', 'Q', 0x03: //ctrl-c doesn't signal in raw mode
+			keys = append(keys, "quit")
+		}
+	}
+	return keys
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"tetris-be/internal/game"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	t.Run("arrows and letters", func(t *testing.T) {
+		got := parseKeys([]byte("\x1b[D\x1b[C\x1b[A\x1b[B zxcr"))
+		assert.Equal(t, []string{"left", "right", "rotate", "down", "space", "rrotate", "rotate", "hold", "ready"}, got)
+	})
+	t.Run("quit and unknown bytes", func(t *testing.T) {
+		assert.Equal(t, []string{"quit", "quit"}, parseKeys([]byte("k\x03q")))
+		assert.Empty(t, parseKeys([]byte("\x1b")))
+	})
+}
+
+func TestDrawBoard(t *testing.T) {
+	state := game.BoardStateDTO{
+		Board: [][]int{{0, 0, 0}, {0, 0, 0}, {8, 0, 8}},
+		Block: [][]int{{3, 3}},
+		CRow:  1,
+		CCol:  1,
+	}
+	lines := drawBoard(state, 1)
+	//the hidden row is gone, the piece is drawn over the board, plus the bottom border
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "│ .", lines[0][:len("│ .")])
+	assert.Equal(t, 2, strings.Count(lines[0], "\x1b[48;5;129m"))
+	assert.Equal(t, 2, strings.Count(lines[1], "\x1b[48;5;244m"))
+	assert.Equal(t, "└──────┘", lines[2])
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"tetris-be/internal/game"
+)
+
+// 256 color background of each cell value, the ids of game.Tetromino plus 8 for garbage
+var cellColors = map[int]int{
+	1: 51,  //I cyan
+	2: 226, //O yellow
+	3: 129, //T purple
+	4: 196, //Z red
+	5: 208, //L orange
+	6: 46,  //S green
+	7: 21,  //J blue
+	8: 244, //garbage gray
+}
+
+// view everything drawn on one screen
+type view struct {
+	me, opponent         string
+	mine, theirs         game.BoardStateDTO
+	hidden               int // rows above the visible board
+	frame                int
+	incoming             int // garbage lines announced and not landed yet
+	stats                string
+	status               string
+	help                 string
+	haveMine, haveTheirs bool
+}
+
+// cells the board with the active piece drawn on it
+func cells(state game.BoardStateDTO) [][]int {
+	grid := make([][]int, len(state.Board))
+	for r, row := range state.Board {
+		grid[r] = append([]int(nil), row...)
+	}
+	for y, row := range state.Block {
+		for x, v := range row {
+			r, c := state.CRow+y, state.CCol+x
+			if v != 0 && r >= 0 && r < len(grid) && c >= 0 && c < len(grid[r]) {
+				grid[r][c] = v
+			}
+		}
+	}
+	return grid
+}
+
+// drawBoard one line of text per visible row, two columns per cell
+func drawBoard(state game.BoardStateDTO, hidden int) []string {
+	grid := cells(state)
+	var lines []string
+	for r := min(hidden, len(grid)); r < len(grid); r++ {
+		var b strings.Builder
+		b.WriteString("│")
+		for _, v := range grid[r] {
+			if color, ok := cellColors[v]; ok {
+				fmt.Fprintf(&b, "\x1b[48;5;%dm  \x1b[0m", color)
+			} else {
+				b.WriteString(" .")
+			}
+		}
+		b.WriteString("│")
+		lines = append(lines, b.String())
+	}
+	if len(grid) > 0 {
+		lines = append(lines, "└"+strings.Repeat("──", len(grid[0]))+"┘")
+	}
+	return lines
+}
+
+// render redraw the whole screen from the top left corner, raw mode needs \r\n
+func render(w io.Writer, v view) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "%-22s   %s\r\n", v.me, v.opponent)
+	var left, right []string
+	if v.haveMine {
+		left = drawBoard(v.mine, v.hidden)
+	}
+	if v.haveTheirs {
+		right = drawBoard(v.theirs, v.hidden)
+	}
+	width := 0
+	if len(left) > 0 {
+		width = len(v.mine.Board[0])*2 + 2
+	}
+	for i := 0; i < max(len(left), len(right)); i++ {
+		l, r := "", ""
+		pad := width
+		if i < len(left) {
+			l = left[i]
+			pad = 0
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		fmt.Fprintf(&b, "%s%s   %s\r\n", l, strings.Repeat(" ", pad), r)
+	}
+	fmt.Fprintf(&b, "frame %d  incoming %d  %s\r\n%s\r\n%s\r\n", v.frame, v.incoming, v.stats, v.status, v.help)
+	io.WriteString(w, b.String())
+}
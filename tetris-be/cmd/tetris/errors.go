@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
 
 func errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	e := envelope{"error": message}
@@ -33,3 +37,15 @@ func badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 func failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	errorResponse(w, r, http.StatusBadRequest, errors)
 }
+func invalidTicketResponse(w http.ResponseWriter, r *http.Request, err error) {
+	errorResponse(w, r, http.StatusUnauthorized, err.Error())
+}
+func wrongKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the room key is incorrect"
+	errorResponse(w, r, http.StatusForbidden, message)
+}
+func rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	message := "too many wrong key attempts, please try again later"
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -15,7 +15,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"tetris-be/internal/game"
 	"tetris-be/internal/validator"
+	"time"
 )
 
 const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -27,12 +29,26 @@ func LoadConfig() *Config {
 	flag.Parse()
 
 	if err := godotenv.Load(envPath); err != nil {
-		log.Fatalf("Error loading env file: %s", envPath)
-		return nil
+		//fall back to process env, the file is optional in containers and tests
+		log.Printf("Error loading env file: %s, using environment only", envPath)
 	}
 
 	var cfg Config
 	cfg.port = getIntEnv("PORT", 8080)
+	cfg.reconnectGrace = time.Duration(getIntEnv("RECONNECT_GRACE_SECONDS", 30)) * time.Second
+	cfg.pauseOnDisconnect = getBoolEnv("RECONNECT_PAUSE", true)
+	cfg.ticketSecret = os.Getenv("TICKET_SECRET")
+	if cfg.ticketSecret == "" {
+		log.Printf("TICKET_SECRET is not set, using a random secret for this process")
+	}
+	cfg.ticketTTL = time.Duration(getIntEnv("TICKET_TTL_SECONDS", 30)) * time.Second
+	cfg.cheatFlagAfter = getIntEnv("ANTICHEAT_FLAG_AFTER", game.DefaultAntiCheatConfig.FlagAfter)
+	cfg.cheatForfeit = getBoolEnv("ANTICHEAT_FORFEIT", game.DefaultAntiCheatConfig.Forfeit)
+	cfg.queueTimeout = time.Duration(getIntEnv("MATCHMAKING_TIMEOUT_SECONDS", 120)) * time.Second
+	cfg.ratingsFile = os.Getenv("RATINGS_FILE")
+	cfg.playersDir = os.Getenv("PLAYERS_DIR")
+	cfg.roomsDB = os.Getenv("ROOMS_DB")
+	cfg.roomIdleTTL = time.Duration(getIntEnv("ROOM_IDLE_TTL_SECONDS", 600)) * time.Second
 
 	return &cfg
 }
@@ -50,6 +66,19 @@ func getIntEnv(key string, defaultVal int) int {
 	return num
 }
 
+func getBoolEnv(key string, defaultVal bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Printf("Invalid bool for %s: %s", key, val)
+		return defaultVal
+	}
+	return b
+}
+
 func healthcheck(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status": "available",
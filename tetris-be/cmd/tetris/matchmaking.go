@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"tetris-be/internal/auth"
+	"tetris-be/internal/game"
+	"tetris-be/internal/matchmaking"
+	"tetris-be/internal/rating"
+	"tetris-be/internal/validator"
+	"time"
+)
+
+const (
+	sseKeepAlive = 15 * time.Second
+	maxRegionLen = 16
+)
+
+type queueInput struct {
+	PlayerID string
+	Region   string // region or latency tag, e.g. "eu" or "lt50"
+}
+
+// pairPlayers create a locked room for a matched pair, nobody knows its key so only the two
+// tickets get in
+func pairPlayers(cfg *Config, roomManager game.RoomManager, tickets *auth.TicketIssuer) matchmaking.PairFunc {
+	return func(a, b matchmaking.Request) ([2]matchmaking.Match, error) {
+		var matches [2]matchmaking.Match
+		key, err := generateID(16)
+		if err != nil {
+			return matches, err
+		}
+		settings := game.DefaultRoomSettings()
+		settings.Ranked = true
+		//nobody owns a matched room
+		room, err := roomManager.CreateRoom("", key, settings)
+		if err != nil {
+			return matches, err
+		}
+		players := [2]matchmaking.Request{a, b}
+		for i, p := range players {
+			ticket, err := tickets.Issue(room.ID, p.PlayerID)
+			if err != nil {
+				//nobody gets in without a ticket, the pair is tried again in a new room
+				if live, getErr := roomManager.Get(room.ID); getErr == nil {
+					live.Close()
+				}
+				return matches, err
+			}
+			matches[i] = matchmaking.Match{
+				RoomID:   room.ID,
+				Opponent: players[1-i].PlayerID,
+				WsURL:    matchURL(cfg, room.ID, p.PlayerID, ticket),
+			}
+		}
+		return matches, nil
+	}
+}
+
+// enqueueHandler matchmaking body {playerID, region}, answer the entry id to listen on. Players are
+// paired on their stored rating
+func enqueueHandler(queue *matchmaking.Queue, ratings *rating.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in, err := decode[queueInput](r)
+		if err != nil {
+			badRequestResponse(w, r, err)
+			return
+		}
+		v := validator.New()
+		ValidateInput(v, input{PlayerID: in.PlayerID})
+		v.Check(len(in.Region) <= maxRegionLen, "region", fmt.Sprintf("must not be more than %d characters long", maxRegionLen))
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		current, err := ratings.Get(in.PlayerID)
+		if err != nil {
+			serverErrorResponse(w, r, err)
+			return
+		}
+		id, err := queue.Enqueue(matchmaking.Request{PlayerID: in.PlayerID, Rating: current.Rating, Region: in.Region})
+		if err != nil {
+			switch {
+			case errors.Is(err, matchmaking.ErrAlreadyQueued):
+				conflictResponse(w, r)
+			default:
+				serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		encode(w, http.StatusAccepted, envelope{"id": id, "events_url": "/matchmaking/events?id=" + id}, nil)
+	})
+}
+
+// cancelHandler matchmaking?id=... leave the queue
+func cancelHandler(queue *matchmaking.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := readString(r.URL.Query(), "id", "")
+		if err := queue.Cancel(id); err != nil {
+			notFoundResponse(w, r)
+			return
+		}
+		encode(w, http.StatusOK, envelope{"status": "cancelled"}, nil)
+	})
+}
+
+// matchEventsHandler matchmaking/events?id=... server-sent events, one event with the result
+// (matched, timeout or cancelled) then the stream ends. Comments keep proxies from closing it.
+// A player reconnecting gets the result again until it expires
+func matchEventsHandler(queue *matchmaking.Queue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, err := queue.Listen(readString(r.URL.Query(), "id", ""))
+		if err != nil {
+			notFoundResponse(w, r)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			serverErrorResponse(w, r, errors.New("streaming unsupported"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": queued\n\n")
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case ev := <-events:
+				data, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+				flusher.Flush()
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"tetris-be/internal/game"
+	"tetris-be/internal/matchmaking"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchmaking(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
+	t.Run("two players get the same room and join it with their tickets", func(t *testing.T) {
+		alice := enqueueMatch(t, server.URL, `{"playerID":"alice","region":"eu"}`)
+		bob := enqueueMatch(t, server.URL, `{"playerID":"bob","region":"eu"}`)
+
+		matchA := readMatchEvent(t, server.URL, alice)
+		matchB := readMatchEvent(t, server.URL, bob)
+		assert.Equal(t, "matched", matchA.Type)
+		assert.Equal(t, matchA.Match.RoomID, matchB.Match.RoomID)
+		assert.Equal(t, "bob", matchA.Match.Opponent)
+		assert.Equal(t, "alice", matchB.Match.Opponent)
+		assert.Equal(t, matchA, readMatchEvent(t, server.URL, alice), "a reconnect gets the result again")
+
+		room, err := roomManager.Get(matchA.Match.RoomID)
+		assertNoError(t, err)
+		assert.True(t, room.Key.Locked())
+		assert.True(t, room.Settings.Ranked)
+		for _, m := range []*matchmaking.Match{matchA.Match, matchB.Match} {
+			u, err := url.Parse(m.WsURL)
+			assertNoError(t, err)
+			conn := dialMatch(t, server.URL, u.RawQuery)
+			defer conn.Close()
+			readUntil(t, conn, "session")
+		}
+	})
+	t.Run("cancel", func(t *testing.T) {
+		id := enqueueMatch(t, server.URL, `{"playerID":"carol"}`)
+		resp := postJSON(t, server.URL+"/matchmaking", `{"playerID":"carol"}`)
+		assertStatusCode(t, http.StatusConflict, resp.StatusCode)
+
+		req, _ := http.NewRequest(http.MethodDelete, server.URL+"/matchmaking?id="+id, nil)
+		resp, err := http.DefaultClient.Do(req)
+		assertNoError(t, err)
+		resp.Body.Close()
+		assertStatusCode(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "cancelled", readMatchEvent(t, server.URL, id).Type)
+
+		resp, err = http.DefaultClient.Do(req)
+		assertNoError(t, err)
+		resp.Body.Close()
+		assertStatusCode(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, body := range []string{
+			`{"playerID":""}`,
+			`{"playerID":"dave","region":"` + strings.Repeat("x", 17) + `"}`,
+			`not json`,
+		} {
+			resp := postJSON(t, server.URL+"/matchmaking", body)
+			assertStatusCode(t, http.StatusBadRequest, resp.StatusCode)
+		}
+		resp, err := http.Get(server.URL + "/matchmaking/events?id=nope")
+		assertNoError(t, err)
+		resp.Body.Close()
+		assertStatusCode(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func postJSON(t testing.TB, target string, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(target, "application/json", bytes.NewReader([]byte(body)))
+	assertNoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func enqueueMatch(t testing.TB, serverURL string, body string) string {
+	t.Helper()
+	resp, err := http.Post(serverURL+"/matchmaking", "application/json", strings.NewReader(body))
+	assertNoError(t, err)
+	defer resp.Body.Close()
+	assertStatusCode(t, http.StatusAccepted, resp.StatusCode)
+	var out struct {
+		ID string `json:"id"`
+	}
+	assertNoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	return out.ID
+}
+
+// readMatchEvent the data line of the one event on the stream
+func readMatchEvent(t testing.TB, serverURL string, id string) matchmaking.Event {
+	t.Helper()
+	resp, err := http.Get(serverURL + "/matchmaking/events?id=" + id)
+	assertNoError(t, err)
+	defer resp.Body.Close()
+	assertContentType(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var ev matchmaking.Event
+			assertNoError(t, json.Unmarshal([]byte(data), &ev))
+			return ev
+		}
+	}
+	t.Fatal("stream ended without an event")
+	return matchmaking.Event{}
+}
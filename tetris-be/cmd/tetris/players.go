@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"tetris-be/internal/game"
+	"tetris-be/internal/player"
+	"tetris-be/internal/rating"
+	"tetris-be/internal/validator"
+)
+
+const (
+	defaultLeaderboardSize = 50
+	maxLeaderboardSize     = 100
+	defaultPageSize        = 20
+	maxPageSize            = 100
+)
+
+// onResults fan a match result out to everything that keeps one
+func onResults(fns ...func(game.MatchResult)) func(game.MatchResult) {
+	return func(res game.MatchResult) {
+		for _, fn := range fns {
+			fn(res)
+		}
+	}
+}
+
+// recordMatch history and stats of every match, solo included
+func recordMatch(players *player.Registry) func(game.MatchResult) {
+	return func(res game.MatchResult) {
+		if err := players.Record(res); err != nil {
+			log.Printf("[player][room:%s] cannot record match: %v", res.RoomID, err)
+		}
+	}
+}
+
+// recordResult rate the players of a ranked match, custom rooms and solo don't count
+func recordResult(ratings *rating.Service) func(game.MatchResult) {
+	return func(res game.MatchResult) {
+		if !res.Ranked || res.Winner == "" {
+			return
+		}
+		updated, err := ratings.Record(res.Winner, res.Loser)
+		if err != nil {
+			log.Printf("[rating][room:%s] cannot record %s beating %s: %v", res.RoomID, res.Winner, res.Loser, err)
+			return
+		}
+		log.Printf("[rating][room:%s] %s %.0f, %s %.0f", res.RoomID,
+			res.Winner, updated[res.Winner].Rating, res.Loser, updated[res.Loser].Rating)
+	}
+}
+
+// readPlayerID the {id} of players/{id}/..., same rules as a playerID joining a room
+func readPlayerID(r *http.Request, v *validator.Validator) string {
+	playerID := r.PathValue("id")
+	v.Check(len(playerID) <= 15 && validator.Match(playerID, validator.IdRX), "id", "invalid player id")
+	return playerID
+}
+
+// getPlayerHandler players/{id}, profiles are made on the first match
+func getPlayerHandler(players *player.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		playerID := readPlayerID(r, v)
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		data, err := players.Get(playerID)
+		if err != nil {
+			switch {
+			case errors.Is(err, player.ErrNotFound):
+				notFoundResponse(w, r)
+			default:
+				serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		averages := map[string]float64{"pps": data.Stats.PPS(), "apm": data.Stats.APM()}
+		encode(w, http.StatusOK, envelope{"player": data, "averages": averages}, nil)
+	})
+}
+
+// listMatchesHandler players/{id}/matches?offset=...&limit=..., newest first
+func listMatchesHandler(players *player.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+		playerID := readPlayerID(r, v)
+		offset := readInt(qs, "offset", 0, v)
+		limit := readInt(qs, "limit", defaultPageSize, v)
+		v.Check(offset >= 0, "offset", "must not be negative")
+		v.Check(limit >= 1 && limit <= maxPageSize, "limit", "must be between 1 and 100")
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		data, total, err := players.Matches(playerID, offset, limit)
+		if err != nil {
+			serverErrorResponse(w, r, err)
+			return
+		}
+		if data == nil {
+			data = []player.MatchRecord{}
+		}
+		metadata := map[string]int{"offset": offset, "limit": limit, "total": total}
+		encode(w, http.StatusOK, envelope{"matches": data, "metadata": metadata}, nil)
+	})
+}
+
+// getRatingHandler players/{id}/rating, a player who never played ranked has the default rating
+func getRatingHandler(ratings *rating.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		playerID := readPlayerID(r, v)
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		data, err := ratings.Get(playerID)
+		if err != nil {
+			serverErrorResponse(w, r, err)
+			return
+		}
+		encode(w, http.StatusOK, envelope{"playerID": playerID, "rating": data}, nil)
+	})
+}
+
+// leaderboardHandler leaderboard?offset=...&limit=...
+func leaderboardHandler(ratings *rating.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+		offset := readInt(qs, "offset", 0, v)
+		limit := readInt(qs, "limit", defaultLeaderboardSize, v)
+		v.Check(offset >= 0, "offset", "must not be negative")
+		v.Check(limit >= 1 && limit <= maxLeaderboardSize, "limit", "must be between 1 and 100")
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		data, err := ratings.Leaderboard(offset, limit)
+		if err != nil {
+			serverErrorResponse(w, r, err)
+			return
+		}
+		encode(w, http.StatusOK, envelope{"leaderboard": data}, nil)
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tetris-be/internal/game"
+	"tetris-be/internal/player"
+	"tetris-be/internal/rating"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatings(t *testing.T) {
+	store := rating.NewMemoryStore()
+	server := NewServerHandler(nil, &Config{ratings: store}, newStubRoomManager())
+	ratings := rating.NewService(store)
+	record := recordResult(ratings)
+	record(game.MatchResult{RoomID: "R1", Winner: "alice", Loser: "bob", Ranked: true})
+	record(game.MatchResult{RoomID: "R2", Winner: "bob", Loser: "carol", Ranked: true})
+	record(game.MatchResult{RoomID: "R3", Winner: "carol", Loser: "alice"})
+
+	t.Run("rating of a ranked player", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/alice/rating", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		var body struct {
+			PlayerID string        `json:"playerID"`
+			Rating   rating.Rating `json:"rating"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&body))
+		assert.Equal(t, "alice", body.PlayerID)
+		assert.Greater(t, body.Rating.Rating, float64(rating.DefaultRating))
+		//the unranked loss didn't count
+		assert.Equal(t, 1, body.Rating.Matches)
+	})
+	t.Run("a new player has the default rating", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/dave/rating", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"rating":1500`)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/"+strings.Repeat("x", 16)+"/rating", nil))
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("leaderboard", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/leaderboard?limit=2", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		var body struct {
+			Leaderboard []rating.Standing `json:"leaderboard"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&body))
+		assert.Len(t, body.Leaderboard, 2)
+		assert.Equal(t, "alice", body.Leaderboard[0].PlayerID)
+		assert.Equal(t, 1, body.Leaderboard[0].Rank)
+
+		for _, query := range []string{"limit=0", "limit=101", "offset=-1", "limit=x"} {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/leaderboard?"+query, nil))
+			assertStatusCode(t, http.StatusBadRequest, response.Code)
+		}
+	})
+	t.Run("custom rooms can't be ranked", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newCreateRoomRequest(map[string]any{"playerID": "alice", "settings": map[string]any{"ranked": true}}))
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestPlayers(t *testing.T) {
+	store := player.NewMemoryStore()
+	server := NewServerHandler(nil, &Config{players: store}, newStubRoomManager())
+	record := recordMatch(player.NewRegistry(store))
+	for i := range 3 {
+		record(game.MatchResult{
+			RoomID:  fmt.Sprintf("R%d", i),
+			Mode:    game.ModeVersus,
+			Winner:  "alice",
+			Loser:   "bob",
+			Players: map[string]game.Stats{"alice": {Frames: 1800, Pieces: 120, Attack: 20}, "bob": {Frames: 1800, Pieces: 60}},
+			EndedAt: time.Unix(int64(i), 0),
+		})
+	}
+
+	t.Run("profile", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/alice", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		var body struct {
+			Player   player.Profile     `json:"player"`
+			Averages map[string]float64 `json:"averages"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&body))
+		assert.Equal(t, 3, body.Player.Stats.Wins)
+		assert.InDelta(t, 2.0, body.Averages["pps"], 0.001)
+		assert.InDelta(t, 20.0, body.Averages["apm"], 0.001)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/nobody", nil))
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("match history pages", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/bob/matches?offset=1&limit=1", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		var body struct {
+			Matches  []player.MatchRecord `json:"matches"`
+			Metadata map[string]int       `json:"metadata"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&body))
+		if assert.Len(t, body.Matches, 1) {
+			assert.Equal(t, "R1", body.Matches[0].RoomID)
+		}
+		assert.Equal(t, map[string]int{"offset": 1, "limit": 1, "total": 3}, body.Metadata)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/nobody/matches", nil))
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"matches":[]`)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/players/bob/matches?limit=101", nil))
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("profiles are read only", func(t *testing.T) {
+		body := `{"displayName":"Carol"}`
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPut, "/players/carol", strings.NewReader(body)))
+		assertStatusCode(t, http.StatusMethodNotAllowed, response.Code)
+		_, err := store.Get("carol")
+		assert.ErrorIs(t, err, player.ErrNotFound)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// failureLimiter blocks a client after too many failed attempts inside a fixed window
+type failureLimiter struct {
+	max    int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*failures // map[client ip]
+}
+type failures struct {
+	count int
+	since time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:     max,
+		window:  window,
+		clients: make(map[string]*failures),
+	}
+}
+
+// Blocked return how long the client has to wait, 0 if it can try again
+func (l *failureLimiter) Blocked(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.clients[client]
+	if !ok {
+		return 0
+	}
+	left := l.window - time.Since(f.since)
+	if left <= 0 {
+		delete(l.clients, client)
+		return 0
+	}
+	if f.count < l.max {
+		return 0
+	}
+	return left
+}
+
+func (l *failureLimiter) Fail(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for c, f := range l.clients {
+		if now.Sub(f.since) >= l.window {
+			delete(l.clients, c)
+		}
+	}
+	f, ok := l.clients[client]
+	if !ok {
+		f = &failures{since: now}
+		l.clients[client] = f
+	}
+	f.count++
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
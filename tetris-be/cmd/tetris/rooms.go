@@ -1,20 +1,48 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"tetris-be/internal/auth"
 	"tetris-be/internal/game"
 	"tetris-be/internal/validator"
+	"time"
 )
 
 type input struct {
 	PlayerID string
 	Key      string
+	Settings *game.RoomSettings // only read when creating a room, missing fields take defaults
 }
 
+// getAllRoomsHandler rooms?status=waiting,countdown&mode=...&locked=...&owner=... every room not
+// closed by default
 func getAllRoomsHandler(roomManager game.RoomManager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		data, err := roomManager.GetAllDTO()
+		qs := r.URL.Query()
+		v := validator.New()
+		var filter game.RoomFilter
+		for _, status := range readCSV(qs, "status", nil) {
+			v.Check(slices.Contains(game.RoomStatuses, game.RoomStatus(status)),
+				"status", "must be waiting, countdown, playing, finished or closed")
+			filter.Status = append(filter.Status, game.RoomStatus(status))
+		}
+		filter.Mode = readString(qs, "mode", "")
+		v.Check(filter.Mode == "" || validator.In(filter.Mode, game.ModeVersus, game.ModeSolo), "mode", "must be versus or solo")
+		if locked := readString(qs, "locked", ""); locked != "" {
+			b, err := strconv.ParseBool(locked)
+			v.Check(err == nil, "locked", "must be true or false")
+			filter.Locked = &b
+		}
+		filter.Owner = readString(qs, "owner", "")
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		data, err := roomManager.GetAllDTO(filter)
 		if err != nil {
 			serverErrorResponse(w, r, err)
 			return
@@ -23,19 +51,32 @@ func getAllRoomsHandler(roomManager game.RoomManager) http.Handler {
 	})
 }
 
-func joinRoomHandler(cfg *Config, roomManager game.RoomManager) http.Handler {
+const (
+	maxWrongKeyAttempts = 5
+	wrongKeyWindow      = time.Minute
+)
+
+func joinRoomHandler(cfg *Config, roomManager game.RoomManager, tickets *auth.TicketIssuer, wrongKeys *failureLimiter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//read param
 		roomID := readString(r.URL.Query(), "roomid", "")
 		//read body
 		in, err := decode[input](r)
 		if err != nil {
-			serverErrorResponse(w, r, err)
+			badRequestResponse(w, r, err)
 			return
 		}
 
+		settings := game.DefaultRoomSettings()
+		if in.Settings != nil {
+			settings = *in.Settings
+		}
+
 		v := validator.New()
 		ValidateInput(v, in)
+		if roomID == "" {
+			game.ValidateRoomSettings(v, settings)
+		}
 
 		if !v.Valid() {
 			failedValidationResponse(w, r, v.Errors)
@@ -46,13 +87,23 @@ func joinRoomHandler(cfg *Config, roomManager game.RoomManager) http.Handler {
 		var data game.RoomDTO
 		switch roomID {
 		case "":
-			data, err = roomManager.CreateRoom(in.Key)
+			data, err = roomManager.CreateRoom(in.PlayerID, in.Key, settings)
 		default:
+			client := clientIP(r)
+			if retryAfter := wrongKeys.Blocked(client); retryAfter > 0 {
+				rateLimitExceededResponse(w, r, retryAfter)
+				return
+			}
 			data, err = roomManager.JoinRoom(roomID, in.Key)
+			if errors.Is(err, game.ErrWrongKey) {
+				wrongKeys.Fail(client)
+			}
 		}
 		//catch error
 		if err != nil {
 			switch {
+			case errors.Is(err, game.ErrWrongKey):
+				wrongKeyResponse(w, r)
 			case err.Error() == "not found":
 				notFoundResponse(w, r)
 			case err.Error() == "room is full":
@@ -60,16 +111,79 @@ func joinRoomHandler(cfg *Config, roomManager game.RoomManager) http.Handler {
 			default:
 				serverErrorResponse(w, r, err)
 			}
+			return
+		}
+		ticket, err := tickets.Issue(data.ID, in.PlayerID)
+		if err != nil {
+			serverErrorResponse(w, r, err)
+			return
 		}
 		//send response { wsurl:...,room:...}
-		host := fmt.Sprintf("%s:%d", cfg.host, cfg.port)
-		wsURL := fmt.Sprintf("ws://%s/ws/match?roomid=%s&playerid=%s", host, data.ID, in.PlayerID)
-		encode(w, http.StatusAccepted, envelope{"room": data, "ws_url": wsURL}, nil)
+		encode(w, http.StatusAccepted, envelope{"room": data, "ws_url": matchURL(cfg, data.ID, in.PlayerID, ticket)}, nil)
+	})
+}
+
+// matchURL roomid and playerid are informational only, /ws/match trusts the ticket
+func matchURL(cfg *Config, roomID, playerID, ticket string) string {
+	host := fmt.Sprintf("%s:%d", cfg.host, cfg.port)
+	return fmt.Sprintf("ws://%s/ws/match?roomid=%s&playerid=%s&ticket=%s", host, roomID, playerID, ticket)
+}
+
+type botInput struct {
+	Key        string
+	Difficulty string // one of game.BotDifficulties, normal if empty
+}
+
+// addBotHandler rooms/bots?roomid=... seat a bot played by the server, no ticket: it has no socket
+func addBotHandler(roomManager game.RoomManager, wrongKeys *failureLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roomID := readString(r.URL.Query(), "roomid", "")
+		in, err := decode[botInput](r)
+		if err != nil {
+			badRequestResponse(w, r, err)
+			return
+		}
+		if in.Difficulty == "" {
+			in.Difficulty = "normal"
+		}
+		cfg, ok := game.BotDifficulties[in.Difficulty]
+
+		v := validator.New()
+		v.Check(roomID != "", "roomid", "roomid must be provided")
+		v.Check(ok, "difficulty", "must be easy, normal or hard")
+		v.Check(len(in.Key) <= 32, "key", "must not be more than 32 characters long")
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		client := clientIP(r)
+		if retryAfter := wrongKeys.Blocked(client); retryAfter > 0 {
+			rateLimitExceededResponse(w, r, retryAfter)
+			return
+		}
+		data, err := roomManager.AddBot(roomID, in.Key, cfg)
+		if err != nil {
+			switch {
+			case errors.Is(err, game.ErrWrongKey):
+				wrongKeys.Fail(client)
+				wrongKeyResponse(w, r)
+			case err.Error() == "not found":
+				notFoundResponse(w, r)
+			case err.Error() == "room is full":
+				conflictResponse(w, r)
+			default:
+				serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		encode(w, http.StatusAccepted, envelope{"room": data}, nil)
 	})
 }
 
 func ValidateInput(v *validator.Validator, in input) {
 	v.Check(in.PlayerID != "", "playerID", "playerID must be provided")
 	v.Check(len(in.PlayerID) <= 15, "playerID", "invalid request body")
-	v.Check(len(in.Key) <= 7, "key", "wrong key")
+	v.Check(validator.Match(in.PlayerID, validator.IdRX), "playerID", "must contain only letters, digits, '-' or '_'")
+	v.Check(len(in.Key) <= 32, "key", "must not be more than 32 characters long")
 }
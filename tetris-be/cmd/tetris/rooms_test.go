@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -29,9 +30,54 @@ func TestGetRooms(t *testing.T) {
 		}
 		err := json.NewDecoder(response.Body).Decode(&responseBody)
 		assertNoError(t, err)
-		expectedRooms, _ := stubRoomManager.GetAllDTO()
+		expectedRooms, _ := stubRoomManager.GetAllDTO(game.RoomFilter{})
 		assertRooms(t, expectedRooms, responseBody.Rooms)
 	})
+	t.Run("private rooms are marked locked without the key", func(t *testing.T) {
+		addStubRoom(stubRoomManager, &game.Room{ID: "PUB01"})
+		defer func() {
+			delete(stubRoomManager.Rooms, "PUB01")
+			stubRoomManager.Store.SetStatus("PUB01", game.RoomClosed)
+		}()
+		req := newGetRoomsRequest()
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+
+		assert.NotContains(t, response.Body.String(), "key-")
+		var responseBody struct {
+			Rooms []game.RoomDTO `json:"rooms"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&responseBody))
+		for _, room := range responseBody.Rooms {
+			assert.Equal(t, room.ID != "PUB01", room.Locked, room.ID)
+		}
+	})
+	t.Run("filtered by status and lock", func(t *testing.T) {
+		addStubRoom(stubRoomManager, &game.Room{ID: "PUB02"})
+		defer delete(stubRoomManager.Rooms, "PUB02")
+		stubRoomManager.Store.SetStatus("PUB02", game.RoomPlaying)
+		defer stubRoomManager.Store.SetStatus("PUB02", game.RoomClosed)
+
+		for query, want := range map[string]int{"status=playing": 1, "locked=true": 3, "status=waiting&locked=false": 0, "status=closed": 0} {
+			req := httptest.NewRequest(http.MethodGet, "/rooms?"+query, nil)
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, req)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			var responseBody struct {
+				Rooms []game.RoomDTO `json:"rooms"`
+			}
+			assertNoError(t, json.NewDecoder(response.Body).Decode(&responseBody))
+			assert.Len(t, responseBody.Rooms, want, query)
+		}
+	})
+	t.Run("unknown filter values", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/rooms?status=playing,gone&locked=maybe", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "status")
+		assert.Contains(t, response.Body.String(), "locked")
+	})
 }
 func TestJoinRoom(t *testing.T) {
 	stubRoomManager := newStubRoomManager()
@@ -43,6 +89,7 @@ func TestJoinRoom(t *testing.T) {
 			Key      string
 		}{
 			PlayerID: "player-x",
+			Key:      "key-1",
 		}
 		roomID := "ABC12"
 		req := newJoinRoomRequest(in, roomID)
@@ -61,6 +108,8 @@ func TestJoinRoom(t *testing.T) {
 			Players: []game.PlayerDTO{
 				{ID: "anon123"},
 			},
+			Locked:   true,
+			Settings: game.DefaultRoomSettings(),
 		}
 		assertRoom(t, expectedRoom, responseBody.Room)
 
@@ -89,12 +138,43 @@ func TestJoinRoom(t *testing.T) {
 		expectedRoomID := "12345"
 		assertRoomID(t, expectedRoomID, responseBody.Room.ID)
 	})
+	t.Run("create room with settings", func(t *testing.T) {
+		body := `{"playerID":"player-x","settings":{"mode":"solo","capacity":1,"lockDelay":500,"attackTable":"guideline"}}`
+		req := newCreateRoomRequest(json.RawMessage(body))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, http.StatusAccepted, response.Code)
+
+		var responseBody struct {
+			Room game.RoomDTO `json:"room"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&responseBody))
+		want := game.DefaultRoomSettings()
+		want.Mode, want.Capacity, want.LockDelay, want.AttackTable = game.ModeSolo, 1, 500, game.AttackGuideline
+		assert.Equal(t, want, responseBody.Room.Settings)
+	})
+	t.Run("create room with invalid settings", func(t *testing.T) {
+		body := `{"playerID":"player-x","settings":{"capacity":5,"randomizer":"bag14","gravity":0}}`
+		req := newCreateRoomRequest(json.RawMessage(body))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+
+		var responseBody struct {
+			Error map[string]string `json:"error"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&responseBody))
+		for _, field := range []string{"settings.capacity", "settings.randomizer", "settings.gravity"} {
+			assert.Contains(t, responseBody.Error, field)
+		}
+	})
 	t.Run("join into a full room", func(t *testing.T) {
 		in := struct {
 			PlayerID string
 			Key      string
 		}{
 			PlayerID: "player-x",
+			Key:      "key-2",
 		}
 		roomID := "DEF34"
 		req := newJoinRoomRequest(in, roomID)
@@ -115,6 +195,40 @@ func TestJoinRoom(t *testing.T) {
 		server.ServeHTTP(response, req)
 		assertStatusCode(t, http.StatusNotFound, response.Code)
 	})
+	t.Run("join with wrong key", func(t *testing.T) {
+		in := struct {
+			PlayerID string
+			Key      string
+		}{
+			PlayerID: "player-x",
+			Key:      "key-2",
+		}
+		req := newJoinRoomRequest(in, "XYZ00")
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assert.Contains(t, response.Body.String(), "the room key is incorrect")
+	})
+	t.Run("too many wrong key attempts", func(t *testing.T) {
+		server := NewServerHandler(nil, cfg, stubRoomManager)
+		in := struct {
+			PlayerID string
+			Key      string
+		}{
+			PlayerID: "player-x",
+			Key:      "guess",
+		}
+		for range maxWrongKeyAttempts {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newJoinRoomRequest(in, "XYZ00"))
+			assertStatusCode(t, http.StatusForbidden, response.Code)
+		}
+		in.Key = "key-3"
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newJoinRoomRequest(in, "XYZ00"))
+		assertStatusCode(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
 	t.Run("request missing playerid", func(t *testing.T) {
 		in := struct {
 			PlayerID string
@@ -128,35 +242,117 @@ func TestJoinRoom(t *testing.T) {
 	})
 }
 
+func TestAddBot(t *testing.T) {
+	stubRoomManager := newStubRoomManager()
+	server := NewServerHandler(nil, nil, stubRoomManager)
+	room, err := stubRoomManager.CreateRoom("", "key-b", game.DefaultRoomSettings())
+	assertNoError(t, err)
+
+	t.Run("bot takes a seat", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newAddBotRequest(`{"key":"key-b","difficulty":"hard"}`, room.ID))
+		assertStatusCode(t, http.StatusAccepted, response.Code)
+
+		var responseBody struct {
+			Room game.RoomDTO `json:"room"`
+		}
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&responseBody))
+		if assert.Len(t, responseBody.Room.Players, 1) {
+			assert.True(t, strings.HasPrefix(responseBody.Room.Players[0].ID, "bot-"))
+		}
+	})
+	t.Run("no seat left", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newAddBotRequest(`{"key":"key-2"}`, "DEF34"))
+		assertStatusCode(t, http.StatusConflict, response.Code)
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newAddBotRequest(`{"key":"key-1"}`, room.ID))
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+	})
+	t.Run("wrong keys count across join and bot", func(t *testing.T) {
+		server := NewServerHandler(nil, nil, stubRoomManager)
+		for range maxWrongKeyAttempts {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newAddBotRequest(`{"key":"guess"}`, room.ID))
+			assertStatusCode(t, http.StatusForbidden, response.Code)
+		}
+		in := struct {
+			PlayerID string
+			Key      string
+		}{
+			PlayerID: "player-x",
+			Key:      "key-b",
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newJoinRoomRequest(in, room.ID))
+		assertStatusCode(t, http.StatusTooManyRequests, response.Code)
+	})
+	t.Run("non-exists room", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newAddBotRequest(`{}`, "DEF00"))
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("unknown difficulty", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newAddBotRequest(`{"key":"key-b","difficulty":"godlike"}`, room.ID))
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "difficulty")
+	})
+}
+
+func newAddBotRequest(body string, roomID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/rooms/bots?roomid=%s", roomID), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
 func newStubRoomManager() *game.InMemoryRoomManager {
 	stubRoomManager := game.NewInMemoryRoomManager()
-	rooms := []game.Room{
+	rooms := []*game.Room{
 		{
-			ID:  "ABC12",
-			Key: "key-1",
-			PlayerConns: map[*game.PlayerConn]bool{
-				{ID: "anon123"}: true,
+			ID:       "ABC12",
+			Key:      mustHashKey("key-1"),
+			Settings: game.DefaultRoomSettings(),
+			PlayerConns: map[string]*game.PlayerConn{
+				"anon123": {ID: "anon123"},
 			},
 		},
 		{
-			ID:  "DEF34",
-			Key: "key-2",
-			PlayerConns: map[*game.PlayerConn]bool{
-				{ID: "player-2"}: true,
-				{ID: "player-3"}: true,
+			ID:       "DEF34",
+			Key:      mustHashKey("key-2"),
+			Settings: game.DefaultRoomSettings(),
+			PlayerConns: map[string]*game.PlayerConn{
+				"player-2": {ID: "player-2"},
+				"player-3": {ID: "player-3"},
 			},
 		},
 		{
 			ID:          "XYZ00",
-			Key:         "key-3",
-			PlayerConns: make(map[*game.PlayerConn]bool), // empty room
+			Key:         mustHashKey("key-3"),
+			Settings:    game.DefaultRoomSettings(),
+			PlayerConns: make(map[string]*game.PlayerConn), // empty room
 		},
 	}
 	for _, room := range rooms {
-		stubRoomManager.Rooms[room.ID] = &room
+		addStubRoom(stubRoomManager, room)
 	}
 	return stubRoomManager
 }
+
+// addStubRoom a room without its goroutine, listed like a stored one
+func addStubRoom(m *game.InMemoryRoomManager, room *game.Room) {
+	m.Rooms[room.ID] = room
+	m.Store.Save(game.RoomRecord{ID: room.ID, Key: room.Key, Settings: room.Settings, Status: game.RoomWaiting})
+}
+func mustHashKey(key string) game.RoomKey {
+	k, err := game.HashRoomKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
 func newCreateRoomRequest(data interface{}) *http.Request {
 	body, _ := json.Marshal(data)
 	req := httptest.NewRequest(http.MethodPost, "/rooms", bytes.NewReader(body))
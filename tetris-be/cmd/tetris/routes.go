@@ -3,7 +3,11 @@ package main
 import (
 	"log/slog"
 	"net/http"
+	"tetris-be/internal/auth"
 	"tetris-be/internal/game"
+	"tetris-be/internal/matchmaking"
+	"tetris-be/internal/player"
+	"tetris-be/internal/rating"
 )
 
 func addRoutes(
@@ -11,15 +15,39 @@ func addRoutes(
 	logger *slog.Logger,
 	config *Config,
 	roomManager game.RoomManager,
+	tickets *auth.TicketIssuer,
+	queue *matchmaking.Queue,
+	ratings *rating.Service,
+	players *player.Registry,
 ) http.Handler {
 
 	mux.HandleFunc("GET /healthcheck", healthcheck)
 
+	//shared, wrong keys on /rooms/bots count against /rooms too
+	wrongKeys := newFailureLimiter(maxWrongKeyAttempts, wrongKeyWindow)
+
 	//rooms?roomid=... to join an existing room. If the parameter is missing, a new room will be created
 	mux.Handle("GET /rooms", getAllRoomsHandler(roomManager))
-	mux.Handle("POST /rooms", joinRoomHandler(config, roomManager))
+	mux.Handle("POST /rooms", joinRoomHandler(config, roomManager, tickets, wrongKeys))
+	//rooms/bots?roomid=... body {key, difficulty}, a bot takes a seat like a player joining
+	mux.Handle("POST /rooms/bots", addBotHandler(roomManager, wrongKeys))
+
+	//matchmaking body {playerID, region}. The room and tickets of the pair come as a
+	//server-sent event on matchmaking/events?id=..., DELETE matchmaking?id=... leaves the queue
+	mux.Handle("POST /matchmaking", enqueueHandler(queue, ratings))
+	mux.Handle("DELETE /matchmaking", cancelHandler(queue))
+	mux.Handle("GET /matchmaking/events", matchEventsHandler(queue))
+
+	//profiles and history, matches?offset=...&limit=.... No write route until players can prove who they are
+	mux.Handle("GET /players/{id}", getPlayerHandler(players))
+	mux.Handle("GET /players/{id}/matches", listMatchesHandler(players))
+	//ratings change with ranked matches only, those made by matchmaking
+	mux.Handle("GET /players/{id}/rating", getRatingHandler(ratings))
+	//leaderboard?offset=...&limit=...
+	mux.Handle("GET /leaderboard", leaderboardHandler(ratings))
 
-	mux.Handle("GET /ws/match", serveWs(roomManager))
+	//ws/match?ticket=... ticket is issued by POST /rooms
+	mux.Handle("GET /ws/match", serveWs(roomManager, tickets))
 
 	return mux
 }
@@ -10,19 +10,62 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"tetris-be/internal/auth"
 	"tetris-be/internal/game"
+	"tetris-be/internal/matchmaking"
+	"tetris-be/internal/player"
+	"tetris-be/internal/rating"
 	"time"
 
 	"net/http"
 )
 
+const defaultTicketTTL = 30 * time.Second
+
 func run(ctx context.Context) error {
 
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM|syscall.SIGINT)
 	defer cancel()
 
 	cfg := LoadConfig()
+	if cfg.ratingsFile != "" {
+		store, err := rating.OpenFileStore(cfg.ratingsFile)
+		if err != nil {
+			return err
+		}
+		cfg.ratings = store
+	}
+	if cfg.playersDir != "" {
+		store, err := player.OpenFileStore(cfg.playersDir)
+		if err != nil {
+			return err
+		}
+		cfg.players = store
+	}
 	roomStorage := game.NewInMemoryRoomManager()
+	if cfg.roomsDB != "" {
+		store, err := game.OpenBoltRoomStore(cfg.roomsDB)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		//the matches died with the last process, their rooms wait for players again
+		if err := game.ResetRooms(store); err != nil {
+			return err
+		}
+		roomStorage.Store = store
+	}
+	roomStorage.Reconnect = game.ReconnectConfig{
+		Grace:     cfg.reconnectGrace,
+		PauseGame: cfg.pauseOnDisconnect,
+	}
+	roomStorage.AntiCheat = game.AntiCheatConfig{
+		FlagAfter: cfg.cheatFlagAfter,
+		Forfeit:   cfg.cheatForfeit,
+	}
+	if cfg.roomIdleTTL > 0 {
+		go roomStorage.RunJanitor(ctx, cfg.roomIdleTTL)
+	}
 	serverHandler := NewServerHandler(logger, cfg, roomStorage)
 
 	//v1 := http.NewServeMux()
@@ -57,12 +100,59 @@ func run(ctx context.Context) error {
 type Config struct {
 	host string
 	port int
+	// reconnect grace window for dropped websockets in a running match
+	reconnectGrace    time.Duration
+	pauseOnDisconnect bool
+	// HMAC secret and lifetime of websocket join tickets
+	ticketSecret string
+	ticketTTL    time.Duration
+	// violations before a player is flagged, and whether flagged players forfeit
+	cheatFlagAfter int
+	cheatForfeit   bool
+	// how long a player waits in the matchmaking queue before giving up
+	queueTimeout time.Duration
+	// JSON file the ratings are kept in, opened into ratings by run. In memory if nil
+	ratingsFile string
+	ratings     rating.Store
+	// directory of the profiles and match history, opened into players by run. In memory if empty
+	playersDir string
+	players    player.Store
+	// bbolt file the room metadata is kept in, rooms are rehydrated from it on demand. In memory if empty
+	roomsDB string
+	// rooms without a join, leave or message for this long are closed, 0 keeps them forever
+	roomIdleTTL time.Duration
 }
 
 func NewServerHandler(logger *slog.Logger, config *Config, roomManager game.RoomManager) http.Handler {
 	mux := http.NewServeMux()
+	if config == nil {
+		config = &Config{}
+	}
+	ttl := config.ticketTTL
+	if ttl == 0 {
+		ttl = defaultTicketTTL
+	}
+	tickets := auth.NewTicketIssuer([]byte(config.ticketSecret), ttl)
+	queueCfg := matchmaking.DefaultConfig
+	if config.queueTimeout > 0 {
+		queueCfg.Timeout = config.queueTimeout
+	}
+	//a match nobody picked up is useless once its tickets expired
+	queueCfg.Linger = ttl
+	queue := matchmaking.NewQueue(queueCfg, nil, pairPlayers(config, roomManager, tickets))
+	store := config.ratings
+	if store == nil {
+		store = rating.NewMemoryStore()
+	}
+	ratings := rating.NewService(store)
+	playerStore := config.players
+	if playerStore == nil {
+		playerStore = player.NewMemoryStore()
+	}
+	players := player.NewRegistry(playerStore)
+	roomManager.OnResult(onResults(recordResult(ratings), recordMatch(players)))
 
-	addRoutes(mux, logger, config, roomManager)
+	addRoutes(mux, logger, config, roomManager, tickets, queue, ratings, players)
 
 	return mux
 }
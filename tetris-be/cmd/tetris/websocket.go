@@ -4,7 +4,9 @@ import (
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"tetris-be/internal/auth"
 	"tetris-be/internal/game"
+	"tetris-be/internal/validator"
 )
 
 const (
@@ -14,6 +16,8 @@ const (
 )
 
 var upgrader = websocket.Upgrader{
+	//binary codec when the client asks for it, JSON otherwise
+	Subprotocols: game.Subprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 		//origin := r.Header.Get("Origin")
@@ -26,15 +30,33 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func serveWs(roomManager game.RoomManager) http.Handler {
+func serveWs(roomManager game.RoomManager, tickets *auth.TicketIssuer) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		roomID := readString(r.URL.Query(), "roomid", "")
-		playerID := readString(r.URL.Query(), "playerid", "")
-		//TODO validate params
-		err := roomManager.CreateMockRoom(roomID)
-		if err != nil {
+		qs := r.URL.Query()
+		roomID := readString(qs, "roomid", "")
+		playerID := readString(qs, "playerid", "")
+		//resume=<token>&frame=<last acked frame> reattach into a running match,
+		//the resume token is checked by the room against the one issued on first join
+		resumeToken := readString(qs, "resume", "")
+		v := validator.New()
+		ackFrame := readInt(qs, "frame", 0, v)
+		if resumeToken == "" {
+			//fresh join: identity comes from the signed ticket only, never from the query string
+			ticket, err := tickets.Redeem(readString(qs, "ticket", ""))
+			if err != nil {
+				invalidTicketResponse(w, r, err)
+				return
+			}
+			v.Check(roomID == "" || roomID == ticket.RoomID, "roomid", "does not match ticket")
+			v.Check(playerID == "" || playerID == ticket.PlayerID, "playerid", "does not match ticket")
+			roomID, playerID = ticket.RoomID, ticket.PlayerID
+		}
+		v.Check(roomID != "", "roomid", "must be provided")
+		v.Check(playerID != "", "playerid", "must be provided")
+		if !v.Valid() {
+			failedValidationResponse(w, r, v.Errors)
 			return
-		} //test only should delete this room id = ABC12
+		}
 		room, err := roomManager.Get(roomID)
 		if err != nil {
 			notFoundResponse(w, r)
@@ -47,6 +69,9 @@ func serveWs(roomManager game.RoomManager) http.Handler {
 			return
 		}
 		playerConn := game.NewPlayerConn(playerID, room, conn)
+		if resumeToken != "" {
+			playerConn.SetResume(resumeToken, ackFrame)
+		}
 		roomManager.AddPlayer(playerConn)
 
 		go playerConn.Read()
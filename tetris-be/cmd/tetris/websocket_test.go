@@ -1,34 +1,171 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"tetris-be/internal/game"
+	"tetris-be/internal/loadtest"
+	"time"
 )
 
 func TestWebsocketConnection(t *testing.T) {
-	stubRoomManager := newStubRoomManager()
-	server := NewServerHandler(nil, nil, stubRoomManager)
+	roomManager := game.NewInMemoryRoomManager()
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
 	t.Run("successful connection", func(t *testing.T) {
-		roomID := "ABC12"
-		playerID := "anon123"
-		req := newWsRequest(roomID, playerID)
+		room, query := requestTicket(t, server.URL, "", "anon123")
+		conn := dialMatch(t, server.URL, query)
+		defer conn.Close()
+		session := readUntil(t, conn, "session")
+
+		assert.Equal(t, "anon123", session.PlayerId)
+		joined, err := roomManager.Get(room.ID)
+		assertNoError(t, err)
+		assert.Contains(t, joined.Players(), "anon123")
+	})
+	t.Run("binary subprotocol", func(t *testing.T) {
+		_, query := requestTicket(t, server.URL, "", "anon123")
+		dialer := websocket.Dialer{Subprotocols: []string{game.SubprotocolBinary, game.SubprotocolJSON}}
+		conn, _, err := dialer.Dial(wsURL(server.URL, query), nil)
+		assertNoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, game.SubprotocolBinary, conn.Subprotocol())
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		frameType, raw, err := conn.ReadMessage()
+		assertNoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, frameType)
+		session, err := game.BinaryCodec{}.Decode(raw)
+		assertNoError(t, err)
+		assert.Equal(t, "session", session.Type)
+		assert.Equal(t, "anon123", session.PlayerId)
+	})
+	t.Run("malformed message gets an error", func(t *testing.T) {
+		_, query := requestTicket(t, server.URL, "", "anon123")
+		conn := dialMatch(t, server.URL, query)
+		defer conn.Close()
+		readUntil(t, conn, "session")
+
+		assertNoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"inputs","payload":`)))
+		assert.Equal(t, "malformed message", readUntil(t, conn, "error").Error)
+	})
+	t.Run("reject connection without ticket", func(t *testing.T) {
+		room, _ := requestTicket(t, server.URL, "", "anon123")
+		req := newWsRequest(room.ID, "anon123")
 		response := httptest.NewRecorder()
-		server.ServeHTTP(response, req)
+		NewServerHandler(nil, nil, roomManager).ServeHTTP(response, req)
+
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+	t.Run("reject reused ticket", func(t *testing.T) {
+		_, query := requestTicket(t, server.URL, "", "anon123")
+		conn := dialMatch(t, server.URL, query)
+		defer conn.Close()
 
-		totalPlayers := len(stubRoomManager.Rooms[roomID].PlayerConns)
-		if totalPlayers == 0 {
-			t.Errorf("got %d want 1", totalPlayers)
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL(server.URL, query), nil)
+		if err == nil {
+			t.Fatal("expected second dial with the same ticket to fail")
 		}
+		assertStatusCode(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+	t.Run("reject ticket of other player", func(t *testing.T) {
+		room, query := requestTicket(t, server.URL, "", "anon123")
+		query = strings.Replace(query, "playerid=anon123", "playerid=anon456", 1)
 
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL(server.URL, query), nil)
+		if err == nil {
+			t.Fatal("expected dial with mismatched playerid to fail")
+		}
+		assertStatusCode(t, http.StatusBadRequest, resp.StatusCode)
+		if r, err := roomManager.Get(room.ID); err == nil {
+			assert.NotContains(t, r.Players(), "anon456")
+		}
 	})
+	t.Run("reject expired ticket", func(t *testing.T) {
+		expired := httptest.NewServer(NewServerHandler(nil, &Config{ticketTTL: -time.Second}, roomManager))
+		defer expired.Close()
+		_, query := requestTicket(t, expired.URL, "", "anon123")
 
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL(expired.URL, query), nil)
+		if err == nil {
+			t.Fatal("expected dial with expired ticket to fail")
+		}
+		assertStatusCode(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestReconnectResume(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	roomManager.Reconnect = game.ReconnectConfig{Grace: 5 * time.Second, PauseGame: true}
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
+	t.Run("player reattach with resume token and receive snapshot", func(t *testing.T) {
+		room, query := requestTicket(t, server.URL, "", "player-1")
+		roomID := room.ID
+		p1 := dialMatch(t, server.URL, query)
+		session := readUntil(t, p1, "session")
+		_, query = requestTicket(t, server.URL, roomID, "player-2")
+		p2 := dialMatch(t, server.URL, query)
+		defer p2.Close()
+		readUntil(t, p2, "session")
+
+		assertNoError(t, p1.WriteJSON(game.NewMessage("ready")))
+		readUntil(t, p1, "start")
+		assertNoError(t, p1.WriteJSON(game.NewMessage("start")))
+		readUntil(t, p2, "opponent")
+
+		p1.Close()
+		assert.Equal(t, "player-1", readUntil(t, p2, "disconnected").PlayerId)
+
+		query = fmt.Sprintf("roomid=%s&playerid=player-1&resume=%s&frame=0", roomID, session.Payload.ResumeToken)
+		p1 = dialMatch(t, server.URL, query)
+		defer p1.Close()
+		resume := readUntil(t, p1, "resume")
+		assert.NotEmpty(t, resume.Payload.ListBlock)
+		assert.Len(t, resume.Payload.BoardState.Board, game.BOARD_HEIGHT)
+		assert.Equal(t, "player-1", readUntil(t, p2, "reconnected").PlayerId)
+
+		//a client back in the lobby screen says ready again, the match keeps going
+		assertNoError(t, p1.WriteJSON(game.NewMessage("ready")))
+		assert.Equal(t, "match in progress", readUntil(t, p1, "start").Error)
+		live, err := roomManager.Get(roomID)
+		assertNoError(t, err)
+		assert.Equal(t, game.RoomPlaying, live.Status())
+	})
+	t.Run("wrong resume token is rejected", func(t *testing.T) {
+		room, query := requestTicket(t, server.URL, "", "player-1")
+		roomID := room.ID
+		p1 := dialMatch(t, server.URL, query)
+		readUntil(t, p1, "session")
+		_, query = requestTicket(t, server.URL, roomID, "player-2")
+		p2 := dialMatch(t, server.URL, query)
+		defer p2.Close()
+		readUntil(t, p2, "session")
+		assertNoError(t, p1.WriteJSON(game.NewMessage("ready")))
+		readUntil(t, p1, "start")
+		assertNoError(t, p1.WriteJSON(game.NewMessage("start")))
+		p1.Close()
+		readUntil(t, p2, "disconnected")
+
+		intruder := dialMatch(t, server.URL, fmt.Sprintf("roomid=%s&playerid=player-1&resume=guess", roomID))
+		defer intruder.Close()
+		intruder.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := intruder.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+			t.Errorf("expected connection to be closed, got %v", err)
+		}
+	})
 }
 
 func TestSendAndReceiveMessage(t *testing.T) {
@@ -68,7 +205,7 @@ func mockConnHandler() (*websocket.Conn, func()) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		serverConn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			fmt.Errorf("upgrade fail %d", err)
+			fmt.Printf("upgrade fail %v\n", err)
 			return
 		}
 		go func() {
@@ -100,13 +237,307 @@ func mockConnHandler() (*websocket.Conn, func()) {
 	return clientConn, cleanup
 }
 
+// requestTicket join (or create when roomID is empty) a room over POST /rooms and return the ws_url query
+func requestTicket(t testing.TB, serverURL string, roomID string, playerID string) (game.RoomDTO, string) {
+	t.Helper()
+	body, _ := json.Marshal(input{PlayerID: playerID})
+	resp, err := http.Post(serverURL+"/rooms?roomid="+roomID, "application/json", bytes.NewReader(body))
+	assertNoError(t, err)
+	defer resp.Body.Close()
+	assertStatusCode(t, http.StatusAccepted, resp.StatusCode)
+	var responseBody struct {
+		Room  game.RoomDTO `json:"room"`
+		WsURL string       `json:"ws_url"`
+	}
+	assertNoError(t, json.NewDecoder(resp.Body).Decode(&responseBody))
+	u, err := url.Parse(responseBody.WsURL)
+	assertNoError(t, err)
+	return responseBody.Room, u.RawQuery
+}
+func wsURL(serverURL string, query string) string {
+	return "ws" + strings.TrimPrefix(serverURL, "http") + "/ws/match?" + query
+}
+func dialMatch(t testing.TB, serverURL string, query string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(serverURL, query), nil)
+	assertNoError(t, err)
+	return conn
+}
+
+// readUntil skip every message until one of msgType arrives
+func readUntil(t testing.TB, conn *websocket.Conn, msgType string) game.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var msg game.Message
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", msgType, err)
+		}
+		assertNoError(t, json.Unmarshal(raw, &msg))
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
 func TestConcurrentJoinSameRoom(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
 	t.Run("Test race condition when 3rd player join room", func(t *testing.T) {
-
+		//tickets are all issued before anyone is seated, the room itself must turn the 3rd one away
+		room, query := requestTicket(t, server.URL, "", "player-1")
+		queries := []string{query}
+		for _, pId := range []string{"player-2", "player-3"} {
+			_, query := requestTicket(t, server.URL, room.ID, pId)
+			queries = append(queries, query)
+		}
+		var read, closed sync.WaitGroup
+		release := make(chan struct{})
+		first := make([]game.Message, len(queries))
+		for i, query := range queries {
+			read.Add(1)
+			closed.Add(1)
+			go func() {
+				defer closed.Done()
+				conn, _, err := websocket.DefaultDialer.Dial(wsURL(server.URL, query), nil)
+				if err != nil {
+					read.Done()
+					t.Errorf("dial: %v", err)
+					return
+				}
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+				if err := conn.ReadJSON(&first[i]); err != nil {
+					t.Errorf("read: %v", err)
+				}
+				read.Done()
+				<-release //hold the seat until every player got an answer
+			}()
+		}
+		read.Wait()
+		close(release)
+		closed.Wait()
+		types := map[string]int{}
+		for _, msg := range first {
+			types[msg.Type]++
+			if msg.Type == "error" {
+				assert.Equal(t, "room is full", msg.Error)
+			}
+		}
+		assert.Equal(t, map[string]int{"session": 2, "error": 1}, types)
 	})
 }
+func TestPlayAgainstBot(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
+
+	room, query := requestTicket(t, server.URL, "", "player-1")
+	conn := dialMatch(t, server.URL, query)
+	defer conn.Close()
+	readUntil(t, conn, "session")
+	resp, err := http.Post(server.URL+"/rooms/bots?roomid="+room.ID, "application/json", strings.NewReader(`{"difficulty":"hard"}`))
+	assertNoError(t, err)
+	var responseBody struct {
+		Room game.RoomDTO `json:"room"`
+	}
+	assertNoError(t, json.NewDecoder(resp.Body).Decode(&responseBody))
+	resp.Body.Close()
+	assertStatusCode(t, http.StatusAccepted, resp.StatusCode)
+	botId := responseBody.Room.Players[len(responseBody.Room.Players)-1].ID
+
+	assertNoError(t, conn.WriteJSON(game.NewMessage("ready")))
+	start := readUntil(t, conn, "start")
+	assert.Empty(t, start.Error)
+	assertNoError(t, conn.WriteJSON(game.NewMessage("start")))
+
+	//the bot's board is pushed to us, wait for its first locked piece
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		msg := readUntil(t, conn, "opponent")
+		if msg.PlayerId != botId {
+			continue
+		}
+		for _, row := range msg.Payload.BoardState.Board {
+			for _, cell := range row {
+				if cell != 0 {
+					return
+				}
+			}
+		}
+	}
+	t.Fatal("bot did not place a piece")
+}
+func TestRoomJanitor(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	roomManager.Reconnect = game.ReconnectConfig{Grace: time.Minute, PauseGame: true}
+	clock := game.NewManualClock(time.Now())
+	roomManager.Clock = clock
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
+	baseline := runtime.NumGoroutine()
+
+	//nobody ever connects to this one
+	empty, _ := requestTicket(t, server.URL, "", "player-2")
+	room, query := requestTicket(t, server.URL, "", "player-1")
+	conn := dialMatch(t, server.URL, query)
+	defer conn.Close()
+	readUntil(t, conn, "session")
+	resp, err := http.Post(server.URL+"/rooms/bots?roomid="+room.ID, "application/json", strings.NewReader(`{}`))
+	assertNoError(t, err)
+	resp.Body.Close()
+	assertStatusCode(t, http.StatusAccepted, resp.StatusCode)
+
+	live, err := roomManager.Get(room.ID)
+	assertNoError(t, err)
+	assert.Equal(t, game.RoomWaiting, live.Status())
+	assertNoError(t, conn.WriteJSON(game.NewMessage("ready")))
+	readUntil(t, conn, "start")
+	assert.Equal(t, game.RoomCountdown, live.Status())
+	assertNoError(t, conn.WriteJSON(game.NewMessage("start")))
+	clock.Advance(time.Minute)
+	assert.Eventually(t, func() bool { return clock.Tickers() == 2 }, 3*time.Second, time.Millisecond)
+	assert.Equal(t, game.RoomPlaying, live.Status(), "once startAt is reached")
+
+	//the player walks away mid match: the seat is held, the paused loops still have their goroutines
+	conn.Close()
+	assert.Eventually(t, func() bool { return clock.Tickers() == 0 }, 3*time.Second, time.Millisecond)
+	assert.Zero(t, roomManager.Reap(time.Minute), "not idle long enough")
+	clock.Advance(time.Minute + time.Second)
+	assert.Equal(t, 2, roomManager.Reap(time.Minute))
+
+	for _, id := range []string{empty.ID, room.ID} {
+		assert.Eventually(t, func() bool {
+			_, err := roomManager.Get(id)
+			return err != nil
+		}, 3*time.Second, time.Millisecond, id)
+	}
+	//the memory store forgets closed rooms
+	closed, err := roomManager.GetAllDTO(game.RoomFilter{Status: []game.RoomStatus{game.RoomClosed}})
+	assertNoError(t, err)
+	assert.Empty(t, closed)
+	//not Eventually, it counts as a goroutine itself
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		http.DefaultClient.CloseIdleConnections()
+		server.CloseClientConnections()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines leaked:\n%s", n-baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
 func TestHandleHighTraffic(t *testing.T) {
+	roomManager := game.NewInMemoryRoomManager()
+	clock := game.NewManualClock(time.Now())
+	roomManager.Clock = clock
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, roomManager))
+	defer server.Close()
+
+	room, query := requestTicket(t, server.URL, "", "player-1")
+	p1 := dialMatch(t, server.URL, query)
+	defer p1.Close()
+	readUntil(t, p1, "session")
+	_, query = requestTicket(t, server.URL, room.ID, "player-2")
+	p2 := dialMatch(t, server.URL, query)
+	defer p2.Close()
+	readUntil(t, p2, "session")
+	assertNoError(t, p1.WriteJSON(game.NewMessage("ready")))
+	delay := readUntil(t, p1, "start").Payload.InputDelay
+	readUntil(t, p2, "start")
+	assertNoError(t, p1.WriteJSON(game.NewMessage("start")))
+	//loops wait for startAt on the manual clock, then tick only when told to
+	clock.Advance(time.Minute)
+	assert.Eventually(t, func() bool { return clock.Tickers() == 2 }, 3*time.Second, time.Millisecond)
 
+	type stats struct {
+		mu       sync.Mutex
+		acked    int
+		messages int
+		errors   []string
+		closed   bool // the server dropped us, e.g. our outbound queue was full
+	}
+	conns := []*websocket.Conn{p1, p2}
+	results := []*stats{{}, {}}
+	for i, conn := range conns {
+		go func() {
+			s := results[i]
+			for {
+				var msg game.Message
+				if err := conn.ReadJSON(&msg); err != nil {
+					s.mu.Lock()
+					s.closed = true
+					s.mu.Unlock()
+					return
+				}
+				s.mu.Lock()
+				s.messages++
+				switch msg.Type {
+				case "input-server":
+					s.acked = max(s.acked, msg.Payload.Seq)
+				case "error", "resync", "gameover":
+					s.errors = append(s.errors, msg.Type+" "+msg.Error)
+				}
+				s.mu.Unlock()
+			}
+		}()
+	}
+	acked := func(s *stats) int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.acked
+	}
+	//every frame both clients send a batch, an input and the previous one resent as if unacked.
+	//clients keep up to 4 batches in flight, the server ticks regardless of them
+	const frames, inFlight = 600, 4
+	keys := [][]string{{"left"}, {"right"}}
+	for frame := 1; frame <= frames; frame++ {
+		for i, conn := range conns {
+			msg := game.NewMessage("inputs")
+			msg.Payload.Seq = frame
+			msg.Payload.LatestFrame = frame + delay
+			msg.Payload.Inputs = []game.Input{
+				{Frame: frame + delay - 1, Keys: keys[(frame-1)%2]},
+				{Frame: frame + delay, Keys: keys[frame%2]},
+			}
+			assertNoError(t, conn.WriteJSON(msg))
+			if !assert.Eventually(t, func() bool { return acked(results[i]) >= frame-inFlight }, 3*time.Second, time.Millisecond) {
+				return
+			}
+		}
+		clock.Tick()
+	}
+	for _, s := range results {
+		assert.Eventually(t, func() bool { return acked(s) == frames }, 3*time.Second, time.Millisecond)
+		s.mu.Lock()
+		assert.Empty(t, s.errors)
+		assert.Greater(t, s.messages, frames)
+		assert.False(t, s.closed)
+		s.mu.Unlock()
+	}
+}
+func TestLoadTest(t *testing.T) {
+	server := httptest.NewServer(NewServerHandler(nil, &Config{}, game.NewInMemoryRoomManager()))
+	defer server.Close()
+
+	report, err := loadtest.Run(context.Background(), loadtest.Config{
+		URL:           server.URL,
+		Clients:       6,
+		Duration:      2 * time.Second,
+		KeysPerSecond: 5,
+		Seed:          1,
+	})
+	assertNoError(t, err)
+	t.Log("\n" + report.String())
+	assert.Equal(t, 3, report.Matches)
+	assert.Empty(t, report.Errors)
+	assert.Zero(t, report.Dropped)
+	//a batch every frame, for about the match duration
+	assert.Greater(t, report.Acked, 6*game.TICK)
+	assert.Positive(t, report.Percentile(50))
 }
 func newWsRequest(roomID, playerID string) *http.Request {
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/ws/match?roomid=%s&playerid=%s", roomID, playerID), nil)
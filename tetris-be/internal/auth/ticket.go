@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTicket = errors.New("invalid ticket")
+	ErrExpiredTicket = errors.New("ticket expired")
+	ErrUsedTicket    = errors.New("ticket already used")
+)
+
+// Ticket binds a player to a room for a short time, it is redeemed once when the websocket opens
+type Ticket struct {
+	RoomID    string
+	PlayerID  string
+	ExpiresAt time.Time
+	nonce     string
+}
+
+// TicketIssuer sign tickets with HMAC-SHA256 and remember redeemed ones until they expire
+type TicketIssuer struct {
+	secret []byte
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time // map[nonce] expiry
+}
+
+// NewTicketIssuer with an empty secret generates a random one, tickets won't survive a restart then
+func NewTicketIssuer(secret []byte, ttl time.Duration) *TicketIssuer {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &TicketIssuer{
+		secret: secret,
+		ttl:    ttl,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Issue return token format: base64url(roomID|playerID|expiry|nonce).base64url(hmac)
+func (i *TicketIssuer) Issue(roomID, playerID string) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("ticket nonce: %w", err)
+	}
+	exp := time.Now().Add(i.ttl).Unix()
+	payload := strings.Join([]string{roomID, playerID, strconv.FormatInt(exp, 10), hex.EncodeToString(nonce)}, "|")
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(i.sign(payload)), nil
+}
+
+// Redeem verify the signature and expiry then burn the ticket so it can't be replayed
+func (i *TicketIssuer) Redeem(token string) (Ticket, error) {
+	enc := base64.RawURLEncoding
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return Ticket{}, ErrInvalidTicket
+	}
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil {
+		return Ticket{}, ErrInvalidTicket
+	}
+	sig, err := enc.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, i.sign(string(payload))) {
+		return Ticket{}, ErrInvalidTicket
+	}
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 4 {
+		return Ticket{}, ErrInvalidTicket
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return Ticket{}, ErrInvalidTicket
+	}
+	t := Ticket{RoomID: parts[0], PlayerID: parts[1], ExpiresAt: time.Unix(exp, 0), nonce: parts[3]}
+
+	now := time.Now()
+	if !now.Before(t.ExpiresAt) {
+		return Ticket{}, ErrExpiredTicket
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, expiry := range i.used {
+		if !now.Before(expiry) {
+			delete(i.used, n)
+		}
+	}
+	if _, burned := i.used[t.nonce]; burned {
+		return Ticket{}, ErrUsedTicket
+	}
+	i.used[t.nonce] = t.ExpiresAt
+	return t, nil
+}
+
+func (i *TicketIssuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,139 @@
+package game
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"time"
+)
+
+// AntiCheatConfig how many violations flag a player, and whether a flagged player loses the match
+type AntiCheatConfig struct {
+	FlagAfter int
+	Forfeit   bool
+}
+
+var DefaultAntiCheatConfig = AntiCheatConfig{FlagAfter: 20, Forfeit: false}
+
+const (
+	maxKeysPerFrame    = 4
+	maxInputsPerSecond = 90                // input entries per second of server frames, resends included
+	maxFrameLead       = 2 * maxInputDelay // frames a client may run ahead of the server tick
+	maxViolationLog    = 64                // reasons kept per match, the counts go on
+)
+
+// Violation a reason an input or message was refused, kept on the match
+type Violation struct {
+	PlayerId string    `json:"playerId"`
+	Frame    int       `json:"frame"`
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+}
+
+var validKeys = map[key]bool{down: true, downOff: true, left: true, right: true, rotate: true, rrotate: true, spacebar: true, hold: true}
+
+// key pairs the client never sends for the same frame
+var conflictingKeys = [][2]key{{left, right}, {rotate, rrotate}}
+
+// validateKeys return why the keys of one frame can't be accepted, "" when they are fine
+func validateKeys(keys []string) string {
+	if len(keys) > maxKeysPerFrame {
+		return fmt.Sprintf("%d keys in one frame", len(keys))
+	}
+	seen := InputBuffer{}
+	for _, k := range keys {
+		if !validKeys[key(k)] {
+			return fmt.Sprintf("unknown key %q", k)
+		}
+		if seen[key(k)] {
+			return fmt.Sprintf("key %q repeated", k)
+		}
+		seen[key(k)] = true
+	}
+	for _, pair := range conflictingKeys {
+		if seen[pair[0]] && seen[pair[1]] {
+			return fmt.Sprintf("%s and %s in one frame", pair[0], pair[1])
+		}
+	}
+	return ""
+}
+
+// currentTick the server frame the client is measured against
+func (exec *FrameExecutor) currentTick() int {
+	if exec.gl != nil {
+		return max(exec.gl.tickFrame, exec.frames.simFrame)
+	}
+	return exec.frames.simFrame
+}
+
+// allowInputs count n input entries in the current second of the match clock, false once over the cap.
+// Not simulated frames: those stop while paused or stalled and the burst after would count as one second
+func (exec *FrameExecutor) allowInputs(n int) bool {
+	window := int64(exec.frames.simFrame / TICK)
+	if exec.clock != nil {
+		window = exec.clock.Now().Unix()
+	}
+	if window != exec.rateWindow {
+		exec.rateWindow, exec.rateCount = window, 0
+	}
+	exec.rateCount += n
+	return exec.rateCount <= maxInputsPerSecond
+}
+
+// now the match clock, the wall clock for an executor outside a match
+func (exec *FrameExecutor) now() time.Time {
+	if exec.clock != nil {
+		return exec.clock.Now()
+	}
+	return time.Now()
+}
+
+func (exec *FrameExecutor) violate(frame int, reason string) {
+	log.Printf("[%s] suspicious input at frame %d: %s", exec.playerId, frame, reason)
+	if exec.onViolation != nil {
+		exec.onViolation(Violation{PlayerId: exec.playerId, Frame: frame, Reason: reason, At: exec.now()})
+	}
+}
+
+// Report record a violation on the match. A player reaching FlagAfter violations in the match is
+// flagged, and lose the match when the policy says so
+func (g *Game) Report(v Violation, broadcast chan Packet) {
+	g.violationsMu.Lock()
+	g.violations[v.PlayerId]++
+	count := g.violations[v.PlayerId]
+	//a flood only bumps the count, the first reasons are enough to see what happened
+	if len(g.violationLog) < maxViolationLog {
+		g.violationLog = append(g.violationLog, v)
+	}
+	g.violationsMu.Unlock()
+	if g.antiCheat.FlagAfter <= 0 || count != g.antiCheat.FlagAfter {
+		return
+	}
+	log.Printf("[%s] flagged after %d violations, last: %s", v.PlayerId, count, v.Reason)
+	if g.antiCheat.Forfeit {
+		//Forfeit stops every loop, the reporting one included
+		go g.forfeit(v.PlayerId, fmt.Sprintf("%s was flagged for cheating: %s", v.PlayerId, v.Reason), broadcast)
+	}
+}
+
+// Violations the reasons recorded on the current match, at most maxViolationLog of them
+func (g *Game) Violations() []Violation {
+	g.violationsMu.Lock()
+	defer g.violationsMu.Unlock()
+	return append([]Violation(nil), g.violationLog...)
+}
+
+// ViolationCounts per player on the current match
+func (g *Game) ViolationCounts() map[string]int {
+	g.violationsMu.Lock()
+	defer g.violationsMu.Unlock()
+	return maps.Clone(g.violations)
+}
+
+// resetViolations a new match starts clean
+func (g *Game) resetViolations() {
+	g.violationsMu.Lock()
+	defer g.violationsMu.Unlock()
+	g.violations = map[string]int{}
+	g.violationLog = nil
+}
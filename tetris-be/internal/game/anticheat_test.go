@@ -0,0 +1,171 @@
+package game
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestValidateKeys(t *testing.T) {
+	cases := []struct {
+		keys  []string
+		valid bool
+	}{
+		{[]string{"left", "rotate", "space"}, true},
+		{[]string{"down", "downOff"}, true},
+		{[]string{}, true},
+		{[]string{"teleport"}, false},
+		{[]string{"left", "left"}, false},
+		{[]string{"left", "right"}, false},
+		{[]string{"rotate", "rrotate"}, false},
+		{[]string{"left", "rotate", "down", "hold", "space"}, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.valid, validateKeys(c.keys) == "", c.keys)
+	}
+}
+
+func TestInputValidation(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	newWatched := func(t *testing.T) (*FrameExecutor, *[]Violation, chan Packet) {
+		exec := newTestExecutor(t, listBlock)
+		exec.clock = NewManualClock(time.Unix(0, 0))
+		violations := &[]Violation{}
+		exec.onViolation = func(v Violation) { *violations = append(*violations, v) }
+		return exec, violations, make(chan Packet, 32)
+	}
+	t.Run("unknown keys and impossible combinations are rejected", func(t *testing.T) {
+		exec, violations, broadcast := newWatched(t)
+		exec.recordInputs(1, []Input{{Frame: 2, Keys: []string{"noclip"}}, {Frame: 3, Keys: []string{"left", "right"}}, {Frame: 4, Keys: []string{"left"}}}, 4, broadcast)
+		reply := (<-broadcast).msg
+		assert.Equal(t, []int{2, 3}, reply.Payload.Rejected)
+		assert.Equal(t, []Input{{Frame: 4, Keys: []string{"left"}}}, reply.Payload.Inputs)
+		assert.Len(t, *violations, 2)
+		bs, _ := exec.frames.Get(2)
+		assert.Empty(t, bs.inputBuffer)
+	})
+	t.Run("latest frame is monotonic and bounded", func(t *testing.T) {
+		exec, violations, broadcast := newWatched(t)
+		exec.recordInputs(0, nil, 5, broadcast)
+		exec.recordInputs(0, nil, 5, broadcast)
+		assert.Empty(t, *violations)
+		exec.recordInputs(0, nil, 3, broadcast)
+		assert.Equal(t, 5, exec.netFrame)
+		if assert.Len(t, *violations, 1) {
+			assert.Equal(t, "latest frame 3 behind 5", (*violations)[0].Reason)
+			assert.Equal(t, exec.clock.Now(), (*violations)[0].At)
+		}
+
+		exec.recordInputs(0, nil, 5000, broadcast)
+		assert.Equal(t, maxFrameLead, exec.netFrame)
+		assert.Len(t, *violations, 2)
+	})
+	t.Run("inputs ahead of the server are rejected", func(t *testing.T) {
+		exec, violations, broadcast := newWatched(t)
+		exec.recordInputs(0, []Input{{Frame: maxFrameLead + 1, Keys: []string{"left"}}}, 1, broadcast)
+		assert.Equal(t, []int{maxFrameLead + 1}, (<-broadcast).msg.Payload.Rejected)
+		assert.Len(t, *violations, 1)
+	})
+	t.Run("same frame twice in a batch", func(t *testing.T) {
+		exec, violations, broadcast := newWatched(t)
+		exec.recordInputs(0, []Input{{Frame: 2, Keys: []string{"left"}}, {Frame: 2, Keys: []string{"right"}}}, 2, broadcast)
+		assert.Equal(t, []int{2}, (<-broadcast).msg.Payload.Rejected)
+		assert.Len(t, *violations, 1)
+	})
+	t.Run("flood over the per second cap", func(t *testing.T) {
+		exec, violations, broadcast := newWatched(t)
+		inputs := make([]Input, 0, maxInputsPerSecond)
+		for frame := 1; frame <= maxFrameLead; frame++ {
+			inputs = append(inputs, Input{Frame: frame, Keys: []string{"hold"}})
+		}
+		for sent := 0; sent+len(inputs) <= maxInputsPerSecond; sent += len(inputs) {
+			exec.recordInputs(0, inputs, 1, broadcast)
+			assert.Empty(t, (<-broadcast).msg.Payload.Rejected)
+		}
+		exec.recordInputs(0, inputs, 1, broadcast)
+		assert.Len(t, (<-broadcast).msg.Payload.Rejected, len(inputs))
+		assert.Len(t, *violations, 1)
+
+		//next second of the clock, no frame was simulated meanwhile: paused or stalled
+		exec.clock.(*ManualClock).Advance(time.Second)
+		exec.recordInputs(0, inputs, 1, broadcast)
+		assert.Empty(t, (<-broadcast).msg.Payload.Rejected)
+		assert.Len(t, *violations, 1)
+	})
+}
+
+func TestReport(t *testing.T) {
+	t.Run("flagged player forfeits", func(t *testing.T) {
+		g := NewGame(DefaultRoomSettings(), AntiCheatConfig{FlagAfter: 3, Forfeit: true}, nil)
+		g.players["player-1"], g.players["player-2"] = NewFrameExecutor("player-1", g.settings), NewFrameExecutor("player-2", g.settings)
+		g.isPlaying.Store(true)
+		results := make(chan MatchResult, 1)
+		g.onResult = func(res MatchResult) { results <- res }
+		broadcast := make(chan Packet, 32)
+		for i := range 3 {
+			g.Report(Violation{PlayerId: "player-1", Frame: i, Reason: "unknown key"}, broadcast)
+		}
+		g.Report(Violation{PlayerId: "player-2", Reason: "malformed message"}, broadcast)
+
+		//final stats of both players come first
+		for range 2 {
+			assert.Equal(t, "stats", (<-broadcast).msg.Type)
+		}
+		select {
+		case packet := <-broadcast:
+			assert.Equal(t, "gameover", packet.msg.Type)
+			assert.Equal(t, "player-2", packet.msg.PlayerId)
+			assert.Contains(t, packet.msg.Error, "player-1 was flagged")
+		case <-time.After(time.Second):
+			t.Fatal("expected gameover")
+		}
+		assert.False(t, g.IsPlaying())
+		assert.Len(t, g.Violations(), 4)
+		res := <-results
+		assert.Equal(t, 3, res.Violations["player-1"])
+		if assert.GreaterOrEqual(t, len(res.ViolationLog), 3) {
+			assert.Equal(t, "unknown key", res.ViolationLog[0].Reason)
+		}
+	})
+	t.Run("counts start over with each match", func(t *testing.T) {
+		g := NewGame(DefaultRoomSettings(), AntiCheatConfig{FlagAfter: 3, Forfeit: true}, nil)
+		g.isPlaying.Store(true)
+		broadcast := make(chan Packet, 32)
+		for range 2 {
+			g.Report(Violation{PlayerId: "player-1", Reason: "unknown key"}, broadcast)
+		}
+		//what Init does for the rematch
+		g.resetViolations()
+		assert.Empty(t, g.Violations())
+		for range 2 {
+			g.Report(Violation{PlayerId: "player-1", Reason: "unknown key"}, broadcast)
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.Empty(t, broadcast)
+		assert.Equal(t, map[string]int{"player-1": 2}, g.ViolationCounts())
+
+		//flagged again in the next match, not only once per room
+		g.Report(Violation{PlayerId: "player-1", Reason: "unknown key"}, broadcast)
+		assert.Eventually(t, func() bool { return !g.IsPlaying() }, time.Second, 10*time.Millisecond)
+	})
+	t.Run("a flood keeps the count, not every reason", func(t *testing.T) {
+		g := NewGame(DefaultRoomSettings(), AntiCheatConfig{}, nil)
+		broadcast := make(chan Packet, 1)
+		for range 10 * maxViolationLog {
+			g.Report(Violation{PlayerId: "player-1", Reason: "unknown key"}, broadcast)
+		}
+		assert.Len(t, g.Violations(), maxViolationLog)
+		assert.Equal(t, 10*maxViolationLog, g.ViolationCounts()["player-1"])
+	})
+	t.Run("flag only by default", func(t *testing.T) {
+		g := NewGame(DefaultRoomSettings(), DefaultAntiCheatConfig, nil)
+		g.isPlaying.Store(true)
+		broadcast := make(chan Packet, 32)
+		for range DefaultAntiCheatConfig.FlagAfter + 1 {
+			g.Report(Violation{PlayerId: "player-1", Reason: "unknown key"}, broadcast)
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.Empty(t, broadcast)
+		assert.True(t, g.IsPlaying())
+	})
+}
@@ -0,0 +1,18 @@
+package game
+
+// AttackDTO an attack on its way to Target: Amount lines from Source landing at Frame
+type AttackDTO struct {
+	Amount int    `json:"amount"`
+	Source string `json:"source"`
+	Frame  int    `json:"frame"`
+}
+
+// sendAttackEvent go to everyone in the room, PlayerId is the player being attacked
+func (exec *FrameExecutor) sendAttackEvent(msgType string, atk AttackDTO, broadcast chan Packet) {
+	msg := NewMessage(msgType)
+	msg.PlayerId = exec.playerId
+	msg.Payload.Attack = &atk
+	var packet Packet
+	packet.msg = msg
+	broadcast <- packet
+}
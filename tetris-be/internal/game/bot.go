@@ -0,0 +1,235 @@
+package game
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+)
+
+// BotWeights score a board after a placement, positive is better
+type BotWeights struct {
+	Height    float64 `json:"height"`    // sum of column heights
+	Holes     float64 `json:"holes"`     // empty cells under a filled one
+	Bumpiness float64 `json:"bumpiness"` // height difference of neighbour columns
+	Lines     float64 `json:"lines"`     // lines cleared by the placement
+	TSlots    float64 `json:"tSlots"`    // T-spin setups left open
+}
+
+// DefaultBotWeights the well known hand tuned weights with holes costing more, there is no lookahead
+// to dig them out later. T slots a little on top
+var DefaultBotWeights = BotWeights{Height: -0.51, Holes: -0.9, Bumpiness: -0.18, Lines: 0.76, TSlots: 0.2}
+
+type BotConfig struct {
+	PiecesPerSecond float64    `json:"piecesPerSecond"`
+	MistakeRate     float64    `json:"mistakeRate"` // chance to take one of the top quarter placements instead of the best one
+	Weights         BotWeights `json:"weights"`
+}
+
+var BotDifficulties = map[string]BotConfig{
+	"easy":   {PiecesPerSecond: 0.8, MistakeRate: 0.1, Weights: DefaultBotWeights},
+	"normal": {PiecesPerSecond: 1.5, MistakeRate: 0.05, Weights: DefaultBotWeights},
+	"hard":   {PiecesPerSecond: 3, MistakeRate: 0, Weights: DefaultBotWeights},
+}
+
+// Placement a resting spot of the current piece: rotated Form times clockwise from spawn, at Col
+type Placement struct {
+	Form  int
+	Col   int
+	Row   int
+	Score float64
+}
+
+// Bot plays a FrameExecutor from inside its loop: every tick it look at the last simulated frame
+// and press at most one key for the next one, like a client would send it
+type Bot struct {
+	cfg BotConfig
+	rng *rand.Rand
+
+	piece    int // blockIndex the target is for
+	target   Placement
+	rotates  int // rotate keys pressed for this piece
+	lastCol  int
+	stuck    int // moves that did not move the piece
+	dropAt   int // frame the piece may be hard dropped, paces pieces per second
+	pressed  string
+	hasPiece bool
+}
+
+func NewBot(cfg BotConfig, seed int64) *Bot {
+	return &Bot{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Next the keys for frame, bs is the state of the frame before
+func (b *Bot) Next(bs *BoardState, frame int) []string {
+	if !b.hasPiece || bs.blockIndex != b.piece {
+		b.hasPiece = true
+		b.piece = bs.blockIndex
+		b.target = b.choose(bs)
+		b.rotates, b.stuck = 0, 0
+		b.lastCol = bs.cCol
+		b.pressed = ""
+		framesPerPiece := TICK
+		if b.cfg.PiecesPerSecond > 0 {
+			framesPerPiece = int(float64(TICK) / b.cfg.PiecesPerSecond)
+		}
+		b.dropAt = frame + framesPerPiece - 1
+	}
+	if (b.pressed == "left" || b.pressed == "right") && bs.cCol == b.lastCol {
+		b.stuck++
+	}
+	b.lastCol = bs.cCol
+	b.pressed = ""
+
+	switch {
+	case bs.block.form != b.target.Form && b.rotates < 4:
+		b.rotates++
+		b.pressed = "rotate"
+	case bs.cCol < b.target.Col && b.stuck < 2:
+		b.pressed = "right"
+	case bs.cCol > b.target.Col && b.stuck < 2:
+		b.pressed = "left"
+	case frame >= b.dropAt:
+		b.pressed = "space"
+	default:
+		return nil
+	}
+	return []string{b.pressed}
+}
+
+func (b *Bot) choose(bs *BoardState) Placement {
+	candidates := Placements(bs.board, bs.block, bs.cRow, b.cfg.Weights)
+	if len(candidates) == 0 {
+		return Placement{Form: bs.block.form, Col: bs.cCol}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	//a mistake is a worse spot a person could pick too, not a random one across the board
+	if b.cfg.MistakeRate > 0 && b.rng.Float64() < b.cfg.MistakeRate {
+		return candidates[b.rng.Intn(len(candidates)/4+1)]
+	}
+	return candidates[0]
+}
+
+// Placements every spot the block can be dropped straight into from row, for each rotation, scored
+func Placements(board [][]int, block Block, row int, weights BotWeights) []Placement {
+	var placements []Placement
+	shape := block.shape
+	width := len(board[0])
+	for r := 0; r < 4; r++ {
+		form := (block.form + r) % 4
+		for col := -len(shape); col < width; col++ {
+			if hasCollision(board, shape, row, col) {
+				continue
+			}
+			landing := FindLandingPosition(board, shape, row, col)
+			after := copySlice(board)
+			PlaceBlock(after, shape, landing, col)
+			lines := ClearLines(after)
+			placements = append(placements, Placement{Form: form, Col: col, Row: landing, Score: Evaluate(after, lines, weights)})
+		}
+		shape = RotateRight(shape)
+	}
+	return placements
+}
+
+// Evaluate score a board, lines were cleared to get it
+func Evaluate(board [][]int, lines int, w BotWeights) float64 {
+	width := len(board[0])
+	heights := make([]int, width)
+	holes := 0
+	for c := 0; c < width; c++ {
+		for r := 0; r < len(board); r++ {
+			if board[r][c] == 0 {
+				if heights[c] > 0 {
+					holes++
+				}
+				continue
+			}
+			if heights[c] == 0 {
+				heights[c] = len(board) - r
+			}
+		}
+	}
+	aggregate, bumpiness := 0, 0
+	for c, h := range heights {
+		aggregate += h
+		if c > 0 {
+			bumpiness += abs(h - heights[c-1])
+		}
+	}
+	return w.Height*float64(aggregate) + w.Holes*float64(holes) + w.Bumpiness*float64(bumpiness) +
+		w.Lines*float64(lines) + w.TSlots*float64(TSlots(board))
+}
+
+// TSlots count spots where a T fits pointing down with 3 of its 4 corners filled, a T-spin setup
+func TSlots(board [][]int) int {
+	filled := func(r, c int) bool {
+		return r < 0 || r >= len(board) || c < 0 || c >= len(board[0]) || board[r][c] != 0
+	}
+	slots := 0
+	for r := 1; r < len(board)-1; r++ {
+		for c := 1; c < len(board[0])-1; c++ {
+			if filled(r, c) || filled(r, c-1) || filled(r, c+1) || filled(r+1, c) {
+				continue
+			}
+			corners := 0
+			for _, d := range [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}} {
+				if filled(r+d[0], c+d[1]) {
+					corners++
+				}
+			}
+			//the roof over the slot is what makes it a spin instead of a drop
+			if corners >= 3 && (filled(r-1, c-1) || filled(r-1, c+1)) {
+				slots++
+			}
+		}
+	}
+	return slots
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// botPlay let the bot of this executor send its keys for the next frame, from the loop goroutine
+func (exec *FrameExecutor) botPlay(broadcast chan Packet) {
+	fq := exec.frames
+	bs, err := fq.Get(fq.simFrame)
+	if err != nil || bs == nil {
+		return
+	}
+	frame := fq.simFrame + 1
+	var inputs []Input
+	if keys := exec.bot.Next(bs, frame); len(keys) > 0 {
+		inputs = append(inputs, Input{Frame: frame, Keys: keys})
+	}
+	exec.recordInputs(0, inputs, frame, broadcast)
+}
+
+// NewBotConn a seat for a bot: no websocket, what the room sends it is read by Play
+func NewBotConn(ID string, room *Room, cfg BotConfig) *PlayerConn {
+	return &PlayerConn{
+		ID:    ID,
+		r:     room,
+		send:  make(chan Message, 256),
+		codec: JSONCodec{},
+		bot:   &cfg,
+	}
+}
+
+// Play answer the room for the bot until its seat is closed. The keys are pressed by the loop
+func (p *PlayerConn) Play() {
+	for msg := range p.send {
+		switch msg.Type {
+		case "start":
+			if msg.Error == "" {
+				p.r.game.StartGame(p.r.broadcast)
+			}
+		case "error":
+			//e.g. room is full, the seat is closed right after
+			log.Printf("[ws][room:%s] bot %s: %s", p.r.ID, p.ID, msg.Error)
+		}
+	}
+}
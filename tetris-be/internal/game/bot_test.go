@@ -0,0 +1,82 @@
+package game
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBotSearch(t *testing.T) {
+	t.Run("I piece goes into the well for a tetris", func(t *testing.T) {
+		board := CreateEmptyBoard(DefaultBoardSize)
+		for r := len(board) - 4; r < len(board); r++ {
+			for c := 0; c < 9; c++ {
+				board[r][c] = 5
+			}
+		}
+		I := Tetromino[1]
+		best := Placement{Score: -1e9}
+		for _, p := range Placements(board, I, 0, DefaultBotWeights) {
+			if p.Score > best.Score {
+				best = p
+			}
+		}
+		//vertical I sits in column 2 of its box
+		assert.Equal(t, 1, best.Form%2)
+		assert.Equal(t, 9, best.Col+2)
+		assert.Equal(t, len(board)-4, best.Row)
+	})
+	t.Run("T slot needs three corners and a roof", func(t *testing.T) {
+		board := CreateEmptyBoard(DefaultBoardSize)
+		n := len(board)
+		board[n-1] = []int{1, 1, 1, 1, 0, 1, 1, 1, 1, 1}
+		board[n-2] = []int{1, 1, 1, 0, 0, 0, 1, 1, 1, 1}
+		assert.Equal(t, 0, TSlots(board))
+		board[n-3][3] = 1
+		assert.Equal(t, 1, TSlots(board))
+	})
+}
+
+func TestBotPlays(t *testing.T) {
+	for _, difficulty := range []string{"easy", "normal", "hard"} {
+		t.Run(difficulty, func(t *testing.T) {
+			cfg := BotDifficulties[difficulty]
+			h := NewHarness(DefaultRoomSettings(), 1, "bot")
+			h.Bot("bot", cfg, 1)
+			h.SetList("bot", seededBags(1, 1000))
+			frames := 2 * 60 * TICK
+			assert.Equal(t, frames, h.Run(frames))
+
+			fq := h.game.players["bot"].frames
+			bs, _ := fq.Get(fq.simFrame)
+			//pieces are paced by piecesPerSecond, the last one may still be falling
+			want := float64(frames) / float64(TICK) * cfg.PiecesPerSecond
+			assert.InDelta(t, want, bs.blockIndex, want*0.1)
+			if cfg.MistakeRate > 0 {
+				return //survives, mistakes may leave a tall stack
+			}
+			height := 0
+			for r, row := range bs.board {
+				for _, cell := range row {
+					if cell != 0 {
+						height = max(height, len(bs.board)-r)
+					}
+				}
+			}
+			assert.LessOrEqual(t, height, DefaultBoardSize.Height/2)
+		})
+	}
+}
+
+// seededBags a 7-bag piece list that is the same on every run
+func seededBags(seed int64, n int) []int {
+	rng := rand.New(rand.NewSource(seed))
+	list := make([]int, 0, n+7)
+	for len(list) < n {
+		bag := []int{1, 2, 3, 4, 5, 6, 7}
+		rng.Shuffle(len(bag), func(i, j int) { bag[i], bag[j] = bag[j], bag[i] })
+		list = append(list, bag...)
+	}
+	return list[:n]
+}
@@ -0,0 +1,98 @@
+package game
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+)
+
+// CHECKSUM_INTERVAL frames between two checksums the server keeps to compare with the client
+const CHECKSUM_INTERVAL = 30
+
+// desyncs count every checksum mismatch of every room, read it with DesyncCount
+var desyncs atomic.Int64
+
+func DesyncCount() int64 {
+	return desyncs.Load()
+}
+
+// Checksum hash what the client predicts: board, piece, position, hold and timers.
+// Cells and numbers are written as big endian uint32 then FNV-1a 32, timers are truncated to ms
+// so the client can compute the same value without matching float bits
+func (bs *BoardState) Checksum() uint32 {
+	cells := 0
+	for _, row := range bs.board {
+		cells += len(row) //the width is a room setting
+	}
+	buf := make([]byte, 0, 4*(cells+32))
+	for _, row := range bs.board {
+		for _, cell := range row {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(cell))
+		}
+	}
+	for _, row := range bs.block.shape {
+		for _, cell := range row {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(cell))
+		}
+	}
+	canHold := 0
+	if bs.canHold {
+		canHold = 1
+	}
+	for _, v := range []int{bs.block.form, bs.cRow, bs.cCol, bs.holdBlock, bs.blockIndex, canHold,
+		int(bs.gravityTimer), int(bs.lockTimer)} {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(v))
+	}
+	h := fnv.New32a()
+	h.Write(buf)
+	return h.Sum32()
+}
+
+// verifyChecksums compare client checksums of frames that can't be rolled back anymore,
+// on the first mismatch the client gets an authoritative resync
+func (exec *FrameExecutor) verifyChecksums(broadcast chan Packet) {
+	fq := exec.frames
+	final := fq.simFrame - ROLLBACK_WINDOW
+	pending := exec.checksums[:0]
+	for _, c := range exec.checksums {
+		if c.Frame > final {
+			pending = append(pending, c)
+			continue
+		}
+		bs, err := fq.Get(c.Frame)
+		if err != nil || bs.events.frame != c.Frame {
+			continue //too old, the slot holds another frame
+		}
+		if bs.events.checksum == c.Checksum {
+			continue
+		}
+		log.Printf("[%s] desync at frame %d: client %08x server %08x\n", exec.playerId, c.Frame, c.Checksum, bs.events.checksum)
+		exec.desyncs++
+		desyncs.Add(1)
+		exec.resync(broadcast)
+		//later checksums were computed from the diverged prediction
+		exec.checksums = exec.checksums[:0]
+		return
+	}
+	exec.checksums = pending
+}
+
+// resync push the latest server state, the client drops its prediction and continue from it
+func (exec *FrameExecutor) resync(broadcast chan Packet) {
+	fq := exec.frames
+	bs, err := fq.Get(fq.simFrame)
+	if err != nil || bs == nil {
+		log.Printf("[%s] cannot resync frame %d: %v\n", exec.playerId, fq.simFrame, err)
+		return
+	}
+	msg := NewMessage("resync")
+	msg.PlayerId = exec.playerId
+	msg.Payload.LatestFrame = fq.simFrame
+	msg.Payload.BoardState = bs.ToDTO()
+	msg.Payload.Checksum = bs.Checksum()
+	var packet Packet
+	packet.directId = exec.playerId
+	packet.msg = msg
+	broadcast <- packet
+}
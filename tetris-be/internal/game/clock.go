@@ -0,0 +1,143 @@
+package game
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is where a GameLoop gets its time. Production uses the wall clock,
+// tests and headless matches use a ManualClock so frames move only when told to
+type Clock interface {
+	Now() time.Time
+	At(t time.Time) <-chan time.Time // fire once Now reaches t
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WallClock the real time, for packages outside game that take a Clock
+var WallClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) At(t time.Time) <-chan time.Time  { return time.After(time.Until(t)) }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// ManualClock never moves by itself: Advance moves Now and fires due timers, Tick fires the tickers
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []manualTimer
+	tickers []*manualTicker
+}
+
+type manualTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+type manualTicker struct {
+	clock    *ManualClock
+	interval time.Duration
+	c        chan time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) At(t time.Time) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := manualTimer{at: t, c: make(chan time.Time, 1)}
+	if !t.After(c.now) {
+		timer.c <- c.now
+		return timer.c
+	}
+	c.timers = append(c.timers, timer)
+	return timer.c
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{clock: c, interval: d, c: make(chan time.Time), stop: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance move the clock by d, firing timers that are due. Tickers only fire on Tick
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// Tick fire every running ticker once and wait until each loop took it, so the tick before is
+// fully processed by then. Now moves by the longest interval
+func (c *ManualClock) Tick() {
+	c.mu.Lock()
+	tickers := append([]*manualTicker(nil), c.tickers...)
+	var step time.Duration
+	for _, t := range tickers {
+		step = max(step, t.interval)
+	}
+	c.mu.Unlock()
+	c.Advance(step)
+	now := c.Now()
+	for _, t := range tickers {
+		select {
+		case t.c <- now:
+		case <-t.stop:
+		}
+	}
+}
+
+// Tickers count the running tickers, a loop creates its own once startAt passed
+func (c *ManualClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+func (t *manualTicker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		c := t.clock
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == t {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	})
+}
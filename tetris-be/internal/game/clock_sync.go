@@ -0,0 +1,98 @@
+package game
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	clockSyncBurst    = 8                      // samples wanted before a match starts
+	clockSyncFast     = 200 * time.Millisecond // ping period until the burst is done
+	clockSyncSlow     = 5 * time.Second        // keep estimates fresh during the match
+	minInputDelay     = 1
+	maxInputDelay     = 8
+	defaultInputDelay = 4
+	minStartLead      = 500 * time.Millisecond
+)
+
+// ClockSync estimate round trip, jitter and clock offset of one connection from server initiated ping/pong:
+//
+//	server --ping{timestamp: t0}--> client --pong{timestamp: t0, clientTime: tc}--> server (t3)
+//	rtt = t3 - t0, offset = tc - (t0 + rtt/2)
+type ClockSync struct {
+	mu      sync.Mutex
+	samples int
+	rtt     float64 // smoothed, ms
+	jitter  float64 // mean deviation of rtt, ms
+	bestRTT float64
+	offset  float64 // client clock - server clock, ms. Taken from the lowest rtt sample, it has the least queuing error
+}
+
+func NewClockSync() *ClockSync {
+	return &ClockSync{}
+}
+
+func (c *ClockSync) AddSample(sentAt, clientTime, receivedAt int64) {
+	rtt := float64(receivedAt - sentAt)
+	if rtt < 0 || rtt > float64(10*time.Second.Milliseconds()) {
+		return //not a ping we sent recently
+	}
+	offset := float64(clientTime) - (float64(sentAt) + rtt/2)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == 0 {
+		c.rtt, c.jitter = rtt, rtt/2
+		c.bestRTT, c.offset = rtt, offset
+	} else {
+		//same gains as TCP srtt/rttvar
+		c.jitter += (math.Abs(rtt-c.rtt) - c.jitter) / 4
+		c.rtt += (rtt - c.rtt) / 8
+		if rtt <= c.bestRTT {
+			c.bestRTT, c.offset = rtt, offset
+		}
+	}
+	c.samples++
+}
+
+func (c *ClockSync) Estimate() (rtt, jitter, offset float64, samples int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rtt, c.jitter, c.offset, c.samples
+}
+
+// InputDelay is the number of frames an input needs to reach the server on the slowest link
+func InputDelay(clocks ...*ClockSync) int {
+	worst := 0.0
+	measured := false
+	for _, c := range clocks {
+		if c == nil {
+			continue
+		}
+		rtt, jitter, _, samples := c.Estimate()
+		if samples == 0 {
+			continue
+		}
+		measured = true
+		worst = max(worst, rtt/2+2*jitter)
+	}
+	if !measured {
+		return defaultInputDelay
+	}
+	frames := int(math.Ceil(worst / INTERVAL))
+	return max(minInputDelay, min(maxInputDelay, frames))
+}
+
+// startLead leave enough time for the start message to reach the slowest client
+func startLead(clocks ...*ClockSync) time.Duration {
+	lead := minStartLead
+	for _, c := range clocks {
+		if c == nil {
+			continue
+		}
+		rtt, jitter, _, _ := c.Estimate()
+		lead = max(lead, time.Duration(2*rtt+4*jitter)*time.Millisecond)
+	}
+	return lead
+}
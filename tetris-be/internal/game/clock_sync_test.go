@@ -0,0 +1,64 @@
+package game
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	t.Run("offset from symmetric link", func(t *testing.T) {
+		c := NewClockSync()
+		// client clock is 1000ms ahead, 40ms each way
+		for sent := int64(0); sent < 800; sent += 100 {
+			c.AddSample(sent, sent+40+1000, sent+80)
+		}
+		rtt, jitter, offset, samples := c.Estimate()
+		assert.Equal(t, 8, samples)
+		assert.InDelta(t, 80, rtt, 0.01)
+		assert.InDelta(t, 0, jitter, 10)
+		assert.InDelta(t, 1000, offset, 0.01)
+	})
+	t.Run("offset follows the fastest sample", func(t *testing.T) {
+		c := NewClockSync()
+		c.AddSample(0, 500+150, 300) // queued on the way back, offset looks wrong
+		c.AddSample(1000, 1500+20, 1040)
+		_, _, offset, _ := c.Estimate()
+		assert.InDelta(t, 500, offset, 0.01)
+	})
+	t.Run("stale or reordered pong ignored", func(t *testing.T) {
+		c := NewClockSync()
+		c.AddSample(100, 0, 50)
+		c.AddSample(0, 0, 60_000)
+		_, _, _, samples := c.Estimate()
+		assert.Equal(t, 0, samples)
+	})
+	t.Run("server time from the match clock", func(t *testing.T) {
+		clock := NewManualClock(time.UnixMilli(1760000000000))
+		g := NewGame(DefaultRoomSettings(), DefaultAntiCheatConfig, clock)
+		broadcast := make(chan Packet, 1)
+		ping := NewMessage("ping")
+		ping.Timestamp = 42
+		g.pong(ping, "player-1", broadcast)
+		reply := (<-broadcast).msg
+		assert.Equal(t, int64(42), reply.Timestamp)
+		assert.Equal(t, clock.Now().UnixMilli(), reply.Payload.ServerTime)
+	})
+}
+
+func TestInputDelay(t *testing.T) {
+	assert.Equal(t, defaultInputDelay, InputDelay(nil, NewClockSync()))
+
+	lan := NewClockSync()
+	lan.AddSample(0, 0, 2)
+	assert.Equal(t, minInputDelay, InputDelay(lan))
+
+	far := NewClockSync()
+	far.AddSample(0, 0, 150)
+	far.AddSample(1000, 0, 1250)
+	assert.Greater(t, InputDelay(lan, far), InputDelay(lan))
+
+	awful := NewClockSync()
+	awful.AddSample(0, 0, 2000)
+	assert.Equal(t, maxInputDelay, InputDelay(lan, awful))
+}
@@ -0,0 +1,559 @@
+package game
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"math"
+)
+
+// websocket subprotocols a client can ask for in Sec-WebSocket-Protocol, binary first so it wins
+// when the client offers both. A client that asks for nothing talks JSON
+const (
+	SubprotocolBinary = "tetris.bin.v1"
+	SubprotocolJSON   = "tetris.json.v1"
+)
+
+var Subprotocols = []string{SubprotocolBinary, SubprotocolJSON}
+
+// Codec turns a Message into a websocket frame and back
+type Codec interface {
+	Encode(msg Message) ([]byte, error)
+	Decode(data []byte) (Message, error)
+	FrameType() int
+}
+
+// CodecFor return the codec of a negotiated subprotocol, JSON is the fallback
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == SubprotocolBinary {
+		return BinaryCodec{}
+	}
+	return JSONCodec{}
+}
+
+// codecForFrame pick the decoder by frame type, a binary client may still send text frames.
+// A client that didn't negotiate binary has no business sending binary frames
+func codecForFrame(frameType int, negotiated Codec) (Codec, error) {
+	if frameType != websocket.BinaryMessage {
+		return JSONCodec{}, nil
+	}
+	if _, ok := negotiated.(BinaryCodec); !ok {
+		return nil, ErrMalformed
+	}
+	return BinaryCodec{}, nil
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+func (JSONCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+func (JSONCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+/*
+BinaryCodec layout, every integer is a varint (zigzag for signed) unless noted:
+
+	type byte                 index in messageTypes, 0 then a string for a type not in the list
+	fields uvarint            bitmask of the fields present below, in this order
+	playerid string, latestFrame, listBlock (count + nibbles), state, inputs,
+	startAt, resumeToken string, inputDelay, clientTime, serverTime, checksum, timestamp, error string,
+	seq, baseSeq, rows (count, then row index, cell count and nibbles of each), rejected (count + frames),
+	attack (amount, source string, frame), stats (the counts of Stats in field order, T-spins inlined)
+
+state is rows, cols, nibble packed cells (2 per byte) for board then block, cRow, cCol, bForm,
+hold, blockIndex, a flag byte (canHold, onGround, which timers follow) and the timers as float64.
+An input is its frame, a key bitmask byte and its checksum.
+*/
+type BinaryCodec struct{}
+
+var messageTypes = []string{"", "inputs", "input-server", "opponent", "garbage-sync", "server-state", "resync",
+	"resume", "start", "ready", "pause", "unpause", "ping", "pong", "session", "disconnected", "reconnected", "gameover",
+	"opponent-delta", "opponent-ack", "keyframe", "attack-incoming", "attack-cancelled", "stats"}
+
+// inputKeys bit i of an input key byte
+var inputKeys = []key{down, downOff, left, right, rotate, rrotate, spacebar, hold}
+
+var ErrMalformed = errors.New("malformed binary message")
+
+const (
+	fPlayerId = 1 << iota
+	fLatestFrame
+	fListBlock
+	fState
+	fInputs
+	fStartAt
+	fResumeToken
+	fInputDelay
+	fClientTime
+	fServerTime
+	fChecksum
+	fTimestamp
+	fError
+	fSeq
+	fBaseSeq
+	fRows
+	fRejected
+	fAttack
+	fStats
+)
+
+// state flag byte
+const (
+	sCanHold = 1 << iota
+	sOnGround
+	sDropSpeed
+	sAccumulator
+	sLockTime
+)
+
+func (BinaryCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (BinaryCodec) Encode(msg Message) ([]byte, error) {
+	buf := make([]byte, 0, 64)
+	code := typeCode(msg.Type)
+	buf = append(buf, code)
+	if code == 0 {
+		buf = appendString(buf, msg.Type)
+	}
+	p := msg.Payload
+	var fields uint64
+	set := func(present bool, f uint64) {
+		if present {
+			fields |= f
+		}
+	}
+	set(msg.PlayerId != "", fPlayerId)
+	set(p.LatestFrame != 0, fLatestFrame)
+	set(len(p.ListBlock) > 0, fListBlock)
+	set(p.BoardState.Board != nil || p.BoardState.Block != nil, fState)
+	set(len(p.Inputs) > 0, fInputs)
+	set(p.StartAt != 0, fStartAt)
+	set(p.ResumeToken != "", fResumeToken)
+	set(p.InputDelay != 0, fInputDelay)
+	set(p.ClientTime != 0, fClientTime)
+	set(p.ServerTime != 0, fServerTime)
+	set(p.Checksum != 0, fChecksum)
+	set(msg.Timestamp != 0, fTimestamp)
+	set(msg.Error != "", fError)
+	set(p.Seq != 0, fSeq)
+	set(p.BaseSeq != 0, fBaseSeq)
+	set(p.Rows != nil, fRows)
+	set(len(p.Rejected) > 0, fRejected)
+	set(p.Attack != nil, fAttack)
+	set(p.Stats != nil, fStats)
+	buf = binary.AppendUvarint(buf, fields)
+
+	var err error
+	if fields&fPlayerId != 0 {
+		buf = appendString(buf, msg.PlayerId)
+	}
+	if fields&fLatestFrame != 0 {
+		buf = binary.AppendVarint(buf, int64(p.LatestFrame))
+	}
+	if fields&fListBlock != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(p.ListBlock)))
+		if buf, err = appendNibbles(buf, p.ListBlock); err != nil {
+			return nil, err
+		}
+	}
+	if fields&fState != 0 {
+		if buf, err = appendState(buf, p.BoardState); err != nil {
+			return nil, err
+		}
+	}
+	if fields&fInputs != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(p.Inputs)))
+		for _, in := range p.Inputs {
+			buf = binary.AppendVarint(buf, int64(in.Frame))
+			var keys byte
+			for _, k := range in.Keys {
+				bit := keyBit(key(k))
+				if bit < 0 {
+					return nil, fmt.Errorf("binary codec: unknown key %q", k)
+				}
+				keys |= 1 << bit
+			}
+			buf = append(buf, keys)
+			buf = binary.AppendUvarint(buf, uint64(in.Checksum))
+		}
+	}
+	if fields&fStartAt != 0 {
+		buf = binary.AppendVarint(buf, p.StartAt)
+	}
+	if fields&fResumeToken != 0 {
+		buf = appendString(buf, p.ResumeToken)
+	}
+	if fields&fInputDelay != 0 {
+		buf = binary.AppendVarint(buf, int64(p.InputDelay))
+	}
+	if fields&fClientTime != 0 {
+		buf = binary.AppendVarint(buf, p.ClientTime)
+	}
+	if fields&fServerTime != 0 {
+		buf = binary.AppendVarint(buf, p.ServerTime)
+	}
+	if fields&fChecksum != 0 {
+		buf = binary.AppendUvarint(buf, uint64(p.Checksum))
+	}
+	if fields&fTimestamp != 0 {
+		buf = binary.AppendVarint(buf, msg.Timestamp)
+	}
+	if fields&fError != 0 {
+		buf = appendString(buf, msg.Error)
+	}
+	if fields&fSeq != 0 {
+		buf = binary.AppendVarint(buf, int64(p.Seq))
+	}
+	if fields&fBaseSeq != 0 {
+		buf = binary.AppendVarint(buf, int64(p.BaseSeq))
+	}
+	if fields&fRows != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(p.Rows)))
+		for _, row := range p.Rows {
+			buf = binary.AppendUvarint(buf, uint64(row.Row))
+			buf = binary.AppendUvarint(buf, uint64(len(row.Cells)))
+			if buf, err = appendNibbles(buf, row.Cells); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if fields&fRejected != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(p.Rejected)))
+		for _, frame := range p.Rejected {
+			buf = binary.AppendVarint(buf, int64(frame))
+		}
+	}
+	if fields&fAttack != 0 {
+		buf = binary.AppendVarint(buf, int64(p.Attack.Amount))
+		buf = appendString(buf, p.Attack.Source)
+		buf = binary.AppendVarint(buf, int64(p.Attack.Frame))
+	}
+	if fields&fStats != 0 {
+		for _, v := range statsCounts(&p.Stats.Stats) {
+			buf = binary.AppendVarint(buf, int64(*v))
+		}
+	}
+	return buf, nil
+}
+
+// statsCounts the fields of Stats the binary codec carries, in wire order. Rates are derived
+func statsCounts(s *Stats) []*int {
+	return []*int{&s.Frames, &s.Pieces, &s.Lines, &s.Attack, &s.Received, &s.GarbageCleared, &s.MaxCombo, &s.MaxB2B,
+		&s.TSpins.Zero, &s.TSpins.Single, &s.TSpins.Double, &s.TSpins.Triple, &s.TSpins.Mini, &s.Finesse, &s.SprintFrames}
+}
+
+func (BinaryCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	r := &reader{data: data}
+	code := r.byte()
+	if int(code) >= len(messageTypes) {
+		return msg, ErrMalformed
+	}
+	msg.Type = messageTypes[code]
+	if code == 0 {
+		msg.Type = r.string()
+	}
+	fields := r.uvarint()
+	p := &msg.Payload
+	if fields&fPlayerId != 0 {
+		msg.PlayerId = r.string()
+	}
+	if fields&fLatestFrame != 0 {
+		p.LatestFrame = int(r.varint())
+	}
+	if fields&fListBlock != 0 {
+		p.ListBlock = r.nibbles(int(r.uvarint()))
+	}
+	if fields&fState != 0 {
+		p.BoardState = r.state()
+	}
+	if fields&fInputs != 0 {
+		n := r.count(3) //an input takes at least 3 bytes
+		p.Inputs = make([]Input, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			in := Input{Frame: int(r.varint()), Keys: []string{}}
+			keys := r.byte()
+			for bit, k := range inputKeys {
+				if keys&(1<<bit) != 0 {
+					in.Keys = append(in.Keys, string(k))
+				}
+			}
+			in.Checksum = uint32(r.uvarint())
+			p.Inputs = append(p.Inputs, in)
+		}
+	}
+	if fields&fStartAt != 0 {
+		p.StartAt = r.varint()
+	}
+	if fields&fResumeToken != 0 {
+		p.ResumeToken = r.string()
+	}
+	if fields&fInputDelay != 0 {
+		p.InputDelay = int(r.varint())
+	}
+	if fields&fClientTime != 0 {
+		p.ClientTime = r.varint()
+	}
+	if fields&fServerTime != 0 {
+		p.ServerTime = r.varint()
+	}
+	if fields&fChecksum != 0 {
+		p.Checksum = uint32(r.uvarint())
+	}
+	if fields&fTimestamp != 0 {
+		msg.Timestamp = r.varint()
+	}
+	if fields&fError != 0 {
+		msg.Error = r.string()
+	}
+	if fields&fSeq != 0 {
+		p.Seq = int(r.varint())
+	}
+	if fields&fBaseSeq != 0 {
+		p.BaseSeq = int(r.varint())
+	}
+	if fields&fRows != 0 {
+		n := r.count(2) //a row takes at least 2 bytes
+		p.Rows = make([]RowDelta, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			row := RowDelta{Row: int(r.uvarint())}
+			row.Cells = r.nibbles(int(r.uvarint()))
+			p.Rows = append(p.Rows, row)
+		}
+	}
+	if fields&fRejected != 0 {
+		n := r.count(1)
+		p.Rejected = make([]int, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			p.Rejected = append(p.Rejected, int(r.varint()))
+		}
+	}
+	if fields&fAttack != 0 {
+		p.Attack = &AttackDTO{Amount: int(r.varint()), Source: r.string(), Frame: int(r.varint())}
+	}
+	if fields&fStats != 0 {
+		var s Stats
+		for _, v := range statsCounts(&s) {
+			*v = int(r.varint())
+		}
+		dto := s.ToDTO()
+		p.Stats = &dto
+	}
+	return msg, r.err
+}
+
+func typeCode(t string) byte {
+	for i, name := range messageTypes[1:] {
+		if name == t {
+			return byte(i + 1)
+		}
+	}
+	return 0
+}
+func keyBit(k key) int {
+	for i, name := range inputKeys {
+		if name == k {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendNibbles pack values 0..15 two per byte, high nibble first
+func appendNibbles(buf []byte, cells []int) ([]byte, error) {
+	for i := 0; i < len(cells); i += 2 {
+		hi, lo := cells[i], 0
+		if i+1 < len(cells) {
+			lo = cells[i+1]
+		}
+		if hi < 0 || hi > 15 || lo < 0 || lo > 15 {
+			return nil, fmt.Errorf("binary codec: cell value out of nibble range: %d %d", hi, lo)
+		}
+		buf = append(buf, byte(hi<<4|lo))
+	}
+	return buf, nil
+}
+
+func appendGrid(buf []byte, grid [][]int) ([]byte, error) {
+	cols := 0
+	if len(grid) > 0 {
+		cols = len(grid[0])
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(grid)))
+	buf = binary.AppendUvarint(buf, uint64(cols))
+	cells := make([]int, 0, len(grid)*cols)
+	for _, row := range grid {
+		if len(row) != cols {
+			return nil, errors.New("binary codec: ragged grid")
+		}
+		cells = append(cells, row...)
+	}
+	return appendNibbles(buf, cells)
+}
+
+func appendState(buf []byte, s BoardStateDTO) ([]byte, error) {
+	var err error
+	if buf, err = appendGrid(buf, s.Board); err != nil {
+		return nil, err
+	}
+	if buf, err = appendGrid(buf, s.Block); err != nil {
+		return nil, err
+	}
+	for _, v := range []int{s.CRow, s.CCol, s.BForm, s.Hold, s.BlockIndex} {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	var flags byte
+	if s.CanHold {
+		flags |= sCanHold
+	}
+	if s.OnGround {
+		flags |= sOnGround
+	}
+	timers := []float64{}
+	for _, t := range []struct {
+		v    float64
+		flag byte
+	}{{s.DropSpeed, sDropSpeed}, {s.Accumulator, sAccumulator}, {s.LockTime, sLockTime}} {
+		if t.v != 0 {
+			flags |= t.flag
+			timers = append(timers, t.v)
+		}
+	}
+	buf = append(buf, flags)
+	for _, t := range timers {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(t))
+	}
+	return buf, nil
+}
+
+// reader keep the first error, every read after it returns zero values
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = ErrMalformed
+	}
+	r.data = nil
+}
+func (r *reader) byte() byte {
+	if len(r.data) < 1 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+func (r *reader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count read a length and check the remaining bytes can hold it, so a bad length can't allocate
+func (r *reader) count(minSize int) int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)/minSize) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+func (r *reader) string() string {
+	n := r.count(1)
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+func (r *reader) nibbles(n int) []int {
+	if n < 0 || n > 2*len(r.data) {
+		r.fail()
+		return nil
+	}
+	size := (n + 1) / 2
+	cells := make([]int, n)
+	for i := range cells {
+		b := r.data[i/2]
+		if i%2 == 0 {
+			cells[i] = int(b >> 4)
+		} else {
+			cells[i] = int(b & 0x0f)
+		}
+	}
+	r.data = r.data[size:]
+	return cells
+}
+
+const maxGridSide = 64 // boards are at most 16 wide and 44 rows with the hidden ones
+
+func (r *reader) grid() [][]int {
+	rows, cols := r.uvarint(), r.uvarint()
+	if rows == 0 || cols == 0 {
+		return nil
+	}
+	//each side on its own first, the product of two huge ones wraps around
+	if rows > maxGridSide || cols > maxGridSide || rows*cols > uint64(len(r.data))*2 {
+		r.fail()
+		return nil
+	}
+	cells := r.nibbles(int(rows * cols))
+	grid := make([][]int, rows)
+	for i := range grid {
+		grid[i] = cells[i*int(cols) : (i+1)*int(cols)]
+	}
+	return grid
+}
+func (r *reader) state() BoardStateDTO {
+	s := BoardStateDTO{Board: r.grid(), Block: r.grid()}
+	s.CRow, s.CCol = int(r.varint()), int(r.varint())
+	s.BForm, s.Hold, s.BlockIndex = int(r.varint()), int(r.varint()), int(r.varint())
+	flags := r.byte()
+	s.CanHold, s.OnGround = flags&sCanHold != 0, flags&sOnGround != 0
+	for _, t := range []struct {
+		v    *float64
+		flag byte
+	}{{&s.DropSpeed, sDropSpeed}, {&s.Accumulator, sAccumulator}, {&s.LockTime, sLockTime}} {
+		if flags&t.flag == 0 {
+			continue
+		}
+		if len(r.data) < 8 {
+			r.fail()
+			return s
+		}
+		*t.v = math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+		r.data = r.data[8:]
+	}
+	return s
+}
@@ -0,0 +1,162 @@
+package game
+
+import (
+	"encoding/binary"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+)
+
+// opponentMessage is what every player receives 3 times per tick window, board with garbage rows
+func opponentMessage(t testing.TB) Message {
+	t.Helper()
+	exec := newTestExecutor(t, DefaultRoomSettings().GenerateList(nil, 100))
+	assertNoErr(t, exec.computeBatchFrames(1, 40, make(chan Packet, 32)))
+	bs, _ := exec.frames.Get(40)
+	TakeGarbage(3, bs.board)
+	msg := NewMessage("opponent")
+	msg.Payload.BoardState = bs.ToDTO()
+	msg.Payload.LatestFrame = 40
+	return msg
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	start := NewMessage("start")
+	start.Payload.ListBlock = DefaultRoomSettings().GenerateList(nil, 99)
+	start.Payload.StartAt = 1760000000123
+	start.Payload.InputDelay = 3
+	inputs := NewMessage("inputs")
+	inputs.Payload.LatestFrame = 1234
+	inputs.Payload.Inputs = []Input{{Frame: 1230, Keys: []string{"left", "rotate"}}, {Frame: 1234, Keys: []string{"space"}, Checksum: 0xdeadbeef}}
+	pong := NewMessage("pong")
+	pong.Timestamp, pong.Payload.ClientTime = 1760000000000, 1760000000321
+	session := NewMessage("session")
+	session.PlayerId, session.Payload.ResumeToken = "player-1", "c2VjcmV0"
+	custom := NewMessage("spectate")
+	custom.Error = "not supported"
+	negative := NewMessage("server-state")
+	negative.Payload.BoardState = BoardStateDTO{Board: CreateEmptyBoard(trainingBoard), CRow: -1, CCol: -2, Accumulator: 16.666666666666668}
+
+	delta := NewMessage("opponent-delta")
+	delta.PlayerId = "player-2"
+	delta.Payload.Seq, delta.Payload.BaseSeq = 41, 39
+	delta.Payload.Rows = []RowDelta{{Row: 21, Cells: []int{8, 8, 0, 8, 8, 8, 8, 8, 8, 8}}, {Row: 3, Cells: []int{1}}}
+	delta.Payload.BoardState = BoardStateDTO{Block: Tetromino[3].shape, CRow: 5, CCol: 4, CanHold: true}
+
+	ack := NewMessage("input-server")
+	ack.Payload.Seq, ack.Payload.Rejected = 17, []int{120, -1}
+	ack.Payload.Inputs = []Input{{Frame: 133, Keys: []string{"hold"}}}
+
+	incoming := NewMessage("attack-incoming")
+	incoming.PlayerId = "player-1"
+	incoming.Payload.Attack = &AttackDTO{Amount: 4, Source: "player-2", Frame: 1045}
+
+	stats := NewMessage("stats")
+	stats.PlayerId = "player-2"
+	statsDTO := Stats{Frames: 3600, Pieces: 250, Lines: 92, Attack: 61, Received: 14, GarbageCleared: 9, MaxCombo: 5,
+		MaxB2B: 3, TSpins: TSpins{Double: 4, Mini: 1}, Finesse: 12}.ToDTO()
+	stats.Payload.Stats = &statsDTO
+
+	messages := map[string]Message{
+		"opponent": opponentMessage(t), "start": start, "inputs": inputs, "pong": pong,
+		"session": session, "unknown type": custom, "negative numbers": negative, "delta": delta,
+		"input ack": ack, "attack": incoming, "stats": stats,
+	}
+	for _, codec := range []Codec{JSONCodec{}, BinaryCodec{}} {
+		for name, msg := range messages {
+			t.Run(name, func(t *testing.T) {
+				data, err := codec.Encode(msg)
+				assertNoErr(t, err)
+				got, err := codec.Decode(data)
+				assertNoErr(t, err)
+				assert.Equal(t, msg, got)
+			})
+		}
+	}
+}
+
+func TestBinaryCodec(t *testing.T) {
+	t.Run("smaller than json", func(t *testing.T) {
+		msg := opponentMessage(t)
+		jsonData, _ := JSONCodec{}.Encode(msg)
+		binData, err := BinaryCodec{}.Encode(msg)
+		assertNoErr(t, err)
+		assert.Less(t, len(binData)*4, len(jsonData))
+	})
+	t.Run("truncated message is rejected", func(t *testing.T) {
+		data, _ := BinaryCodec{}.Encode(opponentMessage(t))
+		for _, n := range []int{0, 1, 2, len(data) / 2, len(data) - 1} {
+			_, err := BinaryCodec{}.Decode(data[:n])
+			assert.ErrorIs(t, err, ErrMalformed, n)
+		}
+	})
+	t.Run("huge length does not allocate", func(t *testing.T) {
+		_, err := BinaryCodec{}.Decode([]byte{1, fInputs, 0xff, 0xff, 0xff, 0xff, 0x0f})
+		assert.ErrorIs(t, err, ErrMalformed)
+	})
+	t.Run("board sides that overflow", func(t *testing.T) {
+		opponent := byte(slices.Index(messageTypes, "opponent"))
+		data := binary.AppendUvarint([]byte{opponent}, fState)
+		data = binary.AppendUvarint(data, 1<<62)
+		data = binary.AppendUvarint(data, 4)
+		data = append(data, make([]byte, 8)...)
+		_, err := BinaryCodec{}.Decode(data)
+		assert.ErrorIs(t, err, ErrMalformed)
+
+		data = binary.AppendUvarint([]byte{opponent}, fState)
+		data = binary.AppendUvarint(data, 1000)
+		data = binary.AppendUvarint(data, 1)
+		data = append(data, make([]byte, 600)...)
+		_, err = BinaryCodec{}.Decode(data)
+		assert.ErrorIs(t, err, ErrMalformed, "taller than any board")
+	})
+	t.Run("values that don't fit", func(t *testing.T) {
+		msg := NewMessage("inputs")
+		msg.Payload.Inputs = []Input{{Frame: 1, Keys: []string{"teleport"}}}
+		_, err := BinaryCodec{}.Encode(msg)
+		assert.Error(t, err)
+
+		msg = NewMessage("opponent")
+		msg.Payload.BoardState.Board = [][]int{{16}}
+		_, err = BinaryCodec{}.Encode(msg)
+		assert.Error(t, err)
+	})
+	t.Run("negotiated codec", func(t *testing.T) {
+		assert.IsType(t, BinaryCodec{}, CodecFor(SubprotocolBinary))
+		assert.IsType(t, JSONCodec{}, CodecFor(SubprotocolJSON))
+		assert.IsType(t, JSONCodec{}, CodecFor(""))
+
+		codec, err := codecForFrame(websocket.TextMessage, BinaryCodec{})
+		assertNoErr(t, err)
+		assert.IsType(t, JSONCodec{}, codec)
+		codec, err = codecForFrame(websocket.BinaryMessage, BinaryCodec{})
+		assertNoErr(t, err)
+		assert.IsType(t, BinaryCodec{}, codec)
+		_, err = codecForFrame(websocket.BinaryMessage, JSONCodec{})
+		assert.ErrorIs(t, err, ErrMalformed, "binary frame on a json connection")
+	})
+}
+
+// BenchmarkOpponentTraffic encode the opponent board a versus room sends, each player every 3 ticks
+func BenchmarkOpponentTraffic(b *testing.B) {
+	msg := opponentMessage(b)
+	perSecond := float64(TICK/3) * 2
+	for _, bc := range []struct {
+		name  string
+		codec Codec
+	}{{"json", JSONCodec{}}, {"binary", BinaryCodec{}}} {
+		b.Run(bc.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				data, err := bc.codec.Encode(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+			b.ReportMetric(float64(size)*perSecond, "bytes/sec/room")
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package game
+
+import "slices"
+
+const (
+	KEYFRAME_INTERVAL = 30 // board updates between two full boards, ~3s with an update every 3 ticks
+	viewHistory       = 16 // sent boards kept per viewer while waiting for an ack
+)
+
+// RowDelta a changed board row, sent whole
+type RowDelta struct {
+	Row   int   `json:"row"`
+	Cells []int `json:"cells"`
+}
+
+// viewRequest come from the client watching this board: an ack of an update or a keyframe request
+type viewRequest struct {
+	viewer   string
+	seq      int
+	keyframe bool
+}
+
+// boardView is what one viewer has of this player's board. Deltas are computed against the last
+// board the viewer acked, so a lost update costs nothing more than the rows it changed
+type boardView struct {
+	seq      int // last sent
+	sent     [viewHistory]sentBoard
+	acked    [][]int
+	ackedSeq int
+	sinceKey int
+	wantKey  bool
+}
+type sentBoard struct {
+	seq   int
+	board [][]int
+}
+
+// sendBoard push this player's board to one viewer, as a keyframe ("opponent") or as the rows
+// changed since the viewer's last ack ("opponent-delta"). Both carry the active piece
+func (exec *FrameExecutor) sendBoard(viewer string, bs *BoardState, frame int, broadcast chan Packet) {
+	if exec.views == nil {
+		exec.views = make(map[string]*boardView)
+	}
+	v, ok := exec.views[viewer]
+	if !ok {
+		v = &boardView{}
+		exec.views[viewer] = v
+	}
+	v.seq++
+	var msg Message
+	dto := bs.ToDTO()
+	if v.acked == nil || v.wantKey || v.sinceKey >= KEYFRAME_INTERVAL || len(v.acked) != len(bs.board) {
+		msg = NewMessage("opponent")
+		v.sinceKey, v.wantKey = 0, false
+	} else {
+		msg = NewMessage("opponent-delta")
+		msg.Payload.BaseSeq = v.ackedSeq
+		msg.Payload.Rows = diffRows(v.acked, bs.board)
+		dto.Board = nil
+		v.sinceKey++
+	}
+	msg.PlayerId = exec.playerId
+	msg.Payload.Seq = v.seq
+	msg.Payload.LatestFrame = frame
+	msg.Payload.BoardState = dto
+	//frame queue slots are reused, keep our own copy for the next diff
+	v.sent[v.seq%viewHistory] = sentBoard{seq: v.seq, board: copySlice(bs.board)}
+
+	var packet Packet
+	packet.directId = viewer
+	packet.msg = msg
+	broadcast <- packet
+}
+
+// onView handle a viewer's ack or keyframe request, runs in the loop goroutine
+func (exec *FrameExecutor) onView(req viewRequest) {
+	v, ok := exec.views[req.viewer]
+	if !ok {
+		return
+	}
+	if req.keyframe {
+		v.wantKey = true
+		return
+	}
+	if req.seq <= v.ackedSeq || req.seq > v.seq {
+		return
+	}
+	sent := v.sent[req.seq%viewHistory]
+	if sent.seq != req.seq {
+		return //too old, a newer ack will come
+	}
+	v.acked, v.ackedSeq = sent.board, sent.seq
+}
+
+func diffRows(base, board [][]int) []RowDelta {
+	rows := []RowDelta{}
+	for r := range board {
+		if !slices.Equal(base[r], board[r]) {
+			rows = append(rows, RowDelta{Row: r, Cells: slices.Clone(board[r])})
+		}
+	}
+	return rows
+}
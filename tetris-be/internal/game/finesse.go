@@ -0,0 +1,168 @@
+package game
+
+import (
+	"fmt"
+	"slices"
+)
+
+const dasFrames = 6 // a horizontal key repeated within this many frames is DAS, one input
+
+// pieceMoves how the active piece got where it is, for T-spins and finesse. Propagated like the
+// rest of the state, reset when a piece spawns or is held
+type pieceMoves struct {
+	inputs     int // rotations and horizontal moves, a DAS run counts once
+	shift      int // direction of the last horizontal key, -1 or 1, pressed on shiftFrame
+	shiftFrame int
+	rotated    bool // the last move that changed anything was a rotation
+	softDrop   bool // tucks and spins need extra inputs, finesse isn't judged then
+}
+
+// track the keys of frame, after ApplyInputBuffer moved the piece from (row, col, form)
+func (m *pieceMoves) track(frame int, input InputBuffer, bs *BoardState, row, col, form int) {
+	if dir := shiftDir(input); dir != 0 {
+		if dir != m.shift || frame-m.shiftFrame > dasFrames {
+			m.inputs++
+		}
+		m.shift, m.shiftFrame = dir, frame
+	}
+	if input[rotate] || input[rrotate] {
+		m.inputs++
+	}
+	if input[down] {
+		m.softDrop = true
+	}
+	switch {
+	case bs.block.form != form:
+		m.rotated = true
+	case bs.cRow != row || bs.cCol != col:
+		m.rotated = false
+	}
+}
+
+func shiftDir(input InputBuffer) int {
+	switch {
+	case input[left] && !input[right]:
+		return -1
+	case input[right] && !input[left]:
+		return 1
+	}
+	return 0
+}
+
+// pieceID the Tetromino id of a shape, its cells hold it
+func pieceID(shape [][]int) int {
+	for _, row := range shape {
+		for _, cell := range row {
+			if cell != 0 {
+				return cell
+			}
+		}
+	}
+	return 0
+}
+
+// tSpin 3-corner rule on the board before the T locks: "full" with both corners in front of the
+// T filled, "mini" with only the back ones, "" otherwise
+func tSpin(bs *BoardState) string {
+	if pieceID(bs.block.shape) != 3 || !bs.moves.rotated {
+		return ""
+	}
+	r, c := bs.cRow+1, bs.cCol+1
+	filled := func(dr, dc int) bool {
+		row, col := r+dr, c+dc
+		if row < 0 {
+			return false
+		}
+		return row >= len(bs.board) || col < 0 || col >= len(bs.board[0]) || bs.board[row][col] != 0
+	}
+	corners := [4]bool{filled(-1, -1), filled(-1, 1), filled(1, 1), filled(1, -1)} // clockwise from top left
+	count := 0
+	for _, f := range corners {
+		if f {
+			count++
+		}
+	}
+	if count < 3 {
+		return ""
+	}
+	//form 0 points up: front corners are top left and top right, each form turns them clockwise
+	form := bs.block.form
+	if corners[form] && corners[(form+1)%4] {
+		return "full"
+	}
+	return "mini"
+}
+
+// fullRowsWithGarbage rows about to be cleared that hold garbage, the piece is placed already
+func fullRowsWithGarbage(board [][]int) int {
+	n := 0
+	for _, row := range board {
+		if !slices.Contains(row, 0) && slices.Contains(row, 8) {
+			n++
+		}
+	}
+	return n
+}
+
+type finesseState struct{ row, col, form int }
+
+// finesseOptimal fewest inputs from spawn to the spot the piece locked on, on an empty board:
+// taps, DAS to the wall and rotations each cost one
+func finesseOptimal(id int, shape [][]int, col int, settings RoomSettings) int {
+	target := footprint(shape, col)
+	board := CreateEmptyBoard(settings.Board)
+	start := &BoardState{board: board, block: Tetromino[id], cCol: settings.Board.SpawnCol(Tetromino[id].shape)}
+	seen := map[finesseState]bool{}
+	queue := []*BoardState{start}
+	for dist := 0; len(queue) > 0 && dist <= 16; dist++ {
+		var next []*BoardState
+		for _, bs := range queue {
+			state := finesseState{bs.cRow, bs.cCol, bs.block.form}
+			if seen[state] {
+				continue
+			}
+			seen[state] = true
+			if footprint(bs.block.shape, bs.cCol) == target {
+				return dist
+			}
+			for _, k := range []key{left, right, rotate, rrotate} {
+				moved := *bs
+				ApplyInputBuffer(nil, &moved, InputBuffer{k: true}, settings)
+				next = append(next, &moved)
+			}
+			for _, k := range []key{left, right} {
+				das := *bs
+				for {
+					col := das.cCol
+					ApplyInputBuffer(nil, &das, InputBuffer{k: true}, settings)
+					if das.cCol == col {
+						break
+					}
+				}
+				next = append(next, &das)
+			}
+		}
+		queue = next
+	}
+	return 0
+}
+
+// footprint the filled cells of shape at col, rows counted from its lowest one so the height it
+// locked at doesn't matter
+func footprint(shape [][]int, col int) string {
+	bottom := 0
+	for y, row := range shape {
+		if slices.ContainsFunc(row, func(v int) bool { return v != 0 }) {
+			bottom = y
+		}
+	}
+	cells := ""
+	for y, row := range shape {
+		for x, v := range row {
+			if v != 0 {
+				cells += fmt.Sprintf("%d,%d;", bottom-y, col+x)
+			}
+		}
+	}
+	return cells
+}
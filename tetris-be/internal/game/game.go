@@ -4,14 +4,51 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math/rand"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Game struct {
+	settings    RoomSettings
 	players     map[string]*FrameExecutor
-	isPlaying   bool
-	delayBuffer int //fixed value, refactor later
+	playersMu   sync.RWMutex // Init swaps players, the player goroutines look them up
+	starting    sync.Mutex   // one Init or StartGame at a time
+	isPlaying   atomic.Bool  // read by room goroutine, must not take mu
+	delayBuffer int          //input delay in frames, computed from both players' clock sync on Init
+	startAt     time.Time    //server time of frame 0
+
+	mu       sync.Mutex // serialize pause/unpause/stop fan-out to the loops
+	isPaused bool
+
+	antiCheat AntiCheatConfig
+	clock     Clock // given to every loop, a ManualClock steps the match by hand
+	//reported from the loops, own lock: mu is held while waiting on a loop
+	violationsMu sync.Mutex
+	violations   map[string]int // per player, reset by Init
+	violationLog []Violation
+	onResult     func(MatchResult) // nil drops results, set by the room manager
+	onStatus     func(RoomStatus)  // nil outside a room
+	loops        sync.WaitGroup    // Run of every loop started
+	ending       sync.Mutex        // held by forfeit, End waits for it
+}
+
+// MatchResult how a match ended, reported once per match
+type MatchResult struct {
+	RoomID  string
+	Mode    string
+	Winner  string // empty in solo
+	Loser   string
+	Reason  string
+	Ranked  bool
+	Players map[string]Stats
+	EndedAt time.Time
+	//per player count, and the first reasons of the match
+	Violations   map[string]int
+	ViolationLog []Violation
 }
 type FrameExecutor struct {
 	playerId string
@@ -22,133 +59,329 @@ type FrameExecutor struct {
 		updates—  the server will auto simulate the frames state for that duration.
 	*/
 	netFrame  int //last frame received from client
+	delay     int //input delay of the match, frames the client may lag before being auto simulated
 	listBlock []int
+	settings  RoomSettings
 	opponentC chan Attack
-	mu        sync.Mutex
+	log       MatchLog // final frames, compacted: the inputs a reattached client missed
+	//last frame whose attack was handed to the opponent
+	flushedFrame int
+	checksums    []Input // client checksums waiting for their frame to be final
+	desyncs      int
+	//input batches, highest contiguous seq and the ones received after a gap
+	inputSeq int
+	seqAhead map[int]bool
+	//players receiving this board, and what each of them has
+	viewers []string
+	views   map[string]*boardView
+	//input rate in the current second, violations go to the match
+	clock       Clock // the match clock, nil until wired: simulated frames count as time
+	rateWindow  int64
+	rateCount   int
+	onViolation func(Violation)
+	onGameOver  func() // topped out, the match decides who won
+	stats       statsTally
+	holes       *rand.Rand // garbage hole columns, nil uses the global source
+	bot         *Bot       // server side player, presses its keys from onUpdate
+	mu          sync.Mutex
 }
 
-func NewFrameExecutor(playerId string) *FrameExecutor {
+func NewFrameExecutor(playerId string, settings RoomSettings) *FrameExecutor {
 	return &FrameExecutor{
 		playerId:  playerId,
-		frames:    NewQueue(QUEUE_SIZE),
+		frames:    NewQueue(QUEUE_SIZE, settings),
 		netFrame:  0,
+		delay:     defaultInputDelay,
 		listBlock: make([]int, 0),
+		settings:  settings,
+		stats:     newStatsTally(),
 	}
 }
 
+const maxSeqAhead = 64 // input batches tracked past a missing one
+
 var ErrGameOver = errors.New("game over")
 var ErrOutOfRange = errors.New("out of range")
 
-func NewGame() *Game {
+func NewGame(settings RoomSettings, antiCheat AntiCheatConfig, clock Clock) *Game {
+	if clock == nil {
+		clock = realClock{}
+	}
 	return &Game{
+		settings:    settings,
+		antiCheat:   antiCheat,
+		clock:       clock,
 		players:     map[string]*FrameExecutor{},
-		isPlaying:   false,
-		delayBuffer: 4, //2 frames
-
+		delayBuffer: defaultInputDelay,
+		violations:  map[string]int{},
 	}
 }
 func (g *Game) Rematch() {
 	//TODO gọi lại Init -> start lại match mới
 }
 func (g *Game) Init(broadcast chan Packet, conns map[string]*PlayerConn, sender string) {
+	g.starting.Lock()
+	defer g.starting.Unlock()
+	players := map[string]*FrameExecutor{}
 	playerCount := 0
 	for playerId, conn := range conns {
-		g.players[playerId] = NewFrameExecutor(playerId)
+		players[playerId] = NewFrameExecutor(playerId, g.settings)
 		if conn != nil {
 			playerCount++
 		}
+		if conn != nil && conn.bot != nil {
+			players[playerId].bot = NewBot(*conn.bot, time.Now().UnixNano())
+		}
 	}
-	if playerCount < 2 {
+	//the room checks it too, a StartGame may have won the race since
+	if g.isPlaying.Load() || playerCount < g.settings.Capacity {
 		var packet Packet
 		body := NewMessage("start")
 		body.Error = "cannot start"
-		packet.body = MarshalMessage(body)
+		packet.msg = body
 		packet.directId = sender
 		broadcast <- packet
 
 		return
 	}
 
-	//init data for game state: list block for player
+	g.resetViolations()
+	g.computeDelayBuffer(conns)
+
+	g.playersMu.Lock()
+	g.players = players
+	g.wire(broadcast)
+	g.playersMu.Unlock()
+	g.setStatus(RoomCountdown)
+	//"start" goes out last, a bot answers it right away and StartGame must see every loop ready
 	for pId, exec := range g.players {
-		exec.listBlock = GenerateList_7bag(exec.listBlock, 1000)
-		exec.gl = NewGameLoop(exec.onUpdate, exec.recordInputs, exec.receiveGarbage)
 		list := exec.listBlock
 		body := NewMessage("start")
 		body.Payload.ListBlock = list
+		body.Payload.InputDelay = g.delayBuffer
+		//startAt in the client's own clock so both begin frame 0 at the same instant
+		startAt := g.startAt.UnixMilli()
+		if conn := conns[pId]; conn != nil && conn.clock != nil {
+			_, _, offset, _ := conn.clock.Estimate()
+			startAt += int64(offset)
+		}
+		body.Payload.StartAt = startAt
 		var packet Packet
 		packet.directId = pId
 
-		packet.body = MarshalMessage(body)
+		packet.msg = body
 
 		broadcast <- packet
 	}
-outer:
-	for p1, exec := range g.players {
-		for p2, exec2 := range g.players {
+}
+
+// wire the loops of every player into the match, shared by Init and the Harness
+func (g *Game) wire(broadcast chan Packet) {
+	//init data for game state: list block for player
+	for _, exec := range g.players {
+		exec.listBlock = exec.settings.GenerateList(exec.listBlock, 1000)
+		exec.gl = NewGameLoop(exec.onUpdate, exec.recordInputs, exec.receiveGarbage, exec.sendSnapshot, exec.onView)
+		exec.gl.clock = g.clock
+		exec.clock = g.clock
+		exec.gl.onStart = func() { g.setStatus(RoomPlaying) }
+		exec.delay = g.delayBuffer
+		exec.onViolation = func(v Violation) { g.Report(v, broadcast) }
+		//own goroutine: forfeit stops every loop, this one included
+		exec.onGameOver = func() { go g.forfeit(exec.playerId, exec.playerId+" topped out", broadcast) }
+	}
+	ids := slices.Sorted(maps.Keys(g.players))
+	for _, p1 := range ids {
+		for _, p2 := range ids {
 			if p1 != p2 {
-				exec.opponentC = exec2.gl.attacked
-				exec2.opponentC = exec.gl.attacked
-				break outer
+				g.players[p1].viewers = append(g.players[p1].viewers, p2)
 			}
 		}
 	}
-	//startTime := time.Now().Add(time.Second * 2).UnixMilli()
-	//body.Payload.StartAt = startTime
-	//init first state at tickFrame 0
-
+	//versus only, the first two trade attacks
+	if len(ids) >= 2 {
+		exec, exec2 := g.players[ids[0]], g.players[ids[1]]
+		exec.opponentC = exec2.gl.attacked
+		exec2.opponentC = exec.gl.attacked
+	}
 }
+
+// StartGame is sent back by every client after "start", only the first one launches the loops.
+// Loops wait until startAt so frame 0 is simulated when clients begin it
 func (g *Game) StartGame(broadcast chan Packet) {
+	g.starting.Lock()
+	defer g.starting.Unlock()
+	if len(g.players) == 0 {
+		return
+	}
 	for _, exec := range g.players {
-		list := exec.listBlock
-		firstState := NewBoardState(CreateEmptyBoard(), 0, Tetromino[list[0]], 0, 0, 4, true, DROPSPEED,
-			make(InputBuffer), 0, false)
-		exec.frames.data[0] = firstState
-		exec.netFrame = 1
-		go exec.gl.Run(broadcast)
+		if exec.gl == nil {
+			return //Init refused to start
+		}
 	}
+	if !g.isPlaying.CompareAndSwap(false, true) {
+		return
+	}
+	for _, exec := range g.players {
+		exec.start()
+		g.loops.Add(1)
+		go func() {
+			defer g.loops.Done()
+			exec.gl.Run(broadcast, g.startAt)
+		}()
+	}
+
+}
+
+// start put the first piece of the list on an empty board as frame 0
+func (exec *FrameExecutor) start() {
+	first := Tetromino[exec.listBlock[0]]
+	exec.frames.data[0] = NewBoardState(CreateEmptyBoard(exec.settings.Board), 0, first, 0, 0, exec.settings.Board.SpawnCol(first.shape), true, exec.settings.Gravity,
+		make(InputBuffer), 0, false)
+	exec.netFrame = 1
+}
+func (g *Game) IsPlaying() bool {
+	return g.isPlaying.Load()
+}
 
+// player the executor of playerId with its loop, nil before Init wired it
+func (g *Game) player(playerId string) *FrameExecutor {
+	g.playersMu.RLock()
+	defer g.playersMu.RUnlock()
+	exec, ok := g.players[playerId]
+	if !ok || exec.gl == nil {
+		return nil
+	}
+	return exec
+}
+
+// Reattach asks the player's loop to push a full state snapshot and the confirmed inputs after ackFrame
+func (g *Game) Reattach(playerId string, ackFrame int) {
+	if exec := g.player(playerId); exec != nil {
+		exec.gl.RequestSnapshot(ackFrame)
+	}
 }
-func (g *Game) computeDelayBuffer(msg Message, broadcast chan Packet) {
-	msg.Type = "ping"
-	now := time.Now().UnixMilli()
+
+// Forfeit ends the match, the other player wins
+func (g *Game) Forfeit(loser string, broadcast chan Packet) {
+	g.forfeit(loser, fmt.Sprintf("%s did not reconnect in time", loser), broadcast)
+}
+func (g *Game) forfeit(loser string, reason string, broadcast chan Packet) {
+	g.ending.Lock()
+	defer g.ending.Unlock()
+	if !g.isPlaying.CompareAndSwap(true, false) {
+		return
+	}
+	g.Stop()
+	msg := NewMessage("gameover")
+	for pId := range g.players {
+		if pId != loser {
+			msg.PlayerId = pId
+		}
+	}
+	msg.Error = reason
+	//final stats first, the client shows them on the gameover screen. Sorted so a replay
+	//sends them in the same order
+	for _, pId := range slices.Sorted(maps.Keys(g.players)) {
+		g.players[pId].sendStats(broadcast)
+	}
 	var packet Packet
-	msg.Timestamp = now
+	packet.msg = msg
+	broadcast <- packet
+	g.setStatus(RoomFinished)
+	if g.onResult == nil {
+		return
+	}
+	res := MatchResult{
+		Mode:    g.settings.Mode,
+		Winner:  msg.PlayerId,
+		Loser:   loser,
+		Reason:  reason,
+		Ranked:  g.settings.Ranked,
+		Players: map[string]Stats{},
+		EndedAt: g.clock.Now(),
+	}
+	for pId, exec := range g.players {
+		res.Players[pId] = exec.stats.get()
+	}
+	res.Violations, res.ViolationLog = g.ViolationCounts(), g.Violations()
+	g.onResult(res)
+}
+
+func (g *Game) setStatus(status RoomStatus) {
+	if g.onStatus != nil {
+		g.onStatus(status)
+	}
+}
+
+// computeDelayBuffer derive the match input delay and start time from every player's clock sync estimate
+func (g *Game) computeDelayBuffer(conns map[string]*PlayerConn) {
+	var clocks []*ClockSync
+	for pId, conn := range conns {
+		if conn == nil || conn.clock == nil {
+			continue
+		}
+		clocks = append(clocks, conn.clock)
+		rtt, jitter, offset, samples := conn.clock.Estimate()
+		log.Printf("[%s] clock sync rtt=%.1fms jitter=%.1fms offset=%.1fms samples=%d", pId, rtt, jitter, offset, samples)
+	}
+	g.delayBuffer = InputDelay(clocks...)
+	g.startAt = g.clock.Now().Add(startLead(clocks...))
+}
+
+// pong answer a client initiated ping NTP style, the client compute its own rtt/offset from
+// timestamp (its send time, echoed) and serverTime
+func (g *Game) pong(msg Message, playerId string, broadcast chan Packet) {
+	reply := NewMessage("pong")
+	reply.Timestamp = msg.Timestamp
+	reply.Payload.ServerTime = g.clock.Now().UnixMilli()
+	var packet Packet
+	packet.directId = playerId
+	packet.msg = reply
 	broadcast <- packet
 }
 func (exec *FrameExecutor) onUpdate(broadcast chan Packet) {
 	frameQueue := exec.frames
+	if exec.bot != nil {
+		exec.botPlay(broadcast)
+	}
 	//update current tickFrame if client inactive in sending messages
 
-	if exec.netFrame+4 < exec.gl.tickFrame {
+	if exec.netFrame+exec.delay < exec.gl.tickFrame {
 		exec.netFrame++
 	}
 	//update tickFrame depends on state.curFrame
 	err := exec.computeBatchFrames(frameQueue.simFrame+1, exec.netFrame, broadcast) //
 	if errors.Is(err, ErrGameOver) {
-		//todo stop cho cả 2 players
-		exec.Stop()
-		var packet Packet
-		msg := NewMessage("gameover")
-		msg.PlayerId = "winner id"
-		packet.body = MarshalMessage(msg)
-		broadcast <- packet
+		exec.gameOver(broadcast)
+		return
+	}
+	exec.flushAttacks(broadcast)
+	exec.verifyChecksums(broadcast)
+	if exec.gl.tickFrame%TICK == 0 {
+		exec.sendStats(broadcast)
 	}
 	if exec.gl.tickFrame%3 == 0 {
-		msg := NewMessage("opponent") //temp type
-		var packet Packet
 		ps, err := frameQueue.Get(frameQueue.simFrame)
 		if err != nil || ps == nil {
-			log.Printf("something wrong with BoardState %s\n", err.Error())
+			log.Printf("something wrong with BoardState %v\n", err)
+			return
+		}
+		for _, viewer := range exec.viewers {
+			exec.sendBoard(viewer, ps, frameQueue.simFrame, broadcast)
 		}
-		msg.Payload.BoardState = BoardStateDTO{ps.board, ps.block.shape, ps.cRow, ps.cCol}
-		msg.Payload.LatestFrame = frameQueue.simFrame
-		packet.excludeId = exec.playerId
-		packet.body = MarshalMessage(msg)
-		broadcast <- packet
 	}
 
 }
+func (exec *FrameExecutor) gameOver(broadcast chan Packet) {
+	exec.Stop()
+	if exec.onGameOver != nil {
+		exec.onGameOver()
+		return
+	}
+	var packet Packet
+	packet.msg = NewMessage("gameover")
+	broadcast <- packet
+}
 
 func (exec *FrameExecutor) computeBatchFrames(fromFrame, toFrame int, broadcast chan Packet) error {
 
@@ -159,150 +392,271 @@ func (exec *FrameExecutor) computeBatchFrames(fromFrame, toFrame int, broadcast
 	}
 
 	for frame := fromFrame; frame <= toFrame; frame++ {
+		if err := exec.simulateFrame(frame, false, broadcast); err != nil {
+			return err
+		}
+		fq.Forward()
+	}
 
-		//TODO log all frames inputBuffer here for deterministic replay
-		bs, _ := fq.Get(frame)
-		previous, _ := fq.Get(frame - 1)
-		PropagateState(previous, bs)
-		//apply garbage
-		//ps.cRow cũng sẽ bị đẩy lên
-		garbage := fq.GetGarbage(frame)
-		bs.cancel = min(fq.GetCancel(frame)+bs.cancel, 0)
-		if garbage > 0 {
-			garbage, bs.cancel = max(garbage+bs.cancel, 0), min(bs.cancel+garbage, 0)
-			fmt.Printf("[%s] receive %d garbage lines at frame: %d \n", exec.playerId, garbage, frame)
-			TakeGarbage(garbage, bs.board)
-			bs.cRow = max(1, bs.cRow-garbage)
-			if garbage > 0 {
-				msg := NewMessage("garbage-sync")
-				var packet Packet
-				msg.Payload.BoardState = BoardStateDTO{bs.board, bs.block.shape, bs.cRow, bs.cCol}
-				msg.Payload.LatestFrame = frame - 1
-				packet.directId = exec.playerId
-				packet.body = MarshalMessage(msg)
-				broadcast <- packet
-
-			}
+	return nil
+}
 
-			if CheckGameOver(bs.board, bs.block.shape, bs.cRow, bs.cCol) {
-				return errors.New("game over")
-			}
+// rollback re-simulate every frame from fromFrame up to simFrame after a late input changed fromFrame,
+// then push the corrected state to the client
+func (exec *FrameExecutor) rollback(fromFrame int, broadcast chan Packet) error {
+	fq := exec.frames
+	for frame := fromFrame; frame <= fq.simFrame; frame++ {
+		if err := exec.simulateFrame(frame, true, broadcast); err != nil {
+			return err
 		}
-		//apply input
-		input := bs.inputBuffer
-		hasSpin := input[rotate] || input[rrotate]
-		if len(input) > 0 {
-			ApplyInputBuffer(exec.listBlock, bs, input)
-		}
-		//clean Input buffer
+	}
+	bs, err := fq.Get(fq.simFrame)
+	if err != nil {
+		return err
+	}
+	msg := NewMessage("server-state")
+	msg.Payload.BoardState = bs.ToDTO()
+	msg.Payload.LatestFrame = fq.simFrame
+	var packet Packet
+	packet.directId = exec.playerId
+	packet.msg = msg
+	broadcast <- packet
+	return nil
+}
+
+// simulateFrame compute state of frame from frame-1. A replay re-runs an already simulated frame
+// after a late input, it must not talk to the client again: the rollback sends the corrected state
+func (exec *FrameExecutor) simulateFrame(frame int, replay bool, broadcast chan Packet) error {
+	fq := exec.frames
+	bs, _ := fq.Get(frame)
+	previous, _ := fq.Get(frame - 1)
+	PropagateState(previous, bs)
+
+	if bs.events.frame != frame {
+		//slot still holds frame-cap, no input was recorded for this frame
 		bs.inputBuffer = InputBuffer{}
-		landingRow := FindLandingPosition(bs.board, bs.block.shape, bs.cRow, bs.cCol)
-		//gravity drop
-
-		if !bs.onGround {
-			bs.gravityTimer += INTERVAL
-			if bs.gravityTimer >= float64(bs.dropSpeed) {
-				if bs.cRow < landingRow {
-					bs.cRow++
-					bs.gravityTimer -= float64(bs.dropSpeed)
-				}
-				if bs.cRow >= landingRow {
-					bs.onGround = true
-				}
-			}
-		} else {
-			bs.lockTimer += INTERVAL
+	}
+	bs.events = frameEvents{frame: frame}
+	//apply input
+	input := bs.inputBuffer
+	hasSpin := input[rotate] || input[rrotate]
+	if len(input) > 0 {
+		row, col, form, canHold := bs.cRow, bs.cCol, bs.block.form, bs.canHold
+		ApplyInputBuffer(exec.listBlock, bs, input, exec.settings)
+		if !(canHold && !bs.canHold) { //a held piece starts over
+			bs.moves.track(frame, input, bs, row, col, form)
 		}
-		//commit phase:
-		if bs.onGround {
+	}
+	landingRow := FindLandingPosition(bs.board, bs.block.shape, bs.cRow, bs.cCol)
+	//gravity drop
+
+	if !bs.onGround {
+		bs.gravityTimer += INTERVAL
+		if bs.gravityTimer >= float64(bs.dropSpeed) {
 			if bs.cRow < landingRow {
-				bs.onGround = false
-				bs.lockTimer = 0
+				bs.cRow++
+				bs.gravityTimer -= float64(bs.dropSpeed)
+				bs.moves.rotated = false
+			}
+			if bs.cRow >= landingRow {
+				bs.onGround = true
+			}
+		}
+	} else {
+		bs.lockTimer += INTERVAL
+	}
+	//commit phase:
+	if bs.onGround {
+		if bs.cRow < landingRow {
+			bs.onGround = false
+			bs.lockTimer = 0
 
+		}
+		if bs.lockTimer >= exec.settings.LockDelay {
+			bs.events.tSpin = tSpin(bs)
+			bs.events.finesse = -1
+			if !bs.moves.softDrop {
+				optimal := finesseOptimal(pieceID(bs.block.shape), bs.block.shape, bs.cCol, exec.settings)
+				bs.events.finesse = max(bs.moves.inputs-optimal, 0)
+			}
+			PlaceBlock(bs.board, bs.block.shape, bs.cRow, bs.cCol)
+			bs.events.garbage = fullRowsWithGarbage(bs.board)
+			//clear lines then reset timer spawn new piece(block)
+			lines := ClearLines(bs.board)
+			bs.events.locked, bs.events.cleared = true, lines
+			b2bType := "none"
+			if hasSpin {
+				b2bType = fmt.Sprintf("spin-%t:%d", hasSpin, lines)
+			}
+			if lines == 4 {
+				b2bType = "clear4"
 			}
-			if bs.lockTimer >= LOCKDELAY {
-				PlaceBlock(bs.board, bs.block.shape, bs.cRow, bs.cCol)
-				//clear lines then reset timer spawn new piece(block)
-				lines := ClearLines(bs.board)
-				b2bType := "none"
-				if hasSpin {
-					b2bType = fmt.Sprintf("spin-%t:%d", hasSpin, lines)
-				}
-				if lines == 4 {
-					b2bType = "clear4"
-				}
 
-				if bs.b2b != b2bType {
-					bs.b2b = b2bType
+			if bs.b2b != b2bType {
+				bs.b2b = b2bType
+			}
+			if lines > 0 {
+				perfect := isPerfect(bs.board)
+				b2bFlag := (bs.b2b == b2bType) && (bs.b2b != "none")
+				bs.b2b = b2bType
+				garbageSent := AttackTables[exec.settings.AttackTable].CalculateGarbageRows(lines, hasSpin, bs.combo, b2bFlag, perfect)
+				//cancel the oldest incoming attacks first, what is left goes to the opponent
+				bs.events.cancelled = fq.garbage.take(frame, &bs.garbageTaken, garbageSent, false)
+				for _, chunk := range bs.events.cancelled {
+					garbageSent -= chunk.lines
 				}
-				if lines > 0 {
-					perfect := isPerfect(bs.board)
-					b2bFlag := (bs.b2b == b2bType) && (bs.b2b != "none")
-					bs.b2b = b2bType
-					garbageSent := CalculateGarbageRows(lines, hasSpin, bs.combo, b2bFlag, perfect)
-					bs.send += garbageSent
-					fq.CancelGarbage(frame, garbageSent)
-					bs.combo++
-				} else {
-					bs.b2b = "none"
-					bs.combo = 0
-					//TODO combo-end here starting send garbage (delay in 45 frame from this frame )
-					// send message to client
-					if bs.send > 0 {
-						fmt.Printf("[%s] send garbage at frame: %d \n", exec.playerId, frame)
-						exec.opponentC <- Attack{lines: bs.send, atFrame: frame}
-					}
-					bs.send = 0
+				bs.send += garbageSent
+				bs.combo++
+			} else {
+				bs.b2b = "none"
+				bs.combo = 0
+				//combo-end here starting send garbage (delay in 45 frame from this frame),
+				//sent to opponent by flushAttacks once this frame can't be rolled back anymore
+				bs.events.attack = bs.send
+				bs.send = 0
+				//a piece locked without clearing: arrived garbage rises, at most GarbageCap lines per piece
+				for _, chunk := range fq.garbage.take(frame, &bs.garbageTaken, exec.settings.GarbageCap, true) {
+					TakeGarbageAt(chunk.lines, chunk.entry.Hole, bs.board)
+					bs.events.landed += chunk.lines
 				}
+			}
 
-				SpawnNewPiece(exec.listBlock, bs)
-				//check game over
-				if CheckGameOver(bs.board, bs.block.shape, bs.cRow, bs.cCol) {
-					return errors.New("game over")
-				}
+			SpawnNewPiece(exec.listBlock, bs, exec.settings.Board)
+			if bs.events.landed > 0 && !replay {
+				msg := NewMessage("garbage-sync")
+				var packet Packet
+				msg.Payload.BoardState = bs.ToDTO()
+				msg.Payload.LatestFrame = frame
+				packet.directId = exec.playerId
+				packet.msg = msg
+				broadcast <- packet
+			}
+			//check game over
+			if CheckGameOver(bs.board, bs.block.shape, bs.cRow, bs.cCol) {
+				return ErrGameOver
 			}
 		}
-
-		fq.Forward()
 	}
-
+	if frame%CHECKSUM_INTERVAL == 0 {
+		bs.events.checksum = bs.Checksum()
+	}
 	return nil
 }
 
+// flushAttacks send attacks of frames older than ROLLBACK_WINDOW, those frames are final
+func (exec *FrameExecutor) flushAttacks(broadcast chan Packet) {
+	fq := exec.frames
+	for frame := exec.flushedFrame + 1; frame <= fq.simFrame-ROLLBACK_WINDOW; frame++ {
+		exec.flushedFrame = frame
+		bs, err := fq.Get(frame)
+		if err != nil || bs.events.frame != frame {
+			continue
+		}
+		exec.log.commit(frame, bs)
+		exec.stats.count(frame, bs.events, exec.settings.Mode)
+		for _, chunk := range bs.events.cancelled {
+			exec.sendAttackEvent("attack-cancelled", AttackDTO{Amount: chunk.lines, Source: chunk.entry.Source, Frame: chunk.entry.Arrival}, broadcast)
+		}
+		//a rollback restarts at most from this frame, it reads the count of the frame before
+		if previous, err := fq.Get(frame - 1); err == nil {
+			fq.garbage.prune(previous.garbageTaken)
+		}
+		if bs.events.attack == 0 || exec.opponentC == nil {
+			continue
+		}
+		select {
+		case exec.opponentC <- Attack{source: exec.playerId, lines: bs.events.attack, atFrame: frame}:
+		case <-exec.gl.quit:
+			return
+		}
+	}
+}
+
 // record inputs store inputBuffer event correspond tickFrame # and  server
-func (exec *FrameExecutor) recordInputs(inputs []Input, latestFrame int, broadcast chan Packet) {
+// The reply goes to the sender only: confirmed keys, the highest contiguous batch seq received
+// and the frames that were rejected, so the client can drop confirmed predictions and resend the rest
+func (exec *FrameExecutor) recordInputs(seq int, inputs []Input, latestFrame int, broadcast chan Packet) {
 	//ghi nhận lại inputBuffer và kể cả tickFrame ko có inputBuffer của client
 	//=> để server biết được cần phải update state tới tickFrame nào
 	var packet Packet
 	msg := NewMessage("input-server")
 	fqueue := exec.frames
+	rollbackFrom := -1
+	stalled := false
+	reject := func(frame int) {
+		msg.Payload.Rejected = append(msg.Payload.Rejected, frame)
+	}
+	bound := exec.currentTick() + maxFrameLead
+	flooding := !exec.allowInputs(len(inputs))
+	if flooding {
+		exec.violate(latestFrame, fmt.Sprintf("more than %d inputs per second", maxInputsPerSecond))
+	}
+	inBatch := make(map[int]bool, len(inputs))
 
 	for _, input := range inputs {
+		if flooding {
+			reject(input.Frame)
+			continue
+		}
+		if reason := validateKeys(input.Keys); reason != "" {
+			exec.violate(input.Frame, reason)
+			reject(input.Frame)
+			continue
+		}
+		if input.Frame > bound {
+			exec.violate(input.Frame, fmt.Sprintf("input for frame %d ahead of server frame %d", input.Frame, exec.currentTick()))
+			reject(input.Frame)
+			continue
+		}
+		if inBatch[input.Frame] {
+			exec.violate(input.Frame, "frame repeated in one batch")
+			reject(input.Frame)
+			continue
+		}
+		inBatch[input.Frame] = true
 
 		serverConfirmedKeys := []string{}
 		frame := input.Frame
 		keys := input.Keys
+		late := frame <= fqueue.simFrame
+		//simFrame-ROLLBACK_WINDOW is final already, see flushAttacks
+		if late && (fqueue.simFrame-frame >= ROLLBACK_WINDOW || frame <= 0) {
+			log.Printf("[%s] input at frame %d arrived too late to roll back, server frame: %d", exec.playerId, frame, fqueue.simFrame)
+			stalled = true
+			reject(frame)
+			continue
+		}
 		ps, err := fqueue.Get(frame)
 		if err != nil {
-			if frame < exec.netFrame {
-				//log.Printf("Input at frame %d arrived late — skip. Current server frame: %d", frame, exec.netFrame)
-			}
-
-			if errors.Is(err, ErrOutOfRange) {
-				log.Printf("invalid frame counter:%d something wrong \n", frame)
-			}
-			//TODO sync clock message here
-			return
+			log.Printf("invalid frame counter:%d something wrong \n", frame)
+			stalled = true
+			reject(frame)
+			continue
 		}
 		if ps == nil {
 			log.Printf("frame %d : nil player state, something went wrong!\n", frame)
 			return
 		}
+		//only frames the server hashes, once each
+		if input.Checksum != 0 && frame%CHECKSUM_INTERVAL == 0 &&
+			(len(exec.checksums) == 0 || exec.checksums[len(exec.checksums)-1].Frame < frame) {
+			exec.checksums = append(exec.checksums, Input{Frame: frame, Checksum: input.Checksum})
+		}
 
-		ps.inputBuffer = InputBuffer{}
+		received := InputBuffer{}
 		for _, key_event := range keys {
-			ps.inputBuffer[key(key_event)] = true
+			received[key(key_event)] = true
+		}
+		if ps.events.frame != frame {
+			//stale slot from frame-cap
+			ps.events = frameEvents{frame: frame}
+			ps.inputBuffer = InputBuffer{}
+		}
+		changed := !maps.Equal(received, ps.inputBuffer)
+		if late && changed && (rollbackFrom == -1 || frame < rollbackFrom) {
+			rollbackFrom = frame
 		}
+		ps.inputBuffer = received
 		for k, v := range ps.inputBuffer {
 			if v == true {
 				serverConfirmedKeys = append(serverConfirmedKeys, string(k))
@@ -315,29 +669,162 @@ func (exec *FrameExecutor) recordInputs(inputs []Input, latestFrame int, broadca
 
 	}
 
+	//LatestFrame only moves forward and never past what the client could have played
+	if latestFrame > bound {
+		exec.violate(latestFrame, fmt.Sprintf("latest frame %d ahead of server frame %d", latestFrame, exec.currentTick()))
+		latestFrame = bound
+	}
 	exec.mu.Lock()
-	exec.netFrame = latestFrame
+	netFrame := exec.netFrame
+	exec.netFrame = max(exec.netFrame, latestFrame)
 	exec.mu.Unlock()
-	if len(msg.Payload.Inputs) > 0 {
-		packet.body = MarshalMessage(msg)
+	if latestFrame < netFrame {
+		exec.violate(latestFrame, fmt.Sprintf("latest frame %d behind %d", latestFrame, netFrame))
+	}
+	msg.Payload.Seq = exec.ackSeq(seq)
+	if seq != 0 || len(msg.Payload.Inputs) > 0 || len(msg.Payload.Rejected) > 0 {
+		packet.directId = exec.playerId
+		packet.msg = msg
 		broadcast <- packet
 	}
+	if rollbackFrom != -1 {
+		if err := exec.rollback(rollbackFrom, broadcast); errors.Is(err, ErrGameOver) {
+			exec.gameOver(broadcast)
+			return
+		}
+	}
+	//client stalled past the rollback window, its prediction can't be fixed: jump it to the server state
+	if stalled {
+		exec.resync(broadcast)
+	}
+
+}
+
+// ackSeq record batch seq and return the highest seq received without a gap before it.
+// seq 0 is a client that doesn't number its batches
+func (exec *FrameExecutor) ackSeq(seq int) int {
+	if seq <= exec.inputSeq || seq > exec.inputSeq+maxSeqAhead {
+		return exec.inputSeq
+	}
+	if exec.seqAhead == nil {
+		exec.seqAhead = make(map[int]bool)
+	}
+	exec.seqAhead[seq] = true
+	for exec.seqAhead[exec.inputSeq+1] {
+		delete(exec.seqAhead, exec.inputSeq+1)
+		exec.inputSeq++
+	}
+	return exec.inputSeq
+}
+func (exec *FrameExecutor) receiveGarbage(atk Attack, broadcast chan Packet) {
+	fq := exec.frames
+	entry := GarbageEntry{
+		Source:    atk.source,
+		Lines:     atk.lines,
+		Hole:      exec.hole(),
+		Arrival:   atk.atFrame + fq.incoming,
+		scheduled: fq.simFrame,
+	}
+	fq.garbage.Push(entry)
+	exec.log.receive(entry)
+	exec.sendAttackEvent("attack-incoming", AttackDTO{Amount: entry.Lines, Source: entry.Source, Frame: entry.Arrival}, broadcast)
+}
+
+func (exec *FrameExecutor) hole() int {
+	if exec.holes != nil {
+		return exec.holes.Intn(exec.settings.Board.Width)
+	}
+	return rand.Intn(exec.settings.Board.Width)
+}
+
+// sendSnapshot send current state of the simulation and every confirmed input after ackFrame
+// so a reconnected client can rebuild its prediction from there
+func (exec *FrameExecutor) sendSnapshot(ackFrame int, broadcast chan Packet) {
+	fq := exec.frames
+	bs, err := fq.Get(fq.simFrame)
+	if err != nil || bs == nil {
+		log.Printf("[%s] cannot snapshot frame %d: %v\n", exec.playerId, fq.simFrame, err)
+		return
+	}
+	msg := NewMessage("resume")
+	msg.PlayerId = exec.playerId
+	msg.Payload.LatestFrame = fq.simFrame
+	msg.Payload.ListBlock = exec.listBlock
+	msg.Payload.BoardState = bs.ToDTO()
+	msg.Payload.Inputs = exec.inputsSince(ackFrame)
+	//client continue from the snapshot, don't auto simulate frames it never played
+	exec.mu.Lock()
+	exec.netFrame = fq.simFrame
+	exec.mu.Unlock()
 
+	var packet Packet
+	packet.directId = exec.playerId
+	packet.msg = msg
+	broadcast <- packet
 }
-func (exec *FrameExecutor) receiveGarbage(atk Attack) {
-	exec.frames.GarbageUpcoming(atk.atFrame, atk.lines)
+
+// inputsSince confirmed inputs after frame: final frames from the match log, the rest from the ring
+func (exec *FrameExecutor) inputsSince(frame int) []Input {
+	fq := exec.frames
+	inputs := exec.log.Inputs(frame, exec.flushedFrame)
+	for f := max(frame, exec.flushedFrame) + 1; f < fq.simFrame+fq.cap/2; f++ {
+		bs, err := fq.Get(f)
+		if err != nil || bs.events.frame != f {
+			continue
+		}
+		if keys := keyMask(bs.inputBuffer); keys != 0 {
+			inputs = append(inputs, Input{Frame: f, Keys: maskKeys(keys)})
+		}
+	}
+	return inputs
 }
+
 func (g *Game) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.isPlaying.Load() || g.isPaused {
+		return
+	}
+	g.isPaused = true
 	for _, exec := range g.players {
-		exec.gl.pause <- struct{}{}
+		exec.gl.Pause()
 	}
 
 }
 func (g *Game) Unpause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.isPaused {
+		return
+	}
+	g.isPaused = false
 	for _, exec := range g.players {
-		exec.gl.resume <- struct{}{}
+		exec.gl.Resume()
 	}
 }
+func (g *Game) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	//End lets an Init run again
+	g.playersMu.RLock()
+	defer g.playersMu.RUnlock()
+	for _, exec := range g.players {
+		if exec.gl != nil {
+			exec.Stop()
+		}
+	}
+}
+
+// End the match without a result, e.g. its room closed. Returns once no loop is running and no
+// forfeit is sending, the caller must keep reading broadcast until then
+func (g *Game) End() {
+	g.isPlaying.Store(false)
+	g.Stop()
+	g.loops.Wait()
+	//a forfeit that won the race still sends its gameover
+	g.ending.Lock()
+	g.ending.Unlock()
+}
 func (exec *FrameExecutor) Stop() {
-	exec.gl.quit <- struct{}{}
+	exec.gl.Stop()
 }
@@ -1,7 +1,6 @@
 package game
 
 import (
-	"fmt"
 	"math"
 	"math/rand"
 )
@@ -22,10 +21,29 @@ type BoardState struct {
 	onGround     bool
 
 	//for attack mechanism
-	combo  int
-	b2b    string //back to back
-	send   int
-	cancel int
+	combo int
+	b2b   string //back to back
+	send  int
+	//lines consumed from the front of FrameQueue.garbage, by landing or canceling
+	garbageTaken int
+	moves        pieceMoves
+
+	events frameEvents // not propagated, belongs to this frame only
+}
+
+// frameEvents are the side effects of simulating one frame, kept so a rollback can replay
+// the same garbage and correct canceled/sent attacks
+type frameEvents struct {
+	frame     int            // frame this ring slot currently holds
+	attack    int            // lines sent to the opponent at combo end
+	cancelled []garbageChunk // incoming attacks canceled by line clears
+	landed    int
+	locked    bool   // a piece locked
+	cleared   int    // lines cleared by it
+	garbage   int    // of those, rows holding garbage
+	tSpin     string // "full", "mini" or ""
+	finesse   int    // inputs over the fewest that reach the same spot, -1 when not judged
+	checksum  uint32
 }
 
 func NewBoardState(board [][]int, blockIndex int, block Block, holdBlock int, cRow, cCol int, canHold bool,
@@ -45,9 +63,9 @@ func NewBoardState(board [][]int, blockIndex int, block Block, holdBlock int, cR
 		combo:        0,
 	}
 }
-func NewDefaultBoardState() *BoardState {
+func NewDefaultBoardState(size BoardSize) *BoardState {
 	return &BoardState{
-		board:       CreateEmptyBoard(),
+		board:       CreateEmptyBoard(size),
 		canHold:     true,
 		dropSpeed:   DROPSPEED,
 		inputBuffer: make(InputBuffer),
@@ -102,10 +120,10 @@ func GenerateList_Classic(list []int, n int) []int {
 	list = append(list, extend...)
 	return list
 }
-func CreateEmptyBoard() [][]int {
-	board := make([][]int, BOARD_HEIGHT)
+func CreateEmptyBoard(size BoardSize) [][]int {
+	board := make([][]int, size.Rows())
 	for i := range board {
-		board[i] = make([]int, BOARD_WIDTH)
+		board[i] = make([]int, size.Width)
 	}
 	return board
 }
@@ -180,14 +198,15 @@ func FindLandingPosition(board [][]int, block [][]int, row, col int) int {
 			}
 		}
 	}
+	height := len(board)
 	landingRow := row
-	for testRow := row + 1; testRow <= BOARD_HEIGHT; testRow++ {
+	for testRow := row + 1; testRow <= height; testRow++ {
 		if hasCollision(board, block, testRow, col) {
 			landingRow = testRow - 1 // Dừng ở row trước khi collision
 			break
 		}
-		if testRow == BOARD_HEIGHT {
-			landingRow = BOARD_HEIGHT - shapeHeight
+		if testRow == height {
+			landingRow = height - shapeHeight
 		}
 	}
 
@@ -221,17 +240,13 @@ func ClearLines(board [][]int) int {
 			cleared++
 		}
 	}
-	ib := BOARD_HEIGHT - 1
+	ib := len(board) - 1
 	if cleared > 0 {
 		for i := len(newBoard) - 1; i >= 0; i, ib = i-1, ib-1 {
-			for j := 0; j < BOARD_WIDTH; j++ {
-				board[ib][j] = newBoard[i][j]
-			}
+			copy(board[ib], newBoard[i])
 		}
 		for ; ib >= 0; ib-- {
-			for j := 0; j < BOARD_WIDTH; j++ {
-				board[ib][j] = 0
-			}
+			clear(board[ib])
 		}
 	}
 
@@ -263,10 +278,11 @@ func PropagateState(previous *BoardState, bs *BoardState) {
 
 	bs.combo = previous.combo
 	bs.send = previous.send
-	bs.cancel = previous.cancel
+	bs.garbageTaken = previous.garbageTaken
+	bs.moves = previous.moves
 }
 
-func ApplyInputBuffer(listBlock []int, bs *BoardState, input InputBuffer) {
+func ApplyInputBuffer(listBlock []int, bs *BoardState, input InputBuffer, settings RoomSettings) {
 	// Order apply: Horizontal move -> Rotate -> Vertical drop -> Hold -> hard drop last
 	// Horizontal moves (left/right)
 	if input[left] {
@@ -305,7 +321,7 @@ func ApplyInputBuffer(listBlock []int, bs *BoardState, input InputBuffer) {
 				newRow := bs.cRow + d[1]
 				newCol := bs.cCol + d[0]
 				if !hasCollision(bs.board, rotatedShape, newRow, newCol) {
-					fmt.Printf("Applying wall kick: dx=%v, dy=%v\n", d[0], d[1])
+					//fmt.Printf("Applying wall kick: dx=%v, dy=%v\n", d[0], d[1])
 					bs.cRow = newRow
 					bs.cCol = newCol
 					bs.block.shape = rotatedShape
@@ -322,39 +338,47 @@ func ApplyInputBuffer(listBlock []int, bs *BoardState, input InputBuffer) {
 		bs.dropSpeed = SOFT_DROP
 	}
 	if input[downOff] {
-		bs.dropSpeed = DROPSPEED
+		bs.dropSpeed = settings.Gravity
 	}
 
 	// Hold
 	if input[hold] && bs.canHold {
-		holdBlock := listBlock[bs.blockIndex]
+		holdBlock := pieceAt(listBlock, bs.blockIndex)
 		if bs.holdBlock == 0 {
 			bs.blockIndex++
-			bs.block = Tetromino[listBlock[bs.blockIndex]]
+			bs.block = Tetromino[pieceAt(listBlock, bs.blockIndex)]
 
 		} else {
 			bs.block = Tetromino[bs.holdBlock]
 		}
 		bs.holdBlock = holdBlock
 		bs.cRow = 0
-		bs.cCol = 4
+		bs.cCol = settings.Board.SpawnCol(bs.block.shape)
 		bs.canHold = false
+		bs.moves = pieceMoves{}
 	}
 	if input[spacebar] {
 		bs.cRow = FindLandingPosition(bs.board, bs.block.shape, bs.cRow, bs.cCol)
 		bs.onGround = true
-		bs.lockTimer = LOCKDELAY
+		bs.lockTimer = settings.LockDelay
 	}
 }
-func SpawnNewPiece(listBlock []int, bs *BoardState) {
+
+// pieceAt the list is generated once per match, a long game wraps around it (client does the same)
+func pieceAt(listBlock []int, i int) int {
+	return listBlock[i%len(listBlock)]
+}
+
+func SpawnNewPiece(listBlock []int, bs *BoardState, size BoardSize) {
 	bs.cRow = 0
-	bs.cCol = 4
 	bs.blockIndex++
-	bs.block = Tetromino[listBlock[bs.blockIndex]]
+	bs.block = Tetromino[pieceAt(listBlock, bs.blockIndex)]
+	bs.cCol = size.SpawnCol(bs.block.shape)
 	bs.onGround = false
 	bs.canHold = true
 	bs.lockTimer = 0
 	bs.gravityTimer = 0
+	bs.moves = pieceMoves{}
 }
 func isPerfect(board [][]int) bool {
 	for _, row := range board {
@@ -367,19 +391,24 @@ func isPerfect(board [][]int) bool {
 	return true
 }
 func TakeGarbage(lines int, board [][]int) {
+	TakeGarbageAt(lines, rand.Intn(len(board[0])), board)
+}
+
+// TakeGarbageAt push lines of garbage from the bottom with the hole at column hole
+func TakeGarbageAt(lines int, hole int, board [][]int) {
 	if lines == 0 {
 		return
 	}
-	for r := 0; r < BOARD_HEIGHT-lines; r++ {
-		for c := 0; c < BOARD_WIDTH; c++ {
-			board[r][c] = board[r+lines][c]
-		}
+	height, width := len(board), len(board[0])
+	lines = min(lines, height)
+	for r := 0; r < height-lines; r++ {
+		copy(board[r], board[r+lines])
 	}
-	emptyCol := rand.Intn(BOARD_WIDTH)
+	emptyCol := min(max(hole, 0), width-1)
 	// Thêm garbage lines vào dưới cùng
 	for i := 0; i < lines; i++ {
-		row := BOARD_HEIGHT - lines + i
-		for c := 0; c < BOARD_WIDTH; c++ {
+		row := height - lines + i
+		for c := 0; c < width; c++ {
 			board[row][c] = 8 // 8: garbage value
 		}
 		board[row][emptyCol] = 0
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var (
+	trainingBoard = BoardSize{Width: 4, Height: 10, Hidden: 2}
+	partyBoard    = BoardSize{Width: 12, Height: 24, Hidden: 3}
+)
+
+func TestCreateEmptyBoard(t *testing.T) {
+	for _, size := range []BoardSize{DefaultBoardSize, trainingBoard, partyBoard} {
+		board := CreateEmptyBoard(size)
+		assert.Len(t, board, size.Rows())
+		for _, row := range board {
+			assert.Len(t, row, size.Width)
+		}
+	}
+	assert.Len(t, CreateEmptyBoard(DefaultBoardSize), BOARD_HEIGHT)
+}
+
+func TestSpawnCol(t *testing.T) {
+	t.Run("standard board keeps column 4", func(t *testing.T) {
+		for id, block := range Tetromino {
+			assert.Equal(t, 4, DefaultBoardSize.SpawnCol(block.shape), ReverseTetrominoMap[id])
+		}
+	})
+	t.Run("every piece fits on spawn", func(t *testing.T) {
+		for _, size := range []BoardSize{trainingBoard, partyBoard} {
+			board := CreateEmptyBoard(size)
+			for id, block := range Tetromino {
+				col := size.SpawnCol(block.shape)
+				assert.False(t, hasCollision(board, block.shape, 0, col), "%s on %d wide", ReverseTetrominoMap[id], size.Width)
+			}
+		}
+	})
+}
+
+func TestClearLines(t *testing.T) {
+	t.Run("4 wide board", func(t *testing.T) {
+		board := CreateEmptyBoard(trainingBoard)
+		bottom := len(board) - 1
+		board[bottom] = []int{1, 1, 1, 1}
+		board[bottom-1] = []int{0, 3, 0, 0}
+		board[bottom-2] = []int{2, 2, 2, 2}
+
+		cleared := ClearLines(board)
+
+		assert.Equal(t, 2, cleared)
+		assert.Equal(t, []int{0, 3, 0, 0}, board[bottom])
+		for r := 0; r < bottom; r++ {
+			assert.Equal(t, []int{0, 0, 0, 0}, board[r])
+		}
+	})
+	t.Run("12 wide board", func(t *testing.T) {
+		board := CreateEmptyBoard(partyBoard)
+		bottom := len(board) - 1
+		full := []int{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5}
+		partial := []int{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 0, 5}
+		copy(board[bottom], full)
+		copy(board[bottom-1], partial)
+
+		assert.Equal(t, 0, ClearLines(CreateEmptyBoard(partyBoard)))
+		assert.Equal(t, 1, ClearLines(board))
+		assert.Equal(t, partial, board[bottom])
+		assert.True(t, isPerfect(board[:bottom]))
+	})
+}
+
+func TestTakeGarbage(t *testing.T) {
+	for _, size := range []BoardSize{trainingBoard, DefaultBoardSize, partyBoard} {
+		board := CreateEmptyBoard(size)
+		bottom := len(board) - 1
+		board[bottom][0] = 7
+
+		TakeGarbage(3, board)
+
+		assert.Equal(t, 7, board[bottom-3][0], "existing cells pushed up on %d wide", size.Width)
+		for r := bottom - 2; r <= bottom; r++ {
+			holes := 0
+			for _, cell := range board[r] {
+				if cell == 0 {
+					holes++
+				} else {
+					assert.Equal(t, 8, cell)
+				}
+			}
+			assert.Equal(t, 1, holes, "one hole per garbage row on %d wide", size.Width)
+		}
+	}
+	t.Run("more garbage than rows tops out the board", func(t *testing.T) {
+		board := CreateEmptyBoard(trainingBoard)
+		TakeGarbage(trainingBoard.Rows()+5, board)
+		for _, row := range board {
+			assert.Contains(t, row, 8)
+		}
+	})
+}
+
+func TestFindLandingPosition(t *testing.T) {
+	o := Tetromino[2].shape
+	for _, size := range []BoardSize{trainingBoard, partyBoard} {
+		board := CreateEmptyBoard(size)
+		col := size.SpawnCol(o)
+		assert.Equal(t, size.Rows()-2, FindLandingPosition(board, o, 0, col))
+
+		TakeGarbage(2, board)
+		board[size.Rows()-1][col], board[size.Rows()-2][col] = 8, 8 // fill the hole under the piece
+		assert.Equal(t, size.Rows()-4, FindLandingPosition(board, o, 0, col))
+	}
+}
@@ -0,0 +1,419 @@
+package game
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newTestExecutor build an executor ready to simulate from frame 1, without a game loop
+func newTestExecutor(t testing.TB, listBlock []int) *FrameExecutor {
+	t.Helper()
+	exec := NewFrameExecutor("player-1", DefaultRoomSettings())
+	exec.listBlock = listBlock
+	first := Tetromino[listBlock[0]]
+	exec.frames.data[0] = NewBoardState(CreateEmptyBoard(exec.settings.Board), 0, first, 0, 0, exec.settings.Board.SpawnCol(first.shape), true, exec.settings.Gravity,
+		make(InputBuffer), 0, false)
+	return exec
+}
+
+func TestRollback(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	simulated := 20
+	t.Run("late input re-simulates to the same state as on time", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		onTime := newTestExecutor(t, listBlock)
+		onTime.recordInputs(0, []Input{{Frame: 12, Keys: []string{"left"}}}, 12, broadcast)
+		assertNoErr(t, onTime.computeBatchFrames(1, simulated, broadcast))
+
+		late := newTestExecutor(t, listBlock)
+		assertNoErr(t, late.computeBatchFrames(1, simulated, broadcast))
+		late.recordInputs(0, []Input{{Frame: 12, Keys: []string{"left"}}}, 12, broadcast)
+
+		want, _ := onTime.frames.Get(simulated)
+		got, _ := late.frames.Get(simulated)
+		assert.Equal(t, want.ToDTO(), got.ToDTO())
+		assert.Equal(t, DefaultBoardSize.SpawnCol(got.block.shape)-1, got.cCol)
+
+		var corrected Message
+		for len(broadcast) > 0 {
+			packet := <-broadcast
+			corrected = packet.msg
+			if corrected.Type == "server-state" {
+				assert.Equal(t, "player-1", packet.directId)
+				break
+			}
+		}
+		assert.Equal(t, "server-state", corrected.Type)
+		assert.Equal(t, simulated, corrected.Payload.LatestFrame)
+		assert.Equal(t, got.cCol, corrected.Payload.BoardState.CCol)
+	})
+	t.Run("input older than the rollback window is dropped and resynced", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		assertNoErr(t, exec.computeBatchFrames(1, simulated, broadcast))
+		before, _ := exec.frames.Get(simulated)
+		col := before.cCol
+
+		tooLate := simulated - ROLLBACK_WINDOW - 1
+		exec.recordInputs(0, []Input{{Frame: tooLate, Keys: []string{"left"}}}, simulated, broadcast)
+		after, _ := exec.frames.Get(simulated)
+		assert.Equal(t, col, after.cCol)
+		reply := <-broadcast
+		assert.Equal(t, "input-server", reply.msg.Type)
+		assert.Equal(t, []int{tooLate}, reply.msg.Payload.Rejected)
+		//client stalled, it jumps to the server state
+		resync := <-broadcast
+		assert.Equal(t, "resync", resync.msg.Type)
+		assert.Equal(t, simulated, resync.msg.Payload.LatestFrame)
+		assert.Empty(t, broadcast)
+	})
+	t.Run("the last final frame is not rolled back", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		assertNoErr(t, exec.computeBatchFrames(1, simulated, broadcast))
+		exec.flushAttacks(broadcast)
+		assert.Equal(t, simulated-ROLLBACK_WINDOW, exec.flushedFrame)
+		before, _ := exec.frames.Get(simulated)
+		col := before.cCol
+
+		exec.recordInputs(0, []Input{{Frame: exec.flushedFrame, Keys: []string{"left"}}}, simulated, broadcast)
+		after, _ := exec.frames.Get(simulated)
+		assert.Equal(t, col, after.cCol)
+		reply := <-broadcast
+		assert.Equal(t, []int{simulated - ROLLBACK_WINDOW}, reply.msg.Payload.Rejected)
+		for len(broadcast) > 0 {
+			assert.NotEqual(t, "server-state", (<-broadcast).msg.Type)
+		}
+	})
+	t.Run("the first frame still open is rolled back", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		assertNoErr(t, exec.computeBatchFrames(1, simulated, broadcast))
+		exec.recordInputs(0, []Input{{Frame: simulated - ROLLBACK_WINDOW + 1, Keys: []string{"left"}}}, simulated, broadcast)
+		reply := <-broadcast
+		assert.Empty(t, reply.msg.Payload.Rejected)
+	})
+	t.Run("repeated input does not roll back", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		exec.recordInputs(0, []Input{{Frame: 5, Keys: []string{"right"}}}, 5, broadcast)
+		assertNoErr(t, exec.computeBatchFrames(1, simulated, broadcast))
+		<-broadcast // input-server
+
+		exec.recordInputs(0, []Input{{Frame: 5, Keys: []string{"right"}}}, simulated, broadcast)
+		for len(broadcast) > 0 {
+			msg := (<-broadcast).msg
+			assert.NotEqual(t, "server-state", msg.Type)
+		}
+	})
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("did not expect error but got: %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	simulated := CHECKSUM_INTERVAL + ROLLBACK_WINDOW
+	t.Run("same state same checksum", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		a, b := newTestExecutor(t, listBlock), newTestExecutor(t, listBlock)
+		assertNoErr(t, a.computeBatchFrames(1, simulated, broadcast))
+		assertNoErr(t, b.computeBatchFrames(1, simulated, broadcast))
+		stateA, _ := a.frames.Get(CHECKSUM_INTERVAL)
+		stateB, _ := b.frames.Get(CHECKSUM_INTERVAL)
+		assert.NotZero(t, stateA.events.checksum)
+		assert.Equal(t, stateA.events.checksum, stateB.events.checksum)
+
+		stateB.cCol++
+		assert.NotEqual(t, stateA.Checksum(), stateB.Checksum())
+	})
+	t.Run("matching client checksum", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		//the checksum comes in while its frame can still be rolled back
+		assertNoErr(t, exec.computeBatchFrames(1, simulated-1, broadcast))
+		bs, _ := exec.frames.Get(CHECKSUM_INTERVAL)
+		exec.recordInputs(0, []Input{{Frame: CHECKSUM_INTERVAL, Checksum: bs.Checksum()}}, simulated-1, broadcast)
+		assertNoErr(t, exec.computeBatchFrames(simulated, simulated, broadcast))
+		exec.verifyChecksums(broadcast)
+
+		assert.Zero(t, exec.desyncs)
+		assert.Empty(t, exec.checksums)
+		assert.Empty(t, broadcast)
+	})
+	t.Run("mismatch push resync", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		//the checksum comes in while its frame can still be rolled back
+		assertNoErr(t, exec.computeBatchFrames(1, simulated-1, broadcast))
+		bs, _ := exec.frames.Get(CHECKSUM_INTERVAL)
+		before := DesyncCount()
+		exec.recordInputs(0, []Input{{Frame: CHECKSUM_INTERVAL, Checksum: bs.Checksum() + 1}}, simulated-1, broadcast)
+		assertNoErr(t, exec.computeBatchFrames(simulated, simulated, broadcast))
+		exec.verifyChecksums(broadcast)
+
+		assert.Equal(t, 1, exec.desyncs)
+		assert.Equal(t, before+1, DesyncCount())
+		packet := <-broadcast
+		msg := packet.msg
+		assert.Equal(t, "resync", msg.Type)
+		assert.Equal(t, "player-1", packet.directId)
+		latest, _ := exec.frames.Get(exec.frames.simFrame)
+		assert.Equal(t, exec.frames.simFrame, msg.Payload.LatestFrame)
+		assert.Equal(t, latest.Checksum(), msg.Payload.Checksum)
+	})
+}
+
+func TestBoardDelta(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	newWatched := func(t *testing.T) (*FrameExecutor, *BoardState, chan Packet) {
+		exec := newTestExecutor(t, listBlock)
+		exec.viewers = []string{"player-2"}
+		broadcast := make(chan Packet, 32)
+		assertNoErr(t, exec.computeBatchFrames(1, 10, broadcast))
+		bs, _ := exec.frames.Get(10)
+		return exec, bs, broadcast
+	}
+	send := func(exec *FrameExecutor, bs *BoardState, broadcast chan Packet) Message {
+		exec.sendBoard("player-2", bs, 10, broadcast)
+		packet := <-broadcast
+		assert.Equal(t, "player-2", packet.directId)
+		return packet.msg
+	}
+	t.Run("keyframe until the viewer acks", func(t *testing.T) {
+		exec, bs, broadcast := newWatched(t)
+		for seq := 1; seq <= 3; seq++ {
+			msg := send(exec, bs, broadcast)
+			assert.Equal(t, "opponent", msg.Type)
+			assert.Equal(t, seq, msg.Payload.Seq)
+			assert.Equal(t, "player-1", msg.PlayerId)
+			assert.Len(t, msg.Payload.BoardState.Board, BOARD_HEIGHT)
+		}
+	})
+	t.Run("only changed rows against the acked board", func(t *testing.T) {
+		exec, bs, broadcast := newWatched(t)
+		key := send(exec, bs, broadcast)
+		exec.onView(viewRequest{viewer: "player-2", seq: key.Payload.Seq})
+		client := copySlice(key.Payload.BoardState.Board)
+
+		TakeGarbage(2, bs.board)
+		delta := send(exec, bs, broadcast)
+		assert.Equal(t, "opponent-delta", delta.Type)
+		assert.Equal(t, key.Payload.Seq+1, delta.Payload.Seq)
+		assert.Equal(t, key.Payload.Seq, delta.Payload.BaseSeq)
+		assert.Nil(t, delta.Payload.BoardState.Board)
+		assert.Equal(t, bs.block.shape, delta.Payload.BoardState.Block)
+		assert.Len(t, delta.Payload.Rows, 2)
+
+		//delta not acked: the next one is still relative to the keyframe
+		TakeGarbage(1, bs.board)
+		delta = send(exec, bs, broadcast)
+		assert.Equal(t, key.Payload.Seq, delta.Payload.BaseSeq)
+		assert.Len(t, delta.Payload.Rows, 3)
+		for _, row := range delta.Payload.Rows {
+			client[row.Row] = row.Cells
+		}
+		assert.Equal(t, bs.board, client)
+
+		unchanged := send(exec, bs, broadcast)
+		exec.onView(viewRequest{viewer: "player-2", seq: unchanged.Payload.Seq})
+		assert.Empty(t, send(exec, bs, broadcast).Payload.Rows)
+	})
+	t.Run("keyframe on request and periodically", func(t *testing.T) {
+		exec, bs, broadcast := newWatched(t)
+		key := send(exec, bs, broadcast)
+		exec.onView(viewRequest{viewer: "player-2", seq: key.Payload.Seq})
+		assert.Equal(t, "opponent-delta", send(exec, bs, broadcast).Type)
+
+		exec.onView(viewRequest{viewer: "player-2", keyframe: true})
+		assert.Equal(t, "opponent", send(exec, bs, broadcast).Type)
+		exec.onView(viewRequest{viewer: "player-2", seq: key.Payload.Seq + 2})
+		for range KEYFRAME_INTERVAL {
+			assert.Equal(t, "opponent-delta", send(exec, bs, broadcast).Type)
+		}
+		assert.Equal(t, "opponent", send(exec, bs, broadcast).Type)
+	})
+	t.Run("unknown or stale acks are ignored", func(t *testing.T) {
+		exec, bs, broadcast := newWatched(t)
+		send(exec, bs, broadcast)
+		exec.onView(viewRequest{viewer: "player-2", seq: 5})
+		exec.onView(viewRequest{viewer: "player-3", seq: 1})
+		assert.Equal(t, "opponent", send(exec, bs, broadcast).Type)
+
+		for range viewHistory + 1 {
+			send(exec, bs, broadcast)
+		}
+		exec.onView(viewRequest{viewer: "player-2", seq: 1})
+		assert.Equal(t, "opponent", send(exec, bs, broadcast).Type)
+	})
+}
+
+func TestInputAck(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	batch := func(exec *FrameExecutor, seq int, frame int, broadcast chan Packet) Message {
+		exec.recordInputs(seq, []Input{{Frame: frame, Keys: []string{"left"}}}, frame, broadcast)
+		packet := <-broadcast
+		assert.Equal(t, "player-1", packet.directId)
+		assert.Empty(t, packet.excludeId)
+		return packet.msg
+	}
+	t.Run("reply to the sender with the highest contiguous seq", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		reply := batch(exec, 1, 2, broadcast)
+		assert.Equal(t, "input-server", reply.Type)
+		assert.Equal(t, 1, reply.Payload.Seq)
+		assert.Equal(t, []Input{{Frame: 2, Keys: []string{"left"}}}, reply.Payload.Inputs)
+
+		//batch 2 lost, 3 and 4 arrive
+		assert.Equal(t, 1, batch(exec, 3, 4, broadcast).Payload.Seq)
+		assert.Equal(t, 1, batch(exec, 4, 5, broadcast).Payload.Seq)
+		//client resend 2
+		assert.Equal(t, 4, batch(exec, 2, 3, broadcast).Payload.Seq)
+		//duplicate of an acked batch
+		assert.Equal(t, 4, batch(exec, 3, 4, broadcast).Payload.Seq)
+		assert.Len(t, exec.inputsSince(0), 4)
+	})
+	t.Run("seq far ahead is not tracked", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		assert.Equal(t, 0, batch(exec, maxSeqAhead+1, 2, broadcast).Payload.Seq)
+		assert.Empty(t, exec.seqAhead)
+	})
+	t.Run("rejected frames", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		exec.recordInputs(1, []Input{{Frame: 2, Keys: []string{"left"}}, {Frame: QUEUE_SIZE, Keys: []string{"right"}}}, 2, broadcast)
+		reply := (<-broadcast).msg
+		assert.Equal(t, 1, reply.Payload.Seq)
+		assert.Equal(t, []int{QUEUE_SIZE}, reply.Payload.Rejected)
+		assert.Len(t, reply.Payload.Inputs, 1)
+	})
+}
+
+func TestAttackEvents(t *testing.T) {
+	listBlock := DefaultRoomSettings().GenerateList(nil, 100)
+	event := func(t *testing.T, broadcast chan Packet) (string, AttackDTO) {
+		t.Helper()
+		packet := <-broadcast
+		assert.Empty(t, packet.directId)
+		assert.Empty(t, packet.excludeId)
+		assert.Equal(t, "player-1", packet.msg.PlayerId)
+		return packet.msg.Type, *packet.msg.Payload.Attack
+	}
+	t.Run("incoming", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, listBlock)
+		exec.receiveGarbage(Attack{source: "player-2", lines: 3, atFrame: 5}, broadcast)
+		msgType, atk := event(t, broadcast)
+		assert.Equal(t, "attack-incoming", msgType)
+		assert.Equal(t, AttackDTO{Amount: 3, Source: "player-2", Frame: 5 + INCOMING}, atk)
+		assert.Equal(t, 3, exec.frames.garbage.Pending(1, 0))
+	})
+	t.Run("cancelled once the frame is final", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newPerfectClear(t)
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-2", Lines: 3, Arrival: 100})
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-3", Lines: 2, Arrival: 100})
+		assertNoErr(t, exec.computeBatchFrames(1, 2+ROLLBACK_WINDOW-1, broadcast))
+		exec.flushAttacks(broadcast)
+		assert.Empty(t, broadcast)
+
+		assertNoErr(t, exec.computeBatchFrames(2+ROLLBACK_WINDOW, 2+ROLLBACK_WINDOW, broadcast))
+		exec.flushAttacks(broadcast)
+		msgType, atk := event(t, broadcast)
+		assert.Equal(t, "attack-cancelled", msgType)
+		assert.Equal(t, AttackDTO{Amount: 3, Source: "player-2", Frame: 100}, atk)
+		_, atk = event(t, broadcast)
+		assert.Equal(t, AttackDTO{Amount: 1, Source: "player-3", Frame: 100}, atk)
+		assert.Empty(t, broadcast)
+	})
+}
+
+// newPerfectClear an executor dealing only I pieces on a board whose bottom row is full but for
+// the I spawn columns: a hard drop on frame 2 is a perfect clear single, 4 lines of attack
+func newPerfectClear(t *testing.T) *FrameExecutor {
+	t.Helper()
+	listBlock := make([]int, 100)
+	for i := range listBlock {
+		listBlock[i] = 1
+	}
+	exec := newTestExecutor(t, listBlock)
+	board := exec.frames.data[0].board
+	spawn := DefaultBoardSize.SpawnCol(Tetromino[1].shape)
+	for c := range board[len(board)-1] {
+		if c < spawn || c >= spawn+4 {
+			board[len(board)-1][c] = 8
+		}
+	}
+	exec.recordInputs(0, []Input{{Frame: 2, Keys: []string{"space"}}}, 2, make(chan Packet, 1))
+	return exec
+}
+
+func TestGarbageSimulation(t *testing.T) {
+	t.Run("clear cancels oldest incoming first and sends the rest", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newPerfectClear(t)
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-2", Lines: 1, Arrival: 100})
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-3", Lines: 2, Arrival: 50})
+		assertNoErr(t, exec.computeBatchFrames(1, 2, broadcast))
+
+		bs, _ := exec.frames.Get(2)
+		assert.Equal(t, []garbageChunk{
+			{entry: GarbageEntry{Source: "player-2", Lines: 1, Arrival: 100}, lines: 1},
+			{entry: GarbageEntry{Source: "player-3", Lines: 2, Arrival: 50}, lines: 2},
+		}, bs.events.cancelled)
+		assert.Equal(t, 1, bs.send)
+		assert.Zero(t, exec.frames.garbage.Pending(3, bs.garbageTaken))
+	})
+	t.Run("arrived garbage rises on lock, capped per piece", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, DefaultRoomSettings().GenerateList(nil, 100))
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-2", Lines: GARBAGE_CAP + 2, Hole: 3, Arrival: 1})
+		exec.recordInputs(0, []Input{{Frame: 2, Keys: []string{"space"}}, {Frame: 4, Keys: []string{"space"}}}, 4, broadcast)
+		<-broadcast
+		assertNoErr(t, exec.computeBatchFrames(1, 3, broadcast))
+
+		bs, _ := exec.frames.Get(3)
+		assert.Equal(t, GARBAGE_CAP, countGarbageRows(bs.board))
+		sync := <-broadcast
+		assert.Equal(t, "garbage-sync", sync.msg.Type)
+		assert.Equal(t, "player-1", sync.directId)
+
+		assertNoErr(t, exec.computeBatchFrames(4, 4, broadcast))
+		bs, _ = exec.frames.Get(4)
+		assert.Equal(t, 2, bs.events.landed)
+		assert.Equal(t, GARBAGE_CAP+2, countGarbageRows(bs.board))
+		for _, row := range bs.board[len(bs.board)-GARBAGE_CAP-2:] {
+			assert.Zero(t, row[3])
+		}
+	})
+	t.Run("not arrived garbage waits", func(t *testing.T) {
+		broadcast := make(chan Packet, 32)
+		exec := newTestExecutor(t, DefaultRoomSettings().GenerateList(nil, 100))
+		exec.frames.garbage.Push(GarbageEntry{Source: "player-2", Lines: 2, Arrival: 40})
+		exec.recordInputs(0, []Input{{Frame: 2, Keys: []string{"space"}}}, 2, broadcast)
+		<-broadcast
+		assertNoErr(t, exec.computeBatchFrames(1, 2, broadcast))
+		bs, _ := exec.frames.Get(2)
+		assert.Zero(t, countGarbageRows(bs.board))
+		assert.Equal(t, 2, exec.frames.garbage.Pending(3, bs.garbageTaken))
+	})
+}
+
+func countGarbageRows(board [][]int) int {
+	n := 0
+	for _, row := range board {
+		for _, cell := range row {
+			if cell == 8 {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
@@ -2,6 +2,7 @@ package game
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -13,16 +14,23 @@ type GameLoop struct {
 	resume    chan struct{}
 	tick      time.Duration //t per sec
 	tickerC   <-chan time.Time
+	clock     Clock // wall clock unless the match was given another one
 	input     chan Message
 	attacked  chan Attack
+	snapshot  chan int // last frame acked by a reattached client
+	views     chan viewRequest
+	stopOnce  sync.Once
 	//callback
 	onUpdate       func(chan Packet)
-	recordInputs   func([]Input, int, chan Packet)
-	receiveGarbage func(Attack)
+	recordInputs   func(int, []Input, int, chan Packet)
+	receiveGarbage func(Attack, chan Packet)
+	sendSnapshot   func(int, chan Packet)
+	onView         func(viewRequest)
+	onStart        func() // startAt reached, may be nil
 }
 
-func NewGameLoop(onUpdate func(chan Packet), recordInputs func([]Input, int, chan Packet),
-	receiveGarbage func(attack Attack)) *GameLoop {
+func NewGameLoop(onUpdate func(chan Packet), recordInputs func(int, []Input, int, chan Packet),
+	receiveGarbage func(Attack, chan Packet), sendSnapshot func(int, chan Packet), onView func(viewRequest)) *GameLoop {
 	return &GameLoop{
 		tickFrame:      0,
 		quit:           make(chan struct{}),
@@ -30,22 +38,49 @@ func NewGameLoop(onUpdate func(chan Packet), recordInputs func([]Input, int, cha
 		resume:         make(chan struct{}),
 		tick:           defaultTicks,
 		tickerC:        nil,
+		clock:          realClock{},
 		input:          make(chan Message),
 		attacked:       make(chan Attack),
+		snapshot:       make(chan int),
+		views:          make(chan viewRequest),
 		onUpdate:       onUpdate,
 		recordInputs:   recordInputs,
 		receiveGarbage: receiveGarbage,
+		sendSnapshot:   sendSnapshot,
+		onView:         onView,
 	}
 }
-func (gl *GameLoop) NewTicker() *time.Ticker {
+func (gl *GameLoop) NewTicker() Ticker {
 	tickInterval := time.Second / gl.tick
-	return time.NewTicker(tickInterval)
+	return gl.clock.NewTicker(tickInterval)
 }
 
-func (gl *GameLoop) Run(broadcast chan Packet) {
+func (gl *GameLoop) Run(broadcast chan Packet, startAt time.Time) {
+	//a pause during the countdown is taken too, Game.Pause holds its lock until then
+	start := gl.clock.At(startAt)
+	paused := false
+countdown:
+	for {
+		select {
+		case <-start:
+			break countdown
+		case <-gl.pause:
+			paused = true
+		case <-gl.resume:
+			paused = false
+		case <-gl.quit:
+			return
+		}
+	}
 
-	ticker := gl.NewTicker()
-	gl.tickerC = ticker.C
+	if gl.onStart != nil {
+		gl.onStart()
+	}
+	var ticker Ticker
+	if !paused {
+		ticker = gl.NewTicker()
+		gl.tickerC = ticker.C()
+	}
 	for {
 		select {
 		case <-gl.tickerC:
@@ -53,24 +88,75 @@ func (gl *GameLoop) Run(broadcast chan Packet) {
 			gl.onUpdate(broadcast)
 			gl.tickFrame++
 		case msg := <-gl.input:
-			gl.recordInputs(msg.Payload.Inputs, msg.Payload.LatestFrame, broadcast)
+			gl.recordInputs(msg.Payload.Seq, msg.Payload.Inputs, msg.Payload.LatestFrame, broadcast)
 		case atk := <-gl.attacked:
-			gl.receiveGarbage(atk)
+			gl.receiveGarbage(atk, broadcast)
+		case ackFrame := <-gl.snapshot:
+			gl.sendSnapshot(ackFrame, broadcast)
+		case req := <-gl.views:
+			gl.onView(req)
 
 		case <-gl.pause:
-			ticker.Stop()
-			gl.tickerC = nil
-			<-gl.resume
-			ticker = gl.NewTicker()
-			gl.tickerC = ticker.C
+			//stop ticking only, inputs/attacks/snapshots are still served while paused
+			if gl.tickerC != nil {
+				ticker.Stop()
+				gl.tickerC = nil
+			}
+		case <-gl.resume:
+			if gl.tickerC == nil {
+				ticker = gl.NewTicker()
+				gl.tickerC = ticker.C()
+			}
 		case <-gl.quit:
-			ticker.Stop()
+			if gl.tickerC != nil {
+				ticker.Stop()
+			}
 			fmt.Println("end loop") //debug
 			gl.tickerC = nil
 			return
 		}
 	}
 }
+
+// Pause, Resume, RequestSnapshot, Input and View never block on a loop that has already quit
+func (gl *GameLoop) Pause() {
+	select {
+	case gl.pause <- struct{}{}:
+	case <-gl.quit:
+	}
+}
+func (gl *GameLoop) Resume() {
+	select {
+	case gl.resume <- struct{}{}:
+	case <-gl.quit:
+	}
+}
+func (gl *GameLoop) RequestSnapshot(ackFrame int) {
+	select {
+	case gl.snapshot <- ackFrame:
+	case <-gl.quit:
+	}
+}
+
+func (gl *GameLoop) Input(msg Message) {
+	select {
+	case gl.input <- msg:
+	case <-gl.quit:
+	}
+}
+func (gl *GameLoop) View(req viewRequest) {
+	select {
+	case gl.views <- req:
+	case <-gl.quit:
+	}
+}
+
+// Stop is safe to call more than once and from inside the loop itself (e.g. onUpdate on game over)
+func (gl *GameLoop) Stop() {
+	gl.stopOnce.Do(func() {
+		close(gl.quit)
+	})
+}
 func (g *GameLoop) startLoop() {
 
 }
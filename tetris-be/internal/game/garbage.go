@@ -0,0 +1,78 @@
+package game
+
+// GarbageEntry one attack waiting to land on a player, kept in the order it was received
+type GarbageEntry struct {
+	Source    string
+	Lines     int
+	Hole      int // column left open in every row of this attack
+	Arrival   int // first frame it can land
+	scheduled int // receiver's frame when it was received, that frame and older can't see it
+}
+
+// garbageChunk part of an entry consumed at one frame, by a cancel or by landing
+type garbageChunk struct {
+	entry GarbageEntry
+	lines int
+}
+
+// GarbageQueue is append only, what a frame has consumed is BoardState.garbageTaken,
+// a count of lines from the front. A rollback only needs the count of the frame before it
+type GarbageQueue struct {
+	entries []GarbageEntry
+	dropped int // lines of entries pruned from the front
+}
+
+func (q *GarbageQueue) Push(e GarbageEntry) {
+	q.entries = append(q.entries, e)
+}
+
+// take consume up to n lines in FIFO order starting after taken lines, as seen at frame.
+// due only takes attacks that already arrived, an entry that can't be taken blocks the ones behind it
+func (q *GarbageQueue) take(frame int, taken *int, n int, due bool) []garbageChunk {
+	var chunks []garbageChunk
+	start := q.dropped
+	for _, e := range q.entries {
+		if n <= 0 {
+			break
+		}
+		end := start + e.Lines
+		if end <= *taken {
+			start = end
+			continue
+		}
+		if e.scheduled >= frame || (due && e.Arrival > frame) {
+			break
+		}
+		k := min(n, end-*taken)
+		chunks = append(chunks, garbageChunk{entry: e, lines: k})
+		*taken += k
+		n -= k
+		start = end
+	}
+	return chunks
+}
+
+// Pending lines still waiting at frame after taken lines were consumed, the garbage meter
+func (q *GarbageQueue) Pending(frame int, taken int) int {
+	pending := 0
+	start := q.dropped
+	for _, e := range q.entries {
+		end := start + e.Lines
+		if e.scheduled >= frame {
+			break
+		}
+		if end > taken {
+			pending += end - max(start, taken)
+		}
+		start = end
+	}
+	return pending
+}
+
+// prune drop entries fully consumed by taken lines, called with the count of a frame that can't be rolled back
+func (q *GarbageQueue) prune(taken int) {
+	for len(q.entries) > 0 && q.dropped+q.entries[0].Lines <= taken {
+		q.dropped += q.entries[0].Lines
+		q.entries = q.entries[1:]
+	}
+}
@@ -0,0 +1,82 @@
+package game
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGarbageQueue(t *testing.T) {
+	newQueue := func() *GarbageQueue {
+		q := &GarbageQueue{}
+		q.Push(GarbageEntry{Source: "a", Lines: 3, Arrival: 50})
+		q.Push(GarbageEntry{Source: "b", Lines: 2, Arrival: 40})
+		q.Push(GarbageEntry{Source: "c", Lines: 4, Arrival: 60, scheduled: 30})
+		return q
+	}
+	sources := func(chunks []garbageChunk) []string {
+		var s []string
+		for _, c := range chunks {
+			s = append(s, c.entry.Source)
+		}
+		return s
+	}
+	t.Run("cancel in the order attacks were received", func(t *testing.T) {
+		q := newQueue()
+		taken := 0
+		chunks := q.take(10, &taken, 4, false)
+		assert.Equal(t, []string{"a", "b"}, sources(chunks))
+		assert.Equal(t, 3, chunks[0].lines)
+		assert.Equal(t, 1, chunks[1].lines)
+		assert.Equal(t, 4, taken)
+
+		chunks = q.take(10, &taken, 1, false)
+		assert.Equal(t, []string{"b"}, sources(chunks))
+		assert.Equal(t, 5, taken)
+	})
+	t.Run("attack received later is not visible to older frames", func(t *testing.T) {
+		q := newQueue()
+		taken := 0
+		chunks := q.take(30, &taken, 10, false)
+		assert.Equal(t, []string{"a", "b"}, sources(chunks))
+		assert.Equal(t, 5, taken)
+		assert.Equal(t, 0, q.Pending(30, taken))
+		assert.Equal(t, 4, q.Pending(31, taken))
+	})
+	t.Run("landing keeps FIFO even when a later attack arrived first", func(t *testing.T) {
+		q := newQueue()
+		taken := 0
+		assert.Empty(t, q.take(45, &taken, 8, true)) // b arrived, a didn't and blocks it
+		chunks := q.take(50, &taken, 8, true)
+		assert.Equal(t, []string{"a", "b"}, sources(chunks))
+		assert.Equal(t, 5, taken)
+	})
+	t.Run("cap per piece", func(t *testing.T) {
+		q := newQueue()
+		taken := 0
+		chunks := q.take(70, &taken, 4, true)
+		assert.Equal(t, []string{"a", "b"}, sources(chunks))
+		assert.Equal(t, 4, taken)
+		chunks = q.take(70, &taken, 4, true)
+		assert.Equal(t, []string{"b", "c"}, sources(chunks))
+		assert.Equal(t, 3, chunks[1].lines)
+	})
+	t.Run("replay from a saved count gives the same result", func(t *testing.T) {
+		q := newQueue()
+		taken := 0
+		first := q.take(10, &taken, 4, false)
+		replayed := 0
+		assert.Equal(t, first, q.take(10, &replayed, 4, false))
+		assert.Equal(t, taken, replayed)
+	})
+	t.Run("prune keeps offsets", func(t *testing.T) {
+		q := newQueue()
+		taken := 4
+		q.prune(taken)
+		assert.Len(t, q.entries, 2)
+		assert.Equal(t, 3, q.dropped)
+		chunks := q.take(70, &taken, 2, false)
+		assert.Equal(t, []string{"b", "c"}, sources(chunks))
+		assert.Equal(t, 1, chunks[0].lines)
+		assert.Equal(t, 3, q.Pending(70, taken))
+	})
+}
@@ -0,0 +1,188 @@
+package game
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Harness play a match with no sockets and no wall clock: every Step moves all loops one frame,
+// the way their tickers would, with the inputs scripted by Play. Attacks go to the opponent inside
+// the same Step, so two runs of the same script end on the same boards
+type Harness struct {
+	game      *Game
+	clock     *ManualClock
+	players   []string // step order
+	broadcast chan Packet
+	sent      []Packet
+	script    map[string]map[int][]string
+	stalled   map[string]bool
+	frame     int
+}
+
+// NewHarness seed decide the garbage holes, piece lists come from settings unless SetList
+func NewHarness(settings RoomSettings, seed int64, players ...string) *Harness {
+	clock := NewManualClock(time.Unix(0, 0))
+	h := &Harness{
+		game:      NewGame(settings, DefaultAntiCheatConfig, clock),
+		clock:     clock,
+		players:   players,
+		broadcast: make(chan Packet, 256),
+		script:    map[string]map[int][]string{},
+		stalled:   map[string]bool{},
+	}
+	g := h.game
+	for _, pId := range players {
+		g.players[pId] = NewFrameExecutor(pId, settings)
+		h.script[pId] = map[int][]string{}
+	}
+	g.wire(h.broadcast)
+	for i, pId := range players {
+		exec := g.players[pId]
+		//no loop goroutine to wait on here, the gameover is there when Step returns
+		exec.onGameOver = func() { g.forfeit(pId, pId+" topped out", h.broadcast) }
+		exec.holes = rand.New(rand.NewSource(seed + int64(i)))
+		exec.start()
+	}
+	g.isPlaying.Store(true)
+	//what Run does once startAt is reached
+	for _, pId := range players {
+		g.players[pId].gl.onStart()
+	}
+	return h
+}
+
+// SetList replace the piece list of a player, before the first Step
+func (h *Harness) SetList(playerId string, list []int) {
+	exec := h.game.players[playerId]
+	exec.listBlock = list
+	exec.start()
+}
+
+// Bot let a bot play for a player instead of the script
+func (h *Harness) Bot(playerId string, cfg BotConfig, seed int64) {
+	h.game.players[playerId].bot = NewBot(cfg, seed)
+}
+
+// Play script keys for a player at frame, sent by its client right before that frame
+func (h *Harness) Play(playerId string, frame int, keys ...string) {
+	h.script[playerId][frame] = append(h.script[playerId][frame], keys...)
+}
+
+// Stall stop (or resume) a player's client from sending anything, the server auto simulates it
+func (h *Harness) Stall(playerId string, stalled bool) {
+	h.stalled[playerId] = stalled
+}
+
+// Step tick every loop once, false once the match is over
+func (h *Harness) Step() bool {
+	if h.Over() {
+		return false
+	}
+	h.frame++
+	h.clock.Advance(time.Second / TICK)
+	var attacks []harnessAttack
+	for _, pId := range h.players {
+		exec := h.game.players[pId]
+		if !h.stalled[pId] && exec.bot == nil {
+			var inputs []Input
+			if keys := h.script[pId][h.frame]; len(keys) > 0 {
+				inputs = append(inputs, Input{Frame: h.frame, Keys: keys})
+			}
+			exec.recordInputs(0, inputs, h.frame, h.broadcast)
+			h.collect()
+		}
+		attacks = append(attacks, h.update(exec)...)
+		exec.gl.tickFrame++
+		h.collect()
+	}
+	//what the opponent's loop would have received on gl.attacked during this tick
+	for _, atk := range attacks {
+		atk.to.receiveGarbage(atk.Attack, h.broadcast)
+		h.collect()
+	}
+	return !h.Over()
+}
+
+type harnessAttack struct {
+	Attack
+	to *FrameExecutor
+}
+
+// update run the tick of a player and take the attacks it sends on gl.attacked of its opponent,
+// they are handed over once every player ticked so the order doesn't depend on the scheduler
+func (h *Harness) update(exec *FrameExecutor) []harnessAttack {
+	var to *FrameExecutor
+	for _, other := range h.game.players {
+		if other.gl.attacked == exec.opponentC {
+			to = other
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exec.onUpdate(h.broadcast)
+	}()
+	var attacks []harnessAttack
+	for {
+		select {
+		case atk := <-exec.opponentC:
+			attacks = append(attacks, harnessAttack{Attack: atk, to: to})
+		case packet := <-h.broadcast:
+			h.sent = append(h.sent, packet)
+		case <-done:
+			return attacks
+		}
+	}
+}
+
+// Run step up to n frames, stopping at game over. Return the frames played
+func (h *Harness) Run(n int) int {
+	played := 0
+	for played < n && h.Step() {
+		played++
+	}
+	return played
+}
+
+// Over a loop has stopped: someone topped out or the match was forfeited
+func (h *Harness) Over() bool {
+	for _, exec := range h.game.players {
+		select {
+		case <-exec.gl.quit:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+func (h *Harness) Frame() int {
+	return h.frame
+}
+
+// State the board of a player at its last simulated frame
+func (h *Harness) State(playerId string) (BoardStateDTO, int) {
+	fq := h.game.players[playerId].frames
+	bs, _ := fq.Get(fq.simFrame)
+	return bs.ToDTO(), fq.simFrame
+}
+
+// Messages everything sent to a player so far, of msgType only unless it is empty
+func (h *Harness) Messages(playerId string, msgType string) []Message {
+	var msgs []Message
+	for _, packet := range h.sent {
+		if packet.directId != "" && packet.directId != playerId || packet.excludeId == playerId {
+			continue
+		}
+		if msgType == "" || packet.msg.Type == msgType {
+			msgs = append(msgs, packet.msg)
+		}
+	}
+	return msgs
+}
+
+func (h *Harness) collect() {
+	for len(h.broadcast) > 0 {
+		h.sent = append(h.sent, <-h.broadcast)
+	}
+}
@@ -0,0 +1,164 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTetrisHarness player-1 plays the I piece tetris cycle of the long game test, player-2 hard drops
+// one piece every 20 frames from dropFrom
+func newTetrisHarness(seed int64, dropFrom int) *Harness {
+	h := NewHarness(DefaultRoomSettings(), seed, "player-1", "player-2")
+	tetris := make([]int, 100)
+	for i := range tetris {
+		tetris[i] = 1
+	}
+	h.SetList("player-1", tetris)
+	h.SetList("player-2", DefaultRoomSettings().GenerateList(nil, 100))
+	for frame := 1; frame <= 1000; frame++ {
+		cycleFrame := frame % longGameCycleFrames
+		if cycleFrame%10 == 1 {
+			for i, keys := range longGameCycle[cycleFrame/10] {
+				h.Play("player-1", frame+i, keys...)
+			}
+			h.Play("player-1", frame+7, "space")
+		}
+		if frame >= dropFrom && frame%20 == 0 {
+			h.Play("player-2", frame, "space")
+		}
+	}
+	return h
+}
+
+func TestHarness(t *testing.T) {
+	t.Run("same script ends on the same boards", func(t *testing.T) {
+		a := newTetrisHarness(7, 200)
+		b := newTetrisHarness(7, 200)
+		b.SetList("player-2", a.game.players["player-2"].listBlock)
+		//player-2 tops out under the tetrises at the same frame in both runs
+		played := a.Run(1000)
+		assert.Less(t, played, 1000)
+		assert.Equal(t, played, b.Run(1000))
+		for _, pId := range []string{"player-1", "player-2"} {
+			stateA, frameA := a.State(pId)
+			stateB, frameB := b.State(pId)
+			assert.Equal(t, frameA, frameB)
+			assert.Equal(t, stateA, stateB)
+		}
+		assert.Equal(t, a.Messages("player-1", ""), b.Messages("player-1", ""))
+	})
+	t.Run("tetris attack lands on the opponent", func(t *testing.T) {
+		h := newTetrisHarness(1, 200)
+		h.Run(199)
+		incoming := h.Messages("player-2", "attack-incoming")
+		if assert.Len(t, incoming, 1) {
+			atk := incoming[0].Payload.Attack
+			assert.Equal(t, "player-2", incoming[0].PlayerId)
+			assert.Equal(t, "player-1", atk.Source)
+			//combo ends on the first lock after the tetris at 98, arrival is GarbageDelay later
+			assert.Equal(t, 108+DefaultRoomSettings().GarbageDelay, atk.Frame)
+			state, _ := h.State("player-2")
+			assert.Equal(t, 0, countGarbageRows(state.Board))
+
+			//first lock of player-2, at most GarbageCap lines per piece
+			h.Run(1)
+			state, _ = h.State("player-2")
+			garbageCap := DefaultRoomSettings().GarbageCap
+			assert.Equal(t, min(atk.Amount, garbageCap), countGarbageRows(state.Board))
+			assert.Len(t, h.Messages("player-2", "garbage-sync"), 1)
+			h.Run(20)
+			state, _ = h.State("player-2")
+			assert.Equal(t, atk.Amount, countGarbageRows(state.Board))
+		}
+	})
+	t.Run("topping out ends the match", func(t *testing.T) {
+		h := newTetrisHarness(1, 1)
+		var results []MatchResult
+		h.game.onResult = func(res MatchResult) { results = append(results, res) }
+		played := h.Run(1000)
+		assert.Less(t, played, 1000)
+		assert.True(t, h.Over())
+		assert.False(t, h.Step())
+		gameover := h.Messages("player-1", "gameover")
+		assert.Len(t, gameover, 1)
+		//the other one wins
+		if assert.Len(t, results, 1) {
+			res := results[0]
+			assert.ElementsMatch(t, []string{"player-1", "player-2"}, []string{res.Winner, res.Loser})
+			assert.Equal(t, res.Winner, gameover[0].PlayerId)
+			assert.Equal(t, res.Loser+" topped out", res.Reason)
+			stats := res.Players[res.Loser]
+			assert.Equal(t, played-ROLLBACK_WINDOW, stats.Frames, "only final frames are counted")
+			assert.NotZero(t, stats.Pieces)
+			assert.InDelta(t, float64(stats.Pieces)*TICK/float64(stats.Frames), stats.PPS(), 0.001)
+		}
+	})
+	t.Run("stalled client is auto simulated then caught up in one batch", func(t *testing.T) {
+		h := newTetrisHarness(1, 1000)
+		delay := h.game.players["player-2"].delay
+		h.Run(10)
+		h.Stall("player-2", true)
+		h.Run(50)
+		//tickFrame moves after onUpdate, it is one behind the harness frame
+		_, simFrame := h.State("player-2")
+		assert.Equal(t, h.Frame()-1-delay, simFrame)
+		_, simFrame = h.State("player-1")
+		assert.Equal(t, 60, simFrame)
+
+		h.Stall("player-2", false)
+		h.Step()
+		_, simFrame = h.State("player-2")
+		assert.Equal(t, 61, simFrame)
+	})
+}
+
+func TestManualClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	ticks := 0
+	gl := NewGameLoop(func(chan Packet) { ticks++ }, nil, nil, nil, nil)
+	gl.clock = clock
+	done := make(chan struct{})
+	startAt := clock.Now().Add(time.Second)
+	go func() {
+		gl.Run(make(chan Packet), startAt)
+		close(done)
+	}()
+
+	clock.Tick() //no ticker before startAt
+	assert.Equal(t, 0, clock.Tickers())
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return clock.Tickers() == 1 }, time.Second, time.Millisecond)
+	for i := 0; i < 5; i++ {
+		clock.Tick()
+	}
+	gl.Pause()
+	assert.Equal(t, 5, ticks) //Pause is taken after the 5th tick is done
+	assert.Equal(t, 0, clock.Tickers())
+	clock.Tick()
+	gl.Resume()
+	gl.Stop()
+	<-done
+	assert.Equal(t, 5, ticks)
+
+	t.Run("paused during the countdown", func(t *testing.T) {
+		gl := NewGameLoop(func(chan Packet) { ticks++ }, nil, nil, nil, nil)
+		gl.clock = clock
+		done := make(chan struct{})
+		go func() {
+			gl.Run(make(chan Packet), clock.Now().Add(time.Second))
+			close(done)
+		}()
+		gl.Pause() //taken before startAt, doesn't wait for it
+		clock.Advance(time.Second)
+		clock.Tick()
+		assert.Equal(t, 0, clock.Tickers(), "startAt passed while paused, no ticker yet")
+		gl.Resume()
+		assert.Eventually(t, func() bool { return clock.Tickers() == 1 }, time.Second, time.Millisecond)
+		clock.Tick()
+		gl.Stop()
+		<-done
+		assert.Equal(t, 6, ticks)
+	})
+}
@@ -8,28 +8,30 @@ import (
 type FrameQueue struct {
 	data []*BoardState
 
-	garbage  []int // size > 45*2 (delay 45 frame +- head and tail
-	cancel   []int
+	garbage  GarbageQueue
 	simFrame int //simulation frame index
 	cap      int
 	size     int
+	incoming int // garbage delay in frames
 }
+
+// Attack sent by source at its frame atFrame, lands incoming frames later
 type Attack struct {
+	source  string
 	lines   int
 	atFrame int
 }
 
-func NewQueue(cap int) *FrameQueue {
+func NewQueue(cap int, settings RoomSettings) *FrameQueue {
 	this := &FrameQueue{
 		data:     make([]*BoardState, cap),
-		garbage:  make([]int, cap),
-		cancel:   make([]int, cap), // The line sweep algorithm is applied to simulate the expiration of canceled upcoming garbage lines.
 		simFrame: 0,
 		cap:      cap,
 		size:     0,
+		incoming: settings.GarbageDelay,
 	}
 	for i := 0; i < cap; i++ {
-		this.data[i] = NewDefaultBoardState()
+		this.data[i] = NewDefaultBoardState(settings.Board)
 	}
 	return this
 }
@@ -41,40 +43,6 @@ func (q *FrameQueue) Get(frame int) (*BoardState, error) {
 	}
 	return q.data[frame%q.cap], nil
 }
-func (q *FrameQueue) GetGarbage(frame int) int {
-	diff := frame - q.simFrame
-	if diff > q.cap/2 || diff < -q.cap/2 {
-		return -1
-	}
-	lines := q.garbage[frame%q.cap]
-
-	q.garbage[frame%q.cap] = 0 // clear old state after apply
-	return lines
-}
-func (q *FrameQueue) GetCancel(frame int) int {
-	lines := q.garbage[frame%q.cap]
-	q.cancel[frame%q.cap] = 0 // clear old state after apply
-	return lines
-
-}
-func (q *FrameQueue) GarbageUpcoming(frame int, qty int) error {
-	diff := frame - q.simFrame
-	if diff > q.cap/2 || diff < -q.cap/2 {
-		return errors.New("out of range")
-	}
-	q.garbage[(frame+INCOMING)%q.cap] += qty
-	return nil
-}
-func (q *FrameQueue) CancelGarbage(frame int, qty int) error {
-	diff := frame - q.simFrame
-	if diff > q.cap/2 || diff < -q.cap/2 {
-		return errors.New("out of range")
-	}
-	q.cancel[frame%q.cap] -= qty
-	q.cancel[(frame+INCOMING)%q.cap] += qty //expire
-	return nil
-}
-
 func (q *FrameQueue) Forward() {
 	q.simFrame++
 }
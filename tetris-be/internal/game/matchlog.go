@@ -0,0 +1,230 @@
+package game
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	SNAPSHOT_INTERVAL = 5 * TICK // frames between two compacted snapshots
+	maxSegments       = 720      // one hour of segments, older ones are dropped
+)
+
+var ErrNotLogged = errors.New("frame is not in the match log")
+var ErrLogTruncated = errors.New("frame was dropped from the match log")
+
+// snapshot only flags, after the state flags of the codec
+const (
+	sRotated = sLockTime << (iota + 1)
+	sSoftDrop
+)
+
+// MatchLog keep a player's match compact: a snapshot every SNAPSHOT_INTERVAL final frames, the
+// inputs played after it and every attack received. The FrameQueue ring only holds the last
+// frames, a reattached client gets the inputs it missed from the log. Restore rebuilds a past
+// frame from it, only the last maxSegments are kept so an hour or more back is gone.
+// Only frames that can't be rolled back anymore are written, so nothing is ever corrected
+type MatchLog struct {
+	segments  []logSegment
+	attacks   GarbageQueue // attacks received, pruned with the segments
+	final     int          // last frame written
+	truncated bool         // compact dropped segments, the start of the match is gone
+}
+
+// logSegment a snapshot of frame and the inputs of frames after it, up to the next segment
+type logSegment struct {
+	frame    int
+	snapshot []byte
+	inputs   []byte // uvarint frame delta from previous input (or frame), key bitmask byte
+	last     int    // frame of the last input written
+}
+
+// commit write a final frame: a new segment on snapshot frames, its inputs otherwise
+func (m *MatchLog) commit(frame int, bs *BoardState) {
+	m.final = frame
+	//input of a snapshot frame goes with the segment before, the snapshot already has it applied
+	if keys := keyMask(bs.inputBuffer); keys != 0 && len(m.segments) > 0 {
+		seg := &m.segments[len(m.segments)-1]
+		seg.inputs = binary.AppendUvarint(seg.inputs, uint64(frame-seg.last))
+		seg.inputs = append(seg.inputs, keys)
+		seg.last = frame
+	}
+	if frame%SNAPSHOT_INTERVAL == 0 || len(m.segments) == 0 {
+		m.segments = append(m.segments, logSegment{frame: frame, snapshot: encodeSnapshot(bs), last: frame})
+		m.compact()
+	}
+}
+
+func (m *MatchLog) receive(e GarbageEntry) {
+	m.attacks.Push(e)
+}
+
+// compact drop the oldest segments over maxSegments, and the attacks fully consumed before
+// the oldest snapshot left
+func (m *MatchLog) compact() {
+	if len(m.segments) <= maxSegments {
+		return
+	}
+	m.segments = m.segments[len(m.segments)-maxSegments:]
+	m.truncated = true
+	if bs, err := decodeSnapshot(m.segments[0].snapshot); err == nil {
+		m.attacks.prune(bs.garbageTaken)
+	}
+}
+
+// Size bytes held by the log
+func (m *MatchLog) Size() int {
+	size := 0
+	for _, seg := range m.segments {
+		size += len(seg.snapshot) + len(seg.inputs)
+	}
+	for _, e := range m.attacks.entries {
+		size += len(e.Source) + 4*8
+	}
+	return size
+}
+
+// Inputs every logged input of frames in (from, to]
+func (m *MatchLog) Inputs(from, to int) []Input {
+	var inputs []Input
+	for _, seg := range m.segments {
+		if seg.last <= from || seg.frame > to {
+			continue
+		}
+		frame := seg.frame
+		data := seg.inputs
+		for len(data) > 0 {
+			delta, n := binary.Uvarint(data)
+			if n <= 0 || len(data) < n+1 {
+				break
+			}
+			frame += int(delta)
+			keys := data[n]
+			data = data[n+1:]
+			if frame > from && frame <= to {
+				inputs = append(inputs, Input{Frame: frame, Keys: maskKeys(keys)})
+			}
+		}
+	}
+	return inputs
+}
+
+// Restore rebuild the state of frame from the latest snapshot before it, re-simulating the logged
+// inputs and attacks. The returned executor can keep simulating from there.
+// Not used by the server yet, a resync sends the current state from the ring
+func (m *MatchLog) Restore(playerId string, settings RoomSettings, listBlock []int, frame int) (*FrameExecutor, error) {
+	var seg *logSegment
+	for i := range m.segments {
+		if m.segments[i].frame <= frame {
+			seg = &m.segments[i]
+		}
+	}
+	if seg == nil && m.truncated {
+		return nil, ErrLogTruncated
+	}
+	if seg == nil || frame > m.final {
+		return nil, ErrNotLogged
+	}
+	bs, err := decodeSnapshot(seg.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	exec := NewFrameExecutor(playerId, settings)
+	exec.listBlock = listBlock
+	fq := exec.frames
+	fq.simFrame = seg.frame
+	bs.events.frame = seg.frame
+	fq.data[seg.frame%fq.cap] = bs
+	fq.garbage = GarbageQueue{entries: append([]GarbageEntry(nil), m.attacks.entries...), dropped: m.attacks.dropped}
+
+	inputs := make(map[int][]string)
+	for _, in := range m.Inputs(seg.frame, frame) {
+		inputs[in.Frame] = in.Keys
+	}
+	for f := seg.frame + 1; f <= frame; f++ {
+		slot, _ := fq.Get(f)
+		slot.events = frameEvents{frame: f}
+		slot.inputBuffer = InputBuffer{}
+		for _, k := range inputs[f] {
+			slot.inputBuffer[key(k)] = true
+		}
+		//replay: nothing is sent, the live executor already did
+		if err := exec.simulateFrame(f, true, nil); err != nil {
+			return exec, err
+		}
+		fq.Forward()
+	}
+	return exec, nil
+}
+
+func keyMask(input InputBuffer) byte {
+	var mask byte
+	for k, v := range input {
+		if bit := keyBit(k); v && bit >= 0 {
+			mask |= 1 << bit
+		}
+	}
+	return mask
+}
+func maskKeys(mask byte) []string {
+	keys := []string{}
+	for bit, k := range inputKeys {
+		if mask&(1<<bit) != 0 {
+			keys = append(keys, string(k))
+		}
+	}
+	return keys
+}
+
+// encodeSnapshot pack what a frame needs to continue: grids as nibbles, numbers as varints
+func encodeSnapshot(bs *BoardState) []byte {
+	buf, _ := appendGrid(make([]byte, 0, 160), bs.board)
+	buf, _ = appendGrid(buf, bs.block.shape)
+	for _, v := range []int{bs.block.form, bs.blockIndex, bs.holdBlock, bs.cRow, bs.cCol, bs.combo, bs.send, bs.garbageTaken,
+		bs.moves.inputs, bs.moves.shift, bs.moves.shiftFrame} {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	var flags byte
+	if bs.canHold {
+		flags |= sCanHold
+	}
+	if bs.onGround {
+		flags |= sOnGround
+	}
+	//T-spins and finesse of the piece in play depend on how it got there
+	if bs.moves.rotated {
+		flags |= sRotated
+	}
+	if bs.moves.softDrop {
+		flags |= sSoftDrop
+	}
+	buf = append(buf, flags)
+	for _, f := range []float64{bs.dropSpeed, bs.gravityTimer, bs.lockTimer} {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+	}
+	return appendString(buf, bs.b2b)
+}
+
+func decodeSnapshot(data []byte) (*BoardState, error) {
+	r := &reader{data: data}
+	bs := &BoardState{board: r.grid(), inputBuffer: InputBuffer{}}
+	bs.block.shape = r.grid()
+	for _, v := range []*int{&bs.block.form, &bs.blockIndex, &bs.holdBlock, &bs.cRow, &bs.cCol, &bs.combo, &bs.send, &bs.garbageTaken,
+		&bs.moves.inputs, &bs.moves.shift, &bs.moves.shiftFrame} {
+		*v = int(r.varint())
+	}
+	flags := r.byte()
+	bs.canHold, bs.onGround = flags&sCanHold != 0, flags&sOnGround != 0
+	bs.moves.rotated, bs.moves.softDrop = flags&sRotated != 0, flags&sSoftDrop != 0
+	for _, f := range []*float64{&bs.dropSpeed, &bs.gravityTimer, &bs.lockTimer} {
+		if len(r.data) < 8 {
+			r.fail()
+			break
+		}
+		*f = math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+		r.data = r.data[8:]
+	}
+	bs.b2b = r.string()
+	return bs, r.err
+}
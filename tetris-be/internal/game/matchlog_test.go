@@ -0,0 +1,168 @@
+package game
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// piece inputs of a 10 piece cycle with only I pieces: two columns of horizontal pieces on cols 0-7,
+// then vertical ones on cols 8 and 9 clear a tetris. Each piece gets 10 frames, hard drop at the 8th
+var longGameCycle = [][][]string{
+	{{"left"}, {"left"}, {"left"}, {"left"}}, {},
+	{{"left"}, {"left"}, {"left"}, {"left"}}, {},
+	{{"left"}, {"left"}, {"left"}, {"left"}}, {},
+	{{"left"}, {"left"}, {"left"}, {"left"}}, {},
+	{{"rotate"}, {"right"}, {"right"}},
+	{{"rotate"}, {"right"}, {"right"}, {"right"}},
+}
+
+const longGameCycleFrames = 100
+
+// newLongGame a player that never tops out: every cycle ends with a tetris, canceling an attack
+// received just before it. Every 5 minutes a line of garbage arrives mid cycle and lands
+func newLongGame(tb testing.TB) *FrameExecutor {
+	tb.Helper()
+	listBlock := make([]int, 100)
+	for i := range listBlock {
+		listBlock[i] = 1
+	}
+	exec := newTestExecutor(tb, listBlock)
+	exec.frames.incoming = 30
+	exec.holes = rand.New(rand.NewSource(1))
+	return exec
+}
+
+// playLongGame simulate frames from..to of the long game, the way the loop does
+func playLongGame(tb testing.TB, exec *FrameExecutor, from, to int) {
+	tb.Helper()
+	broadcast := make(chan Packet, 64)
+	for frame := from; frame <= to; frame++ {
+		cycleFrame := frame % longGameCycleFrames
+		if cycleFrame%10 == 1 {
+			piece := longGameCycle[cycleFrame/10]
+			inputs := make([]Input, 0, len(piece)+1)
+			for i, keys := range piece {
+				inputs = append(inputs, Input{Frame: frame + i, Keys: keys})
+			}
+			inputs = append(inputs, Input{Frame: frame + 7, Keys: []string{"space"}})
+			exec.recordInputs(0, inputs, frame-1, broadcast)
+		}
+		switch {
+		case cycleFrame == 90:
+			exec.receiveGarbage(Attack{source: "player-2", lines: 2, atFrame: frame}, broadcast)
+		case cycleFrame == 0 && frame%(5*60*TICK) == 0:
+			exec.receiveGarbage(Attack{source: "player-2", lines: 1, atFrame: frame}, broadcast)
+		}
+		if err := exec.computeBatchFrames(frame, frame, broadcast); err != nil {
+			tb.Fatalf("frame %d: %v", frame, err)
+		}
+		exec.flushAttacks(broadcast)
+		for len(broadcast) > 0 {
+			<-broadcast
+		}
+	}
+}
+
+func TestMatchLog(t *testing.T) {
+	t.Run("snapshot round trip", func(t *testing.T) {
+		exec := newLongGame(t)
+		playLongGame(t, exec, 1, 437)
+		bs, _ := exec.frames.Get(437)
+		got, err := decodeSnapshot(encodeSnapshot(bs))
+		assertNoErr(t, err)
+		assert.Equal(t, bs.ToDTO(), got.ToDTO())
+		assert.Equal(t, bs.Checksum(), got.Checksum())
+		assert.Equal(t, bs.garbageTaken, got.garbageTaken)
+		assert.Equal(t, bs.blockIndex, got.blockIndex)
+		assert.Equal(t, bs.moves, got.moves)
+
+		moved := *bs
+		moved.moves = pieceMoves{inputs: 3, shift: -1, shiftFrame: 436, rotated: true, softDrop: true}
+		got, err = decodeSnapshot(encodeSnapshot(&moved))
+		assertNoErr(t, err)
+		assert.Equal(t, moved.moves, got.moves)
+	})
+	t.Run("restore replays to the live state", func(t *testing.T) {
+		exec := newLongGame(t)
+		playLongGame(t, exec, 1, 1234)
+		mid, _ := exec.frames.Get(1234)
+		want := mid.ToDTO()
+		taken := mid.garbageTaken
+		playLongGame(t, exec, 1235, 11*60*TICK)
+		//past the end of the piece list, and garbage landed
+		assert.Greater(t, exec.frames.data[0].blockIndex, len(exec.listBlock))
+		assert.NotZero(t, countGarbageRows(exec.frames.data[0].board))
+
+		restored, err := exec.log.Restore(exec.playerId, exec.settings, exec.listBlock, 1234)
+		assertNoErr(t, err)
+		got, _ := restored.frames.Get(1234)
+		assert.Equal(t, want, got.ToDTO())
+		assert.Equal(t, taken, got.garbageTaken)
+
+		final := exec.flushedFrame
+		restored, err = exec.log.Restore(exec.playerId, exec.settings, exec.listBlock, final)
+		assertNoErr(t, err)
+		live, _ := exec.frames.Get(final)
+		got, _ = restored.frames.Get(final)
+		assert.Equal(t, live.ToDTO(), got.ToDTO())
+		assert.Equal(t, live.Checksum(), got.Checksum())
+		assert.Equal(t, live.moves, got.moves)
+
+		_, err = exec.log.Restore(exec.playerId, exec.settings, exec.listBlock, final+1)
+		assert.ErrorIs(t, err, ErrNotLogged)
+	})
+	t.Run("reattached client gets inputs from the log and the ring", func(t *testing.T) {
+		exec := newLongGame(t)
+		playLongGame(t, exec, 1, 1001)
+		ackFrame := exec.flushedFrame - 2*SNAPSHOT_INTERVAL
+		inputs := exec.inputsSince(ackFrame)
+		for i, in := range inputs {
+			assert.Greater(t, in.Frame, ackFrame)
+			if i > 0 {
+				assert.Greater(t, in.Frame, inputs[i-1].Frame)
+			}
+		}
+		//every hard drop after ackFrame, the one of the piece already recorded included
+		want, drops := 0, 0
+		for frame := ackFrame + 1; frame <= 1008; frame++ {
+			if frame%10 == 8 {
+				want++
+			}
+		}
+		for _, in := range inputs {
+			if len(in.Keys) == 1 && in.Keys[0] == "space" {
+				drops++
+			}
+		}
+		assert.Equal(t, want, drops)
+		assert.Equal(t, []Input{{Frame: 1008, Keys: []string{"space"}}}, inputs[len(inputs)-1:])
+	})
+	t.Run("old segments are dropped", func(t *testing.T) {
+		var m MatchLog
+		bs := newTestExecutor(t, []int{1}).frames.data[0]
+		bs.inputBuffer = InputBuffer{left: true}
+		frames := (maxSegments + 10) * SNAPSHOT_INTERVAL
+		for frame := 1; frame <= frames; frame++ {
+			m.commit(frame, bs)
+		}
+		assert.Len(t, m.segments, maxSegments)
+		assert.Equal(t, frames-(maxSegments-1)*SNAPSHOT_INTERVAL, m.segments[0].frame)
+		assert.Empty(t, m.Inputs(0, m.segments[0].frame))
+		assert.Len(t, m.Inputs(0, frames), frames-m.segments[0].frame)
+		_, err := m.Restore("player-1", DefaultRoomSettings(), []int{1}, SNAPSHOT_INTERVAL)
+		assert.ErrorIs(t, err, ErrLogTruncated, "a replay from the start can't be complete")
+	})
+}
+
+// BenchmarkLongGame a 30 minute match through the simulation and the match log
+func BenchmarkLongGame(b *testing.B) {
+	frames := 30 * 60 * TICK
+	for i := 0; i < b.N; i++ {
+		exec := newLongGame(b)
+		playLongGame(b, exec, 1, frames)
+		b.ReportMetric(float64(exec.log.Size()), "log-B/match")
+		b.ReportMetric(float64(len(exec.log.segments)), "segments/match")
+	}
+}
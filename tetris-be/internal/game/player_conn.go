@@ -1,7 +1,6 @@
 package game
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
@@ -16,16 +15,45 @@ type PlayerConn struct {
 	// The websocket connection.
 	conn *websocket.Conn
 	// Buffered channel of outbound messages
-	send chan []byte
+	send       chan Message
+	sendClosed bool  // owned by room.listenAndServe
+	codec      Codec // negotiated through the websocket subprotocol
+
+	clock *ClockSync
+
+	//set when the client reconnects into a running match
+	resumeToken string
+	ackFrame    int
+
+	bot *BotConfig // virtual player played by the server, conn is nil
 }
 
 func NewPlayerConn(ID string, room *Room, conn *websocket.Conn) *PlayerConn {
 	return &PlayerConn{
-		ID:   ID,
-		r:    room,
-		conn: conn,
-		send: make(chan []byte, 256),
+		ID:    ID,
+		r:     room,
+		conn:  conn,
+		send:  make(chan Message, 256),
+		clock: NewClockSync(),
+		codec: codecOf(conn),
+	}
+}
+
+func codecOf(conn *websocket.Conn) Codec {
+	if conn == nil {
+		return JSONCodec{}
+	}
+	return CodecFor(conn.Subprotocol())
+}
+
+// closeSend can be reached from leave and from a dropped broadcast, close the channel only once.
+// Only called from room.listenAndServe
+func (p *PlayerConn) closeSend() {
+	if p.sendClosed {
+		return
 	}
+	p.sendClosed = true
+	close(p.send)
 }
 
 // classifyErr trả về mô tả lỗi thân thiện
@@ -78,7 +106,10 @@ const (
 
 func (p *PlayerConn) Read() {
 	defer func() {
-		p.r.leave <- p
+		select {
+		case p.r.leave <- p:
+		case <-p.r.stop: //room already closed
+		}
 		p.conn.Close()
 	}()
 	p.conn.SetReadLimit(maxMessageSize)
@@ -88,7 +119,7 @@ func (p *PlayerConn) Read() {
 		return nil
 	})
 	for {
-		_, msg, err := p.conn.ReadMessage()
+		frameType, msg, err := p.conn.ReadMessage()
 		//fmt.Printf("Server received: %s\n", msg)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -97,7 +128,7 @@ func (p *PlayerConn) Read() {
 			return
 		}
 		//TODO
-		p.handleMessage(msg)
+		p.handleMessage(frameType, msg)
 
 	}
 }
@@ -116,8 +147,10 @@ func (p *PlayerConn) Read() {
 // Write() k0 nhận message trực tiếp từ p.send, tất cả đều đi qua chan broadcast , broadcast sẽ gửi vào chan p.send
 func (p *PlayerConn) Write() {
 	ticker := time.NewTicker(pingPeriod) //send ping pong every period duration to simulate heartbeat of connection
+	syncTicker := time.NewTicker(clockSyncFast)
 	defer func() {
 		ticker.Stop()
+		syncTicker.Stop()
 		p.conn.Close()
 	}()
 	for {
@@ -128,14 +161,19 @@ func (p *PlayerConn) Write() {
 				p.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
+			body, err := p.codec.Encode(message)
+			if err != nil {
+				log.Printf("[ws][%s] encode %s error: %v", p.ID, message.Type, err)
+				continue
+			}
 			p.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			w, err := p.conn.NextWriter(websocket.TextMessage)
+			w, err := p.conn.NextWriter(p.codec.FrameType())
 			if err != nil {
 				log.Printf("[ws][%s] %s", p.ID, classifyErr("NextWriter error", err))
 				return
 			}
 
-			if _, err = w.Write(message); err != nil {
+			if _, err = w.Write(body); err != nil {
 				log.Printf("[ws][%s] %s", p.ID, classifyErr("Write error", err))
 				w.Close()
 				return
@@ -153,31 +191,66 @@ func (p *PlayerConn) Write() {
 				log.Printf("[ws][%s] %s", p.ID, classifyErr("Ping write error", err))
 				return
 			}
+		case <-syncTicker.C:
+			//application level ping for clock sync, control frames don't carry our timestamps
+			if _, _, _, samples := p.clock.Estimate(); samples >= clockSyncBurst {
+				syncTicker.Reset(clockSyncSlow)
+			}
+			msg := NewMessage("ping")
+			msg.Timestamp = p.r.game.clock.Now().UnixMilli()
+			body, _ := p.codec.Encode(msg)
+			p.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := p.conn.WriteMessage(p.codec.FrameType(), body); err != nil {
+				log.Printf("[ws][%s] %s", p.ID, classifyErr("Clock sync write error", err))
+				return
+			}
 		}
 	}
 
 }
 
-func (p *PlayerConn) handleMessage(raw []byte) {
+func (p *PlayerConn) handleMessage(frameType int, raw []byte) {
+	p.r.touch()
 
+	codec, err := codecForFrame(frameType, p.codec)
 	var msg Message
-	err := json.Unmarshal(raw, &msg)
+	if err == nil {
+		msg, err = codec.Decode(raw)
+	}
 	if err != nil {
-		//
+		log.Printf("[ws][%s] malformed message: %v", p.ID, err)
+		p.r.game.Report(Violation{PlayerId: p.ID, Reason: "malformed message", At: p.r.game.clock.Now()}, p.r.broadcast)
+		reply := NewMessage("error")
+		reply.Error = "malformed message"
+		p.r.broadcast <- Packet{directId: p.ID, msg: reply}
+		return
 	}
 
 	//exclude message
 	switch msg.Type {
 
 	case "inputs":
-		p.r.game.players[p.ID].gl.input <- msg
+		if exec := p.r.game.player(p.ID); exec != nil {
+			exec.gl.Input(msg)
+		}
 
+	case "opponent-ack", "keyframe":
+		//msg.PlayerId is the owner of the board being watched
+		if exec := p.r.game.player(msg.PlayerId); exec != nil && msg.PlayerId != p.ID {
+			exec.gl.View(viewRequest{viewer: p.ID, seq: msg.Payload.Seq, keyframe: msg.Type == "keyframe"})
+		}
 	case "ping":
-		p.r.game.computeDelayBuffer(msg, p.r.broadcast)
+		p.r.game.pong(msg, p.ID, p.r.broadcast)
+	case "pong":
+		p.clock.AddSample(msg.Timestamp, msg.Payload.ClientTime, p.r.game.clock.Now().UnixMilli())
 	case "start":
 		p.r.game.StartGame(p.r.broadcast)
 	case "ready":
-		p.r.game.Init(p.r.broadcast, p.r.PlayerConns, p.ID)
+		//the room goroutine owns the seats, it starts the match from a copy of them
+		select {
+		case p.r.ready <- p.ID:
+		case <-p.r.stop:
+		}
 	case "pause":
 		p.r.game.Pause()
 	case "unpause":
@@ -190,14 +263,41 @@ func (p *PlayerConn) handleMessage(raw []byte) {
 }
 
 type BoardStateDTO struct {
-	Board [][]int `json:"board,omitempty"`
-	Block [][]int `json:"block,omitempty"`
-	CRow  int     `json:"cRow"`
-	CCol  int     `json:"cCol"`
+	Board       [][]int `json:"board,omitempty"`
+	Block       [][]int `json:"block,omitempty"`
+	CRow        int     `json:"cRow"`
+	CCol        int     `json:"cCol"`
+	BForm       int     `json:"bForm,omitempty"`
+	Hold        int     `json:"holdBlock,omitempty"`
+	BlockIndex  int     `json:"blockIndex,omitempty"`
+	CanHold     bool    `json:"canHold,omitempty"`
+	DropSpeed   float64 `json:"dropSpeed,omitempty"`
+	Accumulator float64 `json:"accumulator,omitempty"`
+	LockTime    float64 `json:"lockTime,omitempty"`
+	OnGround    bool    `json:"onGround,omitempty"`
+}
+
+func (bs *BoardState) ToDTO() BoardStateDTO {
+	return BoardStateDTO{
+		Board:       bs.board,
+		Block:       bs.block.shape,
+		CRow:        bs.cRow,
+		CCol:        bs.cCol,
+		BForm:       bs.block.form,
+		Hold:        bs.holdBlock,
+		BlockIndex:  bs.blockIndex,
+		CanHold:     bs.canHold,
+		DropSpeed:   bs.dropSpeed,
+		Accumulator: bs.gravityTimer,
+		LockTime:    bs.lockTimer,
+		OnGround:    bs.onGround,
+	}
 }
+
 type Input struct {
-	Keys  []string `json:"keys"`
-	Frame int      `json:"frame"`
+	Keys     []string `json:"keys"`
+	Frame    int      `json:"frame"`
+	Checksum uint32   `json:"checksum,omitempty"` // client state after Frame, see BoardState.Checksum
 }
 type Message struct {
 	Type     string `json:"type"`
@@ -208,6 +308,17 @@ type Message struct {
 		BoardState  BoardStateDTO `json:"state,omitempty"`
 		Inputs      []Input       `json:"inputs,omitempty"`
 		StartAt     int64         `json:"startAt,omitempty"`
+		ResumeToken string        `json:"resumeToken,omitempty"`
+		InputDelay  int           `json:"inputDelay,omitempty"`
+		ClientTime  int64         `json:"clientTime,omitempty"`
+		ServerTime  int64         `json:"serverTime,omitempty"`
+		Checksum    uint32        `json:"checksum,omitempty"`
+		Seq         int           `json:"seq,omitempty"`     // board update or input batch sequence
+		BaseSeq     int           `json:"baseSeq,omitempty"` // update the delta applies on
+		Rows        []RowDelta    `json:"rows,omitempty"`
+		Rejected    []int         `json:"rejected,omitempty"` // input frames the server refused
+		Attack      *AttackDTO    `json:"attack,omitempty"`
+		Stats       *StatsDTO     `json:"stats,omitempty"`
 	} `json:"payload"`
 	Timestamp int64  `json:"timestamp"`
 	Error     string `json:"error,omitempty"`
@@ -219,13 +330,6 @@ func NewMessage(t string) Message {
 		//Timestamp: time.Now().UnixMilli(),
 	}
 }
-func MarshalMessage(msg Message) []byte {
-	res, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Errorf("marshal error: %v  messge: {%+v}", err, msg)
-	}
-	return res
-}
 
 func (p *PlayerConn) GetConn() *websocket.Conn {
 	return p.conn
@@ -236,3 +340,9 @@ func (p *PlayerConn) SetConn(conn *websocket.Conn) {
 func (p *PlayerConn) GetRoomID() string {
 	return p.r.ID
 }
+
+// SetResume marks this connection as a reattach of a player inside its reconnect grace window
+func (p *PlayerConn) SetResume(token string, ackFrame int) {
+	p.resumeToken = token
+	p.ackFrame = ackFrame
+}
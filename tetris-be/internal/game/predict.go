@@ -0,0 +1,98 @@
+package game
+
+// Predictor run the server simulation on the client for the player's own board, so a key shows
+// up on the frame it is pressed. A state pushed by the server (server-state, garbage-sync, resync)
+// replaces the prediction at its frame, the frames after it are predicted again from the keys
+type Predictor struct {
+	exec *FrameExecutor
+	keys map[int][]string // keys of the frames still in the ring
+}
+
+func NewPredictor(settings RoomSettings, listBlock []int) *Predictor {
+	exec := NewFrameExecutor("", settings)
+	exec.listBlock = listBlock
+	exec.start()
+	return &Predictor{exec: exec, keys: map[int][]string{}}
+}
+
+// Frame the last predicted frame
+func (p *Predictor) Frame() int {
+	return p.exec.frames.simFrame
+}
+
+// Step predict the next frame with keys pressed on it. ErrGameOver once the stack tops out
+func (p *Predictor) Step(keys []string) error {
+	fq := p.exec.frames
+	frame := fq.simFrame + 1
+	if len(keys) > 0 {
+		p.keys[frame] = keys
+	}
+	delete(p.keys, frame-fq.cap/2)
+	if err := p.simulate(frame); err != nil {
+		return err
+	}
+	fq.Forward()
+	return nil
+}
+
+// State the predicted board, valid until the next Step or Adopt
+func (p *Predictor) State() BoardStateDTO {
+	fq := p.exec.frames
+	bs, _ := fq.Get(fq.simFrame)
+	return bs.ToDTO()
+}
+
+func (p *Predictor) Checksum() uint32 {
+	fq := p.exec.frames
+	bs, _ := fq.Get(fq.simFrame)
+	return bs.Checksum()
+}
+
+// Adopt the server state of frame. A frame still in the ring is predicted again up to the current
+// one, anything older or ahead moves the prediction to frame
+func (p *Predictor) Adopt(frame int, state BoardStateDTO) error {
+	fq := p.exec.frames
+	last := fq.simFrame
+	slot, err := fq.Get(frame)
+	if err != nil || frame > last {
+		last = frame
+		fq.simFrame = frame
+		slot = fq.data[frame%fq.cap]
+	}
+	state.apply(slot)
+	slot.events = frameEvents{frame: frame}
+	for f := frame + 1; f <= last; f++ {
+		fq.simFrame = f - 1
+		if err := p.simulate(f); err != nil {
+			return err
+		}
+	}
+	fq.simFrame = last
+	return nil
+}
+
+func (p *Predictor) simulate(frame int) error {
+	fq := p.exec.frames
+	slot, _ := fq.Get(frame)
+	slot.events = frameEvents{frame: frame}
+	slot.inputBuffer = InputBuffer{}
+	for _, k := range p.keys[frame] {
+		slot.inputBuffer[key(k)] = true
+	}
+	//replay: a prediction has nobody to talk to
+	return p.exec.simulateFrame(frame, true, nil)
+}
+
+// apply copy a server state into bs, what the DTO doesn't carry (combo, b2b) is left as it is
+func (dto BoardStateDTO) apply(bs *BoardState) {
+	bs.board = copySlice(dto.Board)
+	bs.block = Block{shape: copySlice(dto.Block), form: dto.BForm}
+	bs.holdBlock = dto.Hold
+	bs.blockIndex = dto.BlockIndex
+	bs.cRow, bs.cCol = dto.CRow, dto.CCol
+	bs.canHold = dto.CanHold
+	bs.dropSpeed = dto.DropSpeed
+	bs.gravityTimer = dto.Accumulator
+	bs.lockTimer = dto.LockTime
+	bs.onGround = dto.OnGround
+}
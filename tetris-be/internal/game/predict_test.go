@@ -0,0 +1,80 @@
+package game
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cycleKeys keys of the long game cycle at frame, hard drop on the 8th frame of a piece
+func cycleKeys(frame int) []string {
+	cycleFrame := frame % longGameCycleFrames
+	piece, i := longGameCycle[cycleFrame/10], cycleFrame%10-1
+	switch {
+	case i == 7:
+		return []string{"space"}
+	case i >= 0 && i < len(piece):
+		return piece[i]
+	}
+	return nil
+}
+
+func TestPredictor(t *testing.T) {
+	listBlock := make([]int, 100)
+	for i := range listBlock {
+		listBlock[i] = 1
+	}
+	t.Run("prediction is the server simulation", func(t *testing.T) {
+		broadcast := make(chan Packet, 64)
+		exec := newTestExecutor(t, listBlock)
+		p := NewPredictor(DefaultRoomSettings(), listBlock)
+		for frame := 1; frame <= 500; frame++ {
+			keys := cycleKeys(frame)
+			exec.recordInputs(0, []Input{{Frame: frame, Keys: keys}}, frame, broadcast)
+			assertNoErr(t, exec.computeBatchFrames(frame, frame, broadcast))
+			assertNoErr(t, p.Step(keys))
+			bs, _ := exec.frames.Get(frame)
+			if !assert.Equal(t, bs.Checksum(), p.Checksum(), "frame %d", frame) {
+				return
+			}
+			for len(broadcast) > 0 {
+				<-broadcast
+			}
+		}
+		assert.Equal(t, 500, p.Frame())
+	})
+	t.Run("adopted garbage is predicted on", func(t *testing.T) {
+		broadcast := make(chan Packet, 64)
+		exec := newTestExecutor(t, listBlock)
+		exec.frames.incoming = 30
+		exec.holes = rand.New(rand.NewSource(1))
+		p := NewPredictor(DefaultRoomSettings(), listBlock)
+		landedAt := 0
+		for frame := 1; frame <= 200; frame++ {
+			if frame == 5 {
+				exec.receiveGarbage(Attack{source: "player-2", lines: 2, atFrame: frame}, broadcast)
+			}
+			keys := cycleKeys(frame)
+			exec.recordInputs(0, []Input{{Frame: frame, Keys: keys}}, frame, broadcast)
+			assertNoErr(t, exec.computeBatchFrames(frame, frame, broadcast))
+			assertNoErr(t, p.Step(keys))
+			for len(broadcast) > 0 {
+				<-broadcast
+			}
+			//the client hears about it a few frames later
+			if bs, _ := exec.frames.Get(max(frame-5, 0)); landedAt == 0 && bs.events.landed > 0 {
+				landedAt = frame - 5
+				now, _ := exec.frames.Get(frame)
+				assert.NotEqual(t, now.Checksum(), p.Checksum())
+				assertNoErr(t, p.Adopt(landedAt, bs.ToDTO()))
+			}
+			if landedAt > 0 {
+				bs, _ := exec.frames.Get(frame)
+				assert.Equal(t, bs.Checksum(), p.Checksum(), "frame %d", frame)
+			}
+		}
+		assert.NotZero(t, landedAt)
+		assert.Equal(t, 2, countGarbageRows(p.State().Board))
+	})
+}
@@ -2,29 +2,62 @@ package game
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"log"
+	"maps"
 	"math/big"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const letters = "abcdefghijklmnopqrstxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// ReconnectConfig decides what happens to a running match when a player's websocket drops
+type ReconnectConfig struct {
+	Grace     time.Duration // 0 disables reconnect, the seat is released right away
+	PauseGame bool          // pause both loops while someone is away, otherwise keep simulating
+}
+
+var DefaultReconnectConfig = ReconnectConfig{
+	Grace:     30 * time.Second,
+	PauseGame: true,
+}
+
 type Room struct {
 	ID          string
-	Key         string
+	Key         RoomKey
+	Settings    RoomSettings
+	Owner       string
+	CreatedAt   time.Time
 	PlayerConns map[string]*PlayerConn // map[playerId] *PlayerConn
+	connsMu     sync.RWMutex           // PlayerConns is written by listenAndServe only, read elsewhere under it
 	join        chan *PlayerConn
 	leave       chan *PlayerConn
 	broadcast   chan Packet
+	ready       chan string // playerId asking to start the match
 	game        *Game
 
+	reconnect    ReconnectConfig
+	resumeTokens map[string]string      // map[playerId] token issued on first join
+	disconnected map[string]*time.Timer // players inside reconnect grace window
+	expire       chan string
+
 	stop          chan struct{}
+	closing       chan struct{} // the janitor asks listenAndServe to close the room
 	callbackClose func()
+	clock         Clock
+	lastActive    int64 // unix nano, atomic: touched by the player goroutines too
+
+	status   atomic.Value     // RoomStatus, set from the room and the game goroutines
+	onStatus func(RoomStatus) // the room manager persists it, may be nil
 }
 
 type Packet struct {
-	directId  string //direct message to playerid
-	excludeId string // broadcast all exclude playerid // else this field and directid empty then broadcast all
-	body      []byte
+	directId  string  //direct message to playerid
+	excludeId string  // broadcast all exclude playerid // else this field and directid empty then broadcast all
+	msg       Message // encoded by each PlayerConn with its own codec
 }
 
 func (r *Room) listenAndServe() {
@@ -33,20 +66,57 @@ func (r *Room) listenAndServe() {
 	for {
 		select {
 		case pConn := <-r.join:
-			r.PlayerConns[pConn.ID] = pConn
+			r.touch()
+			if pConn.resumeToken != "" {
+				r.reattach(pConn)
+				continue
+			}
+			old, rejoin := r.PlayerConns[pConn.ID]
+			//tickets are checked against capacity over http, players racing for the last seat only meet here
+			if _, away := r.disconnected[pConn.ID]; !rejoin && !away && len(r.PlayerConns)+len(r.disconnected) >= r.Settings.Capacity {
+				log.Printf("[ws][room:%s] %s rejected: room is full", r.ID, pConn.ID)
+				msg := NewMessage("error")
+				msg.Error = "room is full"
+				pConn.send <- msg
+				pConn.closeSend()
+				continue
+			}
+			if rejoin && old != pConn {
+				old.closeSend()
+			}
+			r.seat(pConn)
+			r.issueResumeToken(pConn)
 			log.Printf("[ws][room:%s] %s joined, num players: %v ", r.ID, pConn.ID, len(r.PlayerConns))
 
 			//fmt.Println(player.ID)//for debug
 		case playerConn := <-r.leave:
+			r.touch()
 			if conn, ok := r.PlayerConns[playerConn.ID]; ok && playerConn == conn && conn != nil {
-				delete(r.PlayerConns, playerConn.ID)
-				if len(r.PlayerConns) == 0 {
-					close(r.stop)
+				r.unseat(playerConn.ID)
+				if r.game.IsPlaying() && r.reconnect.Grace > 0 {
+					r.holdSeat(playerConn.ID)
 				}
+				r.stopIfEmpty()
 			}
 			if playerConn != nil {
-				close(playerConn.send)
+				playerConn.closeSend()
 			}
+		case playerId := <-r.expire:
+			if _, away := r.disconnected[playerId]; !away {
+				continue //reattached before the timer fired
+			}
+			delete(r.disconnected, playerId)
+			log.Printf("[ws][room:%s] %s did not reconnect in time", r.ID, playerId)
+			go r.game.Forfeit(playerId, r.broadcast)
+			r.stopIfEmpty()
+		case playerId := <-r.ready:
+			r.touch()
+			if r.game.IsPlaying() {
+				//a reattached client says ready again, its match is still running
+				r.reply(playerId, "start", "match in progress")
+				continue
+			}
+			go r.game.Init(r.broadcast, maps.Clone(r.PlayerConns), playerId)
 
 		case msg := <-r.broadcast:
 			for _, pConn := range r.PlayerConns {
@@ -58,18 +128,205 @@ func (r *Room) listenAndServe() {
 					continue
 				}
 				select {
-				case pConn.send <- msg.body:
+				case pConn.send <- msg.msg:
 				default: //send channel is blocked
 					log.Printf("Drop message for %s: outbound full", pConn.ID)
-					close(pConn.send)
-					delete(r.PlayerConns, pConn.ID)
+					pConn.closeSend()
+					r.unseat(pConn.ID)
 				}
 
 			}
+		case <-r.closing:
+			r.close()
+		case <-r.stop:
+			r.shutdown()
+			return
+		}
+	}
+}
+
+// seat and unseat must be called from listenAndServe, it reads PlayerConns without the lock
+func (r *Room) seat(pConn *PlayerConn) {
+	r.connsMu.Lock()
+	defer r.connsMu.Unlock()
+	r.PlayerConns[pConn.ID] = pConn
+}
+func (r *Room) unseat(playerId string) {
+	r.connsMu.Lock()
+	defer r.connsMu.Unlock()
+	delete(r.PlayerConns, playerId)
+}
+
+// Players ids of the connected players, sorted. Safe from any goroutine
+func (r *Room) Players() []string {
+	r.connsMu.RLock()
+	defer r.connsMu.RUnlock()
+	return slices.Sorted(maps.Keys(r.PlayerConns))
+}
+
+// shutdown stop the match and every seat of a closed room. The loops may be blocked sending
+// to broadcast, keep reading it until they are gone
+func (r *Room) shutdown() {
+	for id, timer := range r.disconnected {
+		timer.Stop()
+		delete(r.disconnected, id)
+	}
+	for _, pConn := range r.PlayerConns {
+		pConn.closeSend()
+	}
+	ended := make(chan struct{})
+	go func() {
+		r.game.End()
+		close(ended)
+	}()
+	for {
+		select {
+		case <-r.broadcast:
+		case <-ended:
+			r.setStatus(RoomClosed)
+			return
+		}
+	}
+}
+
+// Close ask the room to close whoever is in it, returns once it is closing
+func (r *Room) Close() {
+	select {
+	case r.closing <- struct{}{}:
+	case <-r.stop:
+	}
+}
+
+// touch a player did something, the janitor leaves the room alone for a while
+func (r *Room) touch() {
+	atomic.StoreInt64(&r.lastActive, r.clock.Now().UnixNano())
+}
+
+// IdleFor how long since the last join, leave or message of a player
+func (r *Room) IdleFor() time.Duration {
+	return r.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&r.lastActive)))
+}
+
+// holdSeat keeps the player's FrameExecutor alive for reconnect.Grace, must be called from listenAndServe
+func (r *Room) holdSeat(playerId string) {
+	timer := time.AfterFunc(r.reconnect.Grace, func() {
+		select {
+		case r.expire <- playerId:
 		case <-r.stop:
+		}
+	})
+	r.disconnected[playerId] = timer
+	log.Printf("[ws][room:%s] %s disconnected, holding seat for %v", r.ID, playerId, r.reconnect.Grace)
+	if r.reconnect.PauseGame {
+		go r.game.Pause()
+	}
+	r.notify("disconnected", playerId)
+}
+
+// reattach swap the new connection in if it presents the token issued to that player
+func (r *Room) reattach(pConn *PlayerConn) {
+	token := r.resumeTokens[pConn.ID]
+	_, away := r.disconnected[pConn.ID]
+	if !away || subtle.ConstantTimeCompare([]byte(token), []byte(pConn.resumeToken)) != 1 {
+		log.Printf("[ws][room:%s] %s rejected: invalid resume token", r.ID, pConn.ID)
+		pConn.closeSend()
+		return
+	}
+	r.disconnected[pConn.ID].Stop()
+	delete(r.disconnected, pConn.ID)
+	r.seat(pConn)
+	log.Printf("[ws][room:%s] %s reconnected from frame %d", r.ID, pConn.ID, pConn.ackFrame)
+
+	unpause := r.reconnect.PauseGame && len(r.disconnected) == 0
+	go func() {
+		//snapshot first, the client must be in sync before the loops tick again
+		r.game.Reattach(pConn.ID, pConn.ackFrame)
+		if unpause {
+			r.game.Unpause()
+		}
+	}()
+	r.notify("reconnected", pConn.ID)
+}
+
+func (r *Room) issueResumeToken(pConn *PlayerConn) {
+	token, err := GenerateID(24)
+	if err != nil {
+		log.Printf("[ws][room:%s] cannot issue resume token: %v", r.ID, err)
+		return
+	}
+	r.resumeTokens[pConn.ID] = token
+	msg := NewMessage("session")
+	msg.PlayerId = pConn.ID
+	msg.Payload.ResumeToken = token
+	select {
+	case pConn.send <- msg:
+	default:
+	}
+}
+
+// notify the other players in room, called from listenAndServe so it can't go through r.broadcast
+func (r *Room) notify(msgType string, playerId string) {
+	msg := NewMessage(msgType)
+	msg.PlayerId = playerId
+	for id, pConn := range r.PlayerConns {
+		if id == playerId {
+			continue
+		}
+		select {
+		case pConn.send <- msg:
+		default:
+		}
+	}
+}
+
+// reply an error to one player, called from listenAndServe so it can't go through r.broadcast
+func (r *Room) reply(playerId string, msgType string, reason string) {
+	pConn, ok := r.PlayerConns[playerId]
+	if !ok {
+		return
+	}
+	msg := NewMessage(msgType)
+	msg.Error = reason
+	select {
+	case pConn.send <- msg:
+	default:
+	}
+}
+
+func (r *Room) stopIfEmpty() {
+	if len(r.disconnected) > 0 {
+		return
+	}
+	//bots don't keep a room alive, their seats are closed with it
+	for _, pConn := range r.PlayerConns {
+		if pConn == nil || pConn.bot == nil {
 			return
 		}
 	}
+	r.close()
+}
+
+// close must be called from listenAndServe
+func (r *Room) close() {
+	select {
+	case <-r.stop: //already closed
+	default:
+		close(r.stop)
+	}
+}
+
+func (r *Room) Status() RoomStatus {
+	status, _ := r.status.Load().(RoomStatus)
+	return status
+}
+
+func (r *Room) setStatus(status RoomStatus) {
+	if r.status.Swap(status) == status {
+		return
+	}
+	if r.onStatus != nil {
+		r.onStatus(status)
+	}
 }
 
 func GenerateID(n int) (string, error) {
@@ -83,16 +340,28 @@ func GenerateID(n int) (string, error) {
 	}
 	return string(b), nil
 }
-func NewRoom(roomID, key string, close func()) *Room {
-	return &Room{
+func NewRoom(roomID string, key RoomKey, settings RoomSettings, reconnect ReconnectConfig, antiCheat AntiCheatConfig, clock Clock, close func()) *Room {
+	r := &Room{
 		ID:            roomID,
 		Key:           key,
+		Settings:      settings,
 		PlayerConns:   make(map[string]*PlayerConn),
 		join:          make(chan *PlayerConn),
 		leave:         make(chan *PlayerConn),
 		broadcast:     make(chan Packet, 32),
+		ready:         make(chan string),
+		reconnect:     reconnect,
+		resumeTokens:  make(map[string]string),
+		disconnected:  make(map[string]*time.Timer),
+		expire:        make(chan string),
 		stop:          make(chan struct{}),
+		closing:       make(chan struct{}),
 		callbackClose: close,
-		game:          NewGame(),
+		game:          NewGame(settings, antiCheat, clock),
 	}
+	r.clock = r.game.clock
+	r.touch()
+	r.status.Store(RoomWaiting)
+	r.game.onStatus = r.setStatus
+	return r
 }
@@ -0,0 +1,96 @@
+package game
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	roomKeyIterations = 50_000
+	roomKeySaltSize   = 16
+	roomKeyHashSize   = 32
+)
+
+var ErrWrongKey = errors.New("wrong key")
+
+const roomKeyScheme = "pbkdf2-sha256"
+
+// RoomKey is the salted PBKDF2 hash of a room password, the plain key is never stored.
+// Zero value is a public room
+type RoomKey struct {
+	salt []byte
+	hash []byte
+}
+
+func HashRoomKey(key string) (RoomKey, error) {
+	if key == "" {
+		return RoomKey{}, nil
+	}
+	salt := make([]byte, roomKeySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return RoomKey{}, err
+	}
+	hash, err := pbkdf2.Key(sha256.New, key, salt, roomKeyIterations, roomKeyHashSize)
+	if err != nil {
+		return RoomKey{}, err
+	}
+	return RoomKey{salt: salt, hash: hash}, nil
+}
+
+func (k RoomKey) Locked() bool {
+	return len(k.hash) > 0
+}
+
+// Matches compare in constant time, any key is accepted by a public room
+func (k RoomKey) Matches(key string) bool {
+	if !k.Locked() {
+		return true
+	}
+	hash, err := pbkdf2.Key(sha256.New, key, k.salt, roomKeyIterations, roomKeyHashSize)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, k.hash) == 1
+}
+
+// MarshalText "pbkdf2-sha256$iterations$salt$hash" in base64, empty for a public room. Lets a
+// RoomStore keep the hash without ever seeing the key
+func (k RoomKey) MarshalText() ([]byte, error) {
+	if !k.Locked() {
+		return []byte{}, nil
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Appendf(nil, "%s$%d$%s$%s", roomKeyScheme, roomKeyIterations, enc.EncodeToString(k.salt), enc.EncodeToString(k.hash)), nil
+}
+
+func (k *RoomKey) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*k = RoomKey{}
+		return nil
+	}
+	parts := strings.Split(string(text), "$")
+	if len(parts) != 4 || parts[0] != roomKeyScheme {
+		return errors.New("room key: unknown format")
+	}
+	//Matches always hashes with roomKeyIterations
+	if n, err := strconv.Atoi(parts[1]); err != nil || n != roomKeyIterations {
+		return fmt.Errorf("room key: unsupported iterations %q", parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("room key salt: %w", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return fmt.Errorf("room key hash: %w", err)
+	}
+	*k = RoomKey{salt: salt, hash: hash}
+	return nil
+}
@@ -1,81 +1,195 @@
 package game
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"sync"
+	"time"
 )
 
 type RoomManager interface {
 	Get(roomID string) (*Room, error)
-	GetAllDTO() ([]RoomDTO, error)
-	CreateRoom(key string) (RoomDTO, error)
+	GetAllDTO(filter RoomFilter) ([]RoomDTO, error)
+	CreateRoom(owner string, key string, settings RoomSettings) (RoomDTO, error)
 	CreateMockRoom(id string) error
 	JoinRoom(roomID string, key string) (RoomDTO, error)
 	AddPlayer(pConn *PlayerConn)
+	AddBot(roomID string, key string, cfg BotConfig) (RoomDTO, error)
+	OnResult(fn func(MatchResult))
 }
+
+// InMemoryRoomManager live rooms are kept in memory, their metadata in Store. A room whose
+// goroutine is gone (a restart) is rehydrated from Store the next time someone asks for it
 type InMemoryRoomManager struct {
-	Rooms map[string]*Room
-	mu    sync.RWMutex
+	Rooms     map[string]*Room
+	Store     RoomStore
+	Reconnect ReconnectConfig
+	AntiCheat AntiCheatConfig
+	Clock     Clock // nil is the wall clock
+	results   func(MatchResult)
+	mu        sync.RWMutex
 }
 
 type RoomDTO struct {
-	ID      string      `json:"ID"`
-	Players []PlayerDTO `json:"players,omitempty"`
-	key     string
+	ID        string       `json:"ID"`
+	Players   []PlayerDTO  `json:"players,omitempty"`
+	Locked    bool         `json:"locked"`
+	Settings  RoomSettings `json:"settings"`
+	Owner     string       `json:"owner,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Status    RoomStatus   `json:"status,omitempty"`
 }
 type PlayerDTO struct {
 	ID string `json:"ID"`
 }
 
-func (r Room) ToDTO() RoomDTO {
+// ToDTO the room goroutine changes the room meanwhile, players and status are read through Players and Status
+func (r *Room) ToDTO() RoomDTO {
 	dto := RoomDTO{
-		ID: r.ID,
+		ID:        r.ID,
+		Locked:    r.Key.Locked(),
+		Settings:  r.Settings,
+		Owner:     r.Owner,
+		CreatedAt: r.CreatedAt,
+		Status:    r.Status(),
 	}
 
-	for _, pConn := range r.PlayerConns {
-		dto.Players = append(dto.Players, PlayerDTO{ID: pConn.ID})
+	for _, id := range r.Players() {
+		dto.Players = append(dto.Players, PlayerDTO{ID: id})
 	}
 
 	return dto
 }
-func (i *InMemoryRoomManager) GetAllDTO() ([]RoomDTO, error) {
+
+// GetAllDTO rooms of the store, the players are only known for the live ones
+func (i *InMemoryRoomManager) GetAllDTO(filter RoomFilter) ([]RoomDTO, error) {
+	recs, err := i.Store.List(filter)
+	if err != nil {
+		return nil, err
+	}
 	i.mu.RLock()
 	defer i.mu.RUnlock()
-	rooms := make([]RoomDTO, 0, len(i.Rooms))
-	for _, r := range i.Rooms {
-		rooms = append(rooms, r.ToDTO())
+	rooms := make([]RoomDTO, 0, len(recs))
+	for _, rec := range recs {
+		if r, ok := i.Rooms[rec.ID]; ok {
+			rooms = append(rooms, r.ToDTO())
+			continue
+		}
+		rooms = append(rooms, RoomDTO{
+			ID:        rec.ID,
+			Locked:    rec.Key.Locked(),
+			Settings:  rec.Settings,
+			Owner:     rec.Owner,
+			CreatedAt: rec.CreatedAt,
+			Status:    rec.Status,
+		})
 	}
 	return rooms, nil
 }
 func (i *InMemoryRoomManager) Get(roomID string) (*Room, error) {
 	i.mu.RLock()
-	defer i.mu.RUnlock()
-	if !i.exists(roomID) {
-		return nil, fmt.Errorf("not found")
+	room, ok := i.Rooms[roomID]
+	i.mu.RUnlock()
+	if ok {
+		return room, nil
 	}
-	return i.Rooms[roomID], nil
+	return i.rehydrate(roomID)
 }
-func (i *InMemoryRoomManager) exists(roomID string) bool {
+
+// rehydrate start the goroutine of a stored room again, its matches are lost
+func (i *InMemoryRoomManager) rehydrate(roomID string) (*Room, error) {
+	i.mu.Lock()
+	if room, ok := i.Rooms[roomID]; ok { //someone else was faster
+		i.mu.Unlock()
+		return room, nil
+	}
+	//read under the lock, the janitor closes stored rooms holding it too
+	rec, err := i.Store.Get(roomID)
+	if err == nil && rec.Status == RoomClosed {
+		err = ErrRoomNotFound
+	}
+	if err != nil {
+		i.mu.Unlock()
+		return nil, err
+	}
+	room := i.newRoom(rec)
+	i.mu.Unlock()
+	log.Printf("[room:%s] rehydrated from the store", roomID)
+	if rec.Status != RoomWaiting {
+		room.onStatus(RoomWaiting)
+	}
+	go room.listenAndServe()
+	return room, nil
+}
+
+// newRoom build the live room of rec and track it, mutex lock ở nơi gọi
+func (i *InMemoryRoomManager) newRoom(rec RoomRecord) *Room {
+	//closed in the store by the room itself, see Room.shutdown
+	closeRoom := func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		delete(i.Rooms, rec.ID)
+	}
+	room := NewRoom(rec.ID, rec.Key, rec.Settings, i.Reconnect, i.AntiCheat, i.Clock, closeRoom)
+	room.Owner = rec.Owner
+	room.CreatedAt = rec.CreatedAt
+	room.onStatus = func(status RoomStatus) {
+		if err := i.Store.SetStatus(rec.ID, status); err != nil {
+			log.Printf("[room:%s] cannot save status %s: %v", rec.ID, status, err)
+		}
+	}
+	room.game.onResult = i.report(rec.ID)
+	i.Rooms[rec.ID] = room
+	return room
+}
+
+func (i *InMemoryRoomManager) exists(roomID string) (bool, error) {
 	//mutex lock ở nơi gọi. ko nên lock ở đây nữa
-	_, ok := i.Rooms[roomID]
-	return ok
+	if _, ok := i.Rooms[roomID]; ok {
+		return true, nil
+	}
+	_, err := i.Store.Get(roomID)
+	if errors.Is(err, ErrRoomNotFound) {
+		return false, nil
+	}
+	return err == nil, err
 }
-func (i *InMemoryRoomManager) CreateRoom(key string) (RoomDTO, error) {
+func (i *InMemoryRoomManager) CreateRoom(owner string, key string, settings RoomSettings) (RoomDTO, error) {
+	//hash outside the lock, pbkdf2 is slow on purpose
+	roomKey, err := HashRoomKey(key)
+	if err != nil {
+		return RoomDTO{}, err
+	}
 	i.mu.Lock()
 
 	for tries := 0; tries <= 5; tries++ {
 		roomID, err := GenerateID(5)
 		if err != nil {
+			i.mu.Unlock()
+			return RoomDTO{}, err
+		}
+		found, err := i.exists(roomID)
+		if err != nil {
+			i.mu.Unlock()
 			return RoomDTO{}, err
 		}
-		if !i.exists(roomID) {
-			closeRoom := func() {
-				i.mu.Lock()
-				defer i.mu.Unlock()
-				delete(i.Rooms, roomID)
+		if !found {
+			rec := RoomRecord{
+				ID:        roomID,
+				Settings:  settings,
+				Key:       roomKey,
+				Owner:     owner,
+				CreatedAt: i.now(),
+				Status:    RoomWaiting,
 			}
-			room := NewRoom(roomID, key, closeRoom)
-			i.Rooms[roomID] = room
+			if err := i.Store.Save(rec); err != nil {
+				i.mu.Unlock()
+				return RoomDTO{}, err
+			}
+			room := i.newRoom(rec)
 
 			i.mu.Unlock()
 			//unlock before listenAndServe listener goroutine
@@ -85,22 +199,23 @@ func (i *InMemoryRoomManager) CreateRoom(key string) (RoomDTO, error) {
 		}
 
 	}
+	i.mu.Unlock()
 	return RoomDTO{}, fmt.Errorf("server is busy")
 
 }
 
 func (i *InMemoryRoomManager) CreateMockRoom(id string) error {
+	i.mu.Lock()
 	if _, ok := i.Rooms[id]; ok {
+		i.mu.Unlock()
 		return nil
 	}
-	i.mu.Lock()
-	closeRoom := func() {
-		i.mu.Lock()
-		defer i.mu.Unlock()
-		delete(i.Rooms, id)
+	rec := RoomRecord{ID: id, Settings: DefaultRoomSettings(), CreatedAt: i.now(), Status: RoomWaiting}
+	if err := i.Store.Save(rec); err != nil {
+		i.mu.Unlock()
+		return err
 	}
-	room := NewRoom(id, "", closeRoom)
-	i.Rooms[id] = room
+	room := i.newRoom(rec)
 
 	i.mu.Unlock()
 	//unlock before listenAndServe listener goroutine
@@ -109,32 +224,155 @@ func (i *InMemoryRoomManager) CreateMockRoom(id string) error {
 	//return RoomDTO{}, fmt.Errorf("server is busy")
 
 }
+
+func (i *InMemoryRoomManager) now() time.Time {
+	if i.Clock == nil {
+		return time.Now()
+	}
+	return i.Clock.Now()
+}
 func (i *InMemoryRoomManager) AddPlayer(pConn *PlayerConn) {
 	//join thông qua send vào goroutine room.listenAndServe()
 	room := pConn.r
-	room.join <- pConn
-
+	select {
+	case room.join <- pConn:
+	case <-room.stop:
+		//closed in between, no room goroutine owns the seat: Write ends, then Read
+		pConn.closeSend()
+	}
 }
 
 func (i *InMemoryRoomManager) JoinRoom(roomID string, key string) (RoomDTO, error) {
-	i.mu.RLock()
-	defer i.mu.RUnlock()
-	if !i.exists(roomID) {
-		return RoomDTO{}, fmt.Errorf("not found")
+	room, err := i.Get(roomID)
+	if err != nil {
+		return RoomDTO{}, err
 	}
-	room := i.Rooms[roomID]
-	if len(room.PlayerConns) >= 2 {
+	//check key before capacity so a full room doesn't tell a stranger anything
+	if !room.Key.Matches(key) {
+		return RoomDTO{}, ErrWrongKey
+	}
+	if len(room.Players()) >= room.Settings.Capacity {
 		return RoomDTO{}, fmt.Errorf("room is full")
 	}
-	//check key
-	//if key!=room.Key{
-	//	...
-	//}
-	return i.Rooms[roomID].ToDTO(), nil
+	return room.ToDTO(), nil
+}
+
+// AddBot seat a bot played by the server, the key is checked like for a player joining
+func (i *InMemoryRoomManager) AddBot(roomID string, key string, cfg BotConfig) (RoomDTO, error) {
+	dto, err := i.JoinRoom(roomID, key)
+	if err != nil {
+		return RoomDTO{}, err
+	}
+	room, err := i.Get(roomID)
+	if err != nil {
+		return RoomDTO{}, err
+	}
+	id, err := GenerateID(4)
+	if err != nil {
+		return RoomDTO{}, err
+	}
+	bot := NewBotConn("bot-"+id, room, cfg)
+	select {
+	case room.join <- bot:
+	case <-room.stop:
+		return RoomDTO{}, fmt.Errorf("not found")
+	}
+	go bot.Play()
+	//it may not be seated yet
+	dto.Players = append(dto.Players, PlayerDTO{ID: bot.ID})
+	return dto, nil
+}
+
+// OnResult fn is called with every match that ends with a winner, from the goroutine that ended it
+func (i *InMemoryRoomManager) OnResult(fn func(MatchResult)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.results = fn
+}
+
+func (i *InMemoryRoomManager) report(roomID string) func(MatchResult) {
+	return func(res MatchResult) {
+		i.mu.RLock()
+		fn := i.results
+		i.mu.RUnlock()
+		if fn != nil {
+			res.RoomID = roomID
+			fn(res)
+		}
+	}
+}
+
+// Reap close the live rooms nobody used for ttl, their loops are stopped with them. Stored rooms
+// older than ttl with no live room are closed too, e.g. left playing by a restart
+func (i *InMemoryRoomManager) Reap(ttl time.Duration) int {
+	i.mu.RLock()
+	var idle []*Room
+	for _, room := range i.Rooms {
+		if room.IdleFor() > ttl {
+			idle = append(idle, room)
+		}
+	}
+	i.mu.RUnlock()
+	for _, room := range idle {
+		log.Printf("[room:%s] idle for %v, closing", room.ID, room.IdleFor().Round(time.Second))
+		room.Close()
+	}
+	return len(idle) + i.reapStored(ttl, idle)
+}
+
+// reapStored skip the rooms just closed, they may be gone from Rooms before their record says closed
+func (i *InMemoryRoomManager) reapStored(ttl time.Duration, closing []*Room) int {
+	recs, err := i.Store.List(RoomFilter{})
+	if err != nil {
+		log.Printf("[janitor] cannot list rooms: %v", err)
+		return 0
+	}
+	//rehydrate can't start one of them in between
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	reaped := 0
+	for _, rec := range recs {
+		_, live := i.Rooms[rec.ID]
+		if live || i.now().Sub(rec.CreatedAt) <= ttl || slices.ContainsFunc(closing, func(r *Room) bool { return r.ID == rec.ID }) {
+			continue
+		}
+		err := i.Store.SetStatus(rec.ID, RoomClosed)
+		if errors.Is(err, ErrRoomNotFound) { //its room closed it meanwhile, a memory store drops it
+			continue
+		}
+		if err != nil {
+			log.Printf("[room:%s] cannot close stored room: %v", rec.ID, err)
+			continue
+		}
+		log.Printf("[room:%s] %s in the store with no live room, closing", rec.ID, rec.Status)
+		reaped++
+	}
+	return reaped
+}
+
+// RunJanitor reap idle rooms until ctx is done
+func (i *InMemoryRoomManager) RunJanitor(ctx context.Context, ttl time.Duration) {
+	clock := i.Clock
+	if clock == nil {
+		clock = WallClock
+	}
+	ticker := clock.NewTicker(max(ttl/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			i.Reap(ttl)
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func NewInMemoryRoomManager() *InMemoryRoomManager {
 	return &InMemoryRoomManager{
-		Rooms: make(map[string]*Room),
+		Rooms:     make(map[string]*Room),
+		Store:     NewMemoryRoomStore(),
+		Reconnect: DefaultReconnectConfig,
+		AntiCheat: DefaultAntiCheatConfig,
 	}
 }
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrRoomNotFound = errors.New("not found")
+
+// RoomStatus waiting -> countdown -> playing -> finished, then countdown again on a rematch.
+// Any of them -> closed when the room goroutine exits
+type RoomStatus string
+
+const (
+	RoomWaiting   RoomStatus = "waiting"   // for players, or for "ready"
+	RoomCountdown RoomStatus = "countdown" // "start" sent, the loops wait for startAt
+	RoomPlaying   RoomStatus = "playing"
+	RoomFinished  RoomStatus = "finished"
+	RoomClosed    RoomStatus = "closed"
+)
+
+var RoomStatuses = []RoomStatus{RoomWaiting, RoomCountdown, RoomPlaying, RoomFinished, RoomClosed}
+
+// RoomRecord what is kept of a room across restarts, the live Room is rebuilt from it
+type RoomRecord struct {
+	ID        string       `json:"ID"`
+	Settings  RoomSettings `json:"settings"`
+	Key       RoomKey      `json:"key"` // the hash, see RoomKey.MarshalText
+	Owner     string       `json:"owner,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Status    RoomStatus   `json:"status"`
+}
+
+// RoomFilter zero value lists every room still open
+type RoomFilter struct {
+	Status []RoomStatus // any of them, every status but closed if empty
+	Mode   string
+	Locked *bool
+	Owner  string
+}
+
+func (f RoomFilter) Matches(rec RoomRecord) bool {
+	if len(f.Status) == 0 && rec.Status == RoomClosed {
+		return false
+	}
+	if len(f.Status) > 0 && !slices.Contains(f.Status, rec.Status) {
+		return false
+	}
+	if f.Mode != "" && rec.Settings.Mode != f.Mode {
+		return false
+	}
+	if f.Locked != nil && rec.Key.Locked() != *f.Locked {
+		return false
+	}
+	return f.Owner == "" || rec.Owner == f.Owner
+}
+
+// RoomStore keep room metadata, a room whose goroutine is gone can be rehydrated from it
+type RoomStore interface {
+	Get(roomID string) (RoomRecord, error) // ErrRoomNotFound
+	Save(rec RoomRecord) error
+	SetStatus(roomID string, status RoomStatus) error
+	List(filter RoomFilter) ([]RoomRecord, error) // oldest first
+}
+
+// ResetRooms a restart lost every match, rooms left open are waiting again
+func ResetRooms(store RoomStore) error {
+	recs, err := store.List(RoomFilter{Status: []RoomStatus{RoomCountdown, RoomPlaying}})
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := store.SetStatus(rec.ID, RoomWaiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryRoomStore forget a room once it is closed, nothing outlives the process to rehydrate it
+type MemoryRoomStore struct {
+	mu    sync.RWMutex
+	rooms map[string]RoomRecord
+}
+
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{rooms: make(map[string]RoomRecord)}
+}
+
+func (m *MemoryRoomStore) Get(roomID string) (RoomRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rec, ok := m.rooms[roomID]
+	if !ok {
+		return RoomRecord{}, ErrRoomNotFound
+	}
+	return rec, nil
+}
+
+func (m *MemoryRoomStore) Save(rec RoomRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec.Status == RoomClosed {
+		delete(m.rooms, rec.ID)
+		return nil
+	}
+	m.rooms[rec.ID] = rec
+	return nil
+}
+
+func (m *MemoryRoomStore) SetStatus(roomID string, status RoomStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if status == RoomClosed {
+		delete(m.rooms, roomID)
+		return nil
+	}
+	rec.Status = status
+	m.rooms[roomID] = rec
+	return nil
+}
+
+func (m *MemoryRoomStore) List(filter RoomFilter) ([]RoomRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	recs := make([]RoomRecord, 0, len(m.rooms))
+	for _, rec := range m.rooms {
+		if filter.Matches(rec) {
+			recs = append(recs, rec)
+		}
+	}
+	sortRooms(recs)
+	return recs, nil
+}
+
+func sortRooms(recs []RoomRecord) {
+	slices.SortFunc(recs, func(a, b RoomRecord) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+}
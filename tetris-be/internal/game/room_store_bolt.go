@@ -0,0 +1,100 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var roomsBucket = []byte("rooms")
+
+// BoltRoomStore rooms in a bbolt file, one JSON record per room ID. The file is locked by
+// one server at a time
+type BoltRoomStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltRoomStore(path string) (*BoltRoomStore, error) {
+	//a second server on the same file waits for the lock, give up instead
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("rooms db %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(roomsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltRoomStore{db: db}, nil
+}
+
+func (b *BoltRoomStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltRoomStore) Get(roomID string) (RoomRecord, error) {
+	var rec RoomRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(roomsBucket).Get([]byte(roomID))
+		if data == nil {
+			return ErrRoomNotFound
+		}
+		return json.Unmarshal(data, &rec)
+	})
+	return rec, err
+}
+
+func (b *BoltRoomStore) Save(rec RoomRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).Put([]byte(rec.ID), data)
+	})
+}
+
+func (b *BoltRoomStore) SetStatus(roomID string, status RoomStatus) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(roomsBucket)
+		data := bucket.Get([]byte(roomID))
+		if data == nil {
+			return ErrRoomNotFound
+		}
+		var rec RoomRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		rec.Status = status
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(roomID), data)
+	})
+}
+
+func (b *BoltRoomStore) List(filter RoomFilter) ([]RoomRecord, error) {
+	var recs []RoomRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).ForEach(func(k, data []byte) error {
+			var rec RoomRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return fmt.Errorf("room %s: %w", k, err)
+			}
+			if filter.Matches(rec) {
+				recs = append(recs, rec)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRooms(recs)
+	return recs, nil
+}
@@ -0,0 +1,209 @@
+package game
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoomKeyText(t *testing.T) {
+	key, err := HashRoomKey("secret")
+	assertNoErr(t, err)
+	text, err := key.MarshalText()
+	assertNoErr(t, err)
+	assert.NotContains(t, string(text), "secret")
+
+	var back RoomKey
+	assertNoErr(t, back.UnmarshalText(text))
+	assert.True(t, back.Matches("secret"))
+	assert.False(t, back.Matches("guess"))
+
+	public, err := RoomKey{}.MarshalText()
+	assertNoErr(t, err)
+	assert.Empty(t, public)
+	assertNoErr(t, back.UnmarshalText(public))
+	assert.False(t, back.Locked())
+
+	for _, bad := range []string{"plain", "pbkdf2-sha256$1000$AAAA$AAAA", "pbkdf2-sha256$50000$!!$AAAA"} {
+		assert.Error(t, back.UnmarshalText([]byte(bad)), bad)
+	}
+}
+
+func TestRoomStore(t *testing.T) {
+	key, err := HashRoomKey("k")
+	assertNoErr(t, err)
+	solo := DefaultRoomSettings()
+	solo.Mode, solo.Capacity = ModeSolo, 1
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	recs := []RoomRecord{
+		{ID: "AAAAA", Settings: DefaultRoomSettings(), Key: key, Owner: "alice", CreatedAt: at, Status: RoomWaiting},
+		{ID: "BBBBB", Settings: solo, Owner: "bob", CreatedAt: at.Add(time.Minute), Status: RoomPlaying},
+		{ID: "CCCCC", Settings: DefaultRoomSettings(), CreatedAt: at.Add(2 * time.Minute), Status: RoomClosed},
+	}
+	ids := func(recs []RoomRecord) []string {
+		var ids []string
+		for _, rec := range recs {
+			ids = append(ids, rec.ID)
+		}
+		return ids
+	}
+	locked := true
+	stores := map[string]func(t *testing.T) RoomStore{
+		"memory": func(t *testing.T) RoomStore { return NewMemoryRoomStore() },
+		"bolt": func(t *testing.T) RoomStore {
+			store, err := OpenBoltRoomStore(filepath.Join(t.TempDir(), "rooms.db"))
+			assertNoErr(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			for _, rec := range recs {
+				assertNoErr(t, store.Save(rec))
+			}
+			got, err := store.Get("AAAAA")
+			assertNoErr(t, err)
+			assert.Equal(t, "alice", got.Owner)
+			assert.True(t, got.Key.Matches("k"))
+			_, err = store.Get("ZZZZZ")
+			assert.ErrorIs(t, err, ErrRoomNotFound)
+
+			list, err := store.List(RoomFilter{})
+			assertNoErr(t, err)
+			assert.Equal(t, []string{"AAAAA", "BBBBB"}, ids(list), "closed rooms are left out")
+			list, err = store.List(RoomFilter{Status: []RoomStatus{RoomClosed, RoomPlaying}})
+			assertNoErr(t, err)
+			if name == "memory" {
+				assert.Equal(t, []string{"BBBBB"}, ids(list), "closed rooms are not kept in memory")
+			} else {
+				assert.Equal(t, []string{"BBBBB", "CCCCC"}, ids(list))
+			}
+			list, err = store.List(RoomFilter{Mode: ModeSolo})
+			assertNoErr(t, err)
+			assert.Equal(t, []string{"BBBBB"}, ids(list))
+			list, err = store.List(RoomFilter{Locked: &locked})
+			assertNoErr(t, err)
+			assert.Equal(t, []string{"AAAAA"}, ids(list))
+			list, err = store.List(RoomFilter{Owner: "bob"})
+			assertNoErr(t, err)
+			assert.Equal(t, []string{"BBBBB"}, ids(list))
+
+			assertNoErr(t, ResetRooms(store))
+			got, err = store.Get("BBBBB")
+			assertNoErr(t, err)
+			assert.Equal(t, RoomWaiting, got.Status)
+			assert.ErrorIs(t, store.SetStatus("ZZZZZ", RoomClosed), ErrRoomNotFound)
+		})
+	}
+	t.Run("memory forgets a room once it is closed", func(t *testing.T) {
+		store := NewMemoryRoomStore()
+		assertNoErr(t, store.Save(recs[0]))
+		assertNoErr(t, store.SetStatus("AAAAA", RoomClosed))
+		_, err := store.Get("AAAAA")
+		assert.ErrorIs(t, err, ErrRoomNotFound)
+		assert.Empty(t, store.rooms)
+	})
+	t.Run("bolt keeps the rooms across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rooms.db")
+		store, err := OpenBoltRoomStore(path)
+		assertNoErr(t, err)
+		assertNoErr(t, store.Save(recs[0]))
+		assertNoErr(t, store.Close())
+
+		store, err = OpenBoltRoomStore(path)
+		assertNoErr(t, err)
+		defer store.Close()
+		got, err := store.Get("AAAAA")
+		assertNoErr(t, err)
+		assert.True(t, got.CreatedAt.Equal(at))
+		assert.True(t, got.Key.Matches("k"))
+	})
+}
+
+func TestRoomRehydration(t *testing.T) {
+	store := NewMemoryRoomStore()
+	before := NewInMemoryRoomManager()
+	before.Store = store
+	before.Reconnect = ReconnectConfig{}
+	created, err := before.CreateRoom("alice", "k", DefaultRoomSettings())
+	assertNoErr(t, err)
+	assert.Equal(t, RoomWaiting, created.Status)
+	assert.Equal(t, "alice", created.Owner)
+	assertNoErr(t, store.SetStatus(created.ID, RoomPlaying))
+
+	//a new process: nothing live, the same store
+	after := NewInMemoryRoomManager()
+	after.Store = store
+	listed, err := after.GetAllDTO(RoomFilter{})
+	assertNoErr(t, err)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, created.ID, listed[0].ID)
+		assert.True(t, listed[0].Locked)
+	}
+	assert.Empty(t, after.Rooms, "listing doesn't start rooms")
+
+	_, err = after.JoinRoom(created.ID, "wrong")
+	assert.ErrorIs(t, err, ErrWrongKey)
+	joined, err := after.JoinRoom(created.ID, "k")
+	assertNoErr(t, err)
+	assert.Equal(t, "alice", joined.Owner)
+	assert.Contains(t, after.Rooms, created.ID)
+	rec, err := store.Get(created.ID)
+	assertNoErr(t, err)
+	assert.Equal(t, RoomWaiting, rec.Status, "its match did not survive")
+
+	t.Run("closed rooms stay closed", func(t *testing.T) {
+		room := after.Rooms[created.ID]
+		close(room.stop)
+		//the memory store drops it once closed
+		assert.Eventually(t, func() bool {
+			_, err := store.Get(created.ID)
+			return errors.Is(err, ErrRoomNotFound)
+		}, time.Second, 10*time.Millisecond)
+		_, err := after.Get(created.ID)
+		assert.ErrorIs(t, err, ErrRoomNotFound)
+		listed, err := after.GetAllDTO(RoomFilter{})
+		assertNoErr(t, err)
+		assert.Empty(t, listed)
+	})
+}
+
+func TestReapStoredRooms(t *testing.T) {
+	store, err := OpenBoltRoomStore(filepath.Join(t.TempDir(), "rooms.db"))
+	assertNoErr(t, err)
+	defer store.Close()
+	clock := NewManualClock(time.Now())
+	rooms := NewInMemoryRoomManager()
+	rooms.Store = store
+	rooms.Clock = clock
+
+	//left by the previous process, nothing live owns them
+	assertNoErr(t, store.Save(RoomRecord{ID: "STALE", Settings: DefaultRoomSettings(), CreatedAt: clock.Now().Add(-2 * time.Minute), Status: RoomPlaying}))
+	assertNoErr(t, store.Save(RoomRecord{ID: "FRESH", Settings: DefaultRoomSettings(), CreatedAt: clock.Now(), Status: RoomCountdown}))
+	live, err := rooms.CreateRoom("alice", "", DefaultRoomSettings())
+	assertNoErr(t, err)
+
+	assert.Equal(t, 1, rooms.Reap(time.Minute))
+	rec, err := store.Get("STALE")
+	assertNoErr(t, err)
+	assert.Equal(t, RoomClosed, rec.Status)
+	_, err = rooms.JoinRoom("STALE", "")
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+	rec, err = store.Get("FRESH")
+	assertNoErr(t, err)
+	assert.Equal(t, RoomCountdown, rec.Status)
+	assert.Contains(t, rooms.Rooms, live.ID)
+
+	t.Run("a live room is closed once", func(t *testing.T) {
+		clock.Advance(2 * time.Minute)
+		assert.Equal(t, 2, rooms.Reap(time.Minute), "FRESH from the store, the live one by its goroutine")
+		assert.Eventually(t, func() bool {
+			rec, err := store.Get(live.ID)
+			return err == nil && rec.Status == RoomClosed
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,91 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"tetris-be/internal/validator"
+)
+
+const (
+	ModeVersus = "versus"
+	ModeSolo   = "solo"
+
+	Randomizer7Bag    = "7bag"
+	RandomizerClassic = "classic"
+
+	AttackDefault   = "default"
+	AttackGuideline = "guideline"
+)
+
+var modeCapacity = map[string]int{
+	ModeVersus: 2,
+	ModeSolo:   1,
+}
+
+// RoomSettings is chosen by the room creator and fixed for every game played in the room
+type RoomSettings struct {
+	Mode         string    `json:"mode"`
+	Capacity     int       `json:"capacity"`
+	Gravity      float64   `json:"gravity"`      // ms per cell
+	LockDelay    float64   `json:"lockDelay"`    // ms
+	GarbageDelay int       `json:"garbageDelay"` // frames between attack sent and garbage received
+	GarbageCap   int       `json:"garbageCap"`   // garbage lines rising per piece locked
+	Randomizer   string    `json:"randomizer"`
+	AttackTable  string    `json:"attackTable"`
+	Board        BoardSize `json:"board"`
+	SeriesLength int       `json:"seriesLength"` // first to N wins
+	Ranked       bool      `json:"ranked"`       // results change ratings, only matchmaking makes ranked rooms
+}
+
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		Mode:         ModeVersus,
+		Capacity:     modeCapacity[ModeVersus],
+		Gravity:      DROPSPEED,
+		LockDelay:    LOCKDELAY,
+		GarbageDelay: INCOMING,
+		GarbageCap:   GARBAGE_CAP,
+		Randomizer:   Randomizer7Bag,
+		AttackTable:  AttackDefault,
+		Board:        DefaultBoardSize,
+		SeriesLength: 1,
+	}
+}
+
+// UnmarshalJSON start from defaults so a client only sends the fields it wants to change
+func (s *RoomSettings) UnmarshalJSON(data []byte) error {
+	type plain RoomSettings
+	p := plain(DefaultRoomSettings())
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*s = RoomSettings(p)
+	return nil
+}
+
+func ValidateRoomSettings(v *validator.Validator, s RoomSettings) {
+	v.Check(validator.In(s.Mode, ModeVersus, ModeSolo), "settings.mode", "must be versus or solo")
+	v.Check(s.Capacity == modeCapacity[s.Mode], "settings.capacity", fmt.Sprintf("must be %d for this mode", modeCapacity[s.Mode]))
+	v.Check(s.Gravity >= SOFT_DROP && s.Gravity <= 5000, "settings.gravity", fmt.Sprintf("must be between %d and 5000 ms", SOFT_DROP))
+	v.Check(s.LockDelay >= 0 && s.LockDelay <= 5000, "settings.lockDelay", "must be between 0 and 5000 ms")
+	//attacks leave only after the rollback window, they must still arrive in the future
+	v.Check(s.GarbageDelay > ROLLBACK_WINDOW && s.GarbageDelay <= 10*TICK, "settings.garbageDelay",
+		fmt.Sprintf("must be between %d and %d frames", ROLLBACK_WINDOW+1, 10*TICK))
+	v.Check(s.GarbageCap >= 1 && s.GarbageCap <= 40, "settings.garbageCap", "must be between 1 and 40 lines")
+	v.Check(validator.In(s.Randomizer, Randomizer7Bag, RandomizerClassic), "settings.randomizer", "must be 7bag or classic")
+	_, ok := AttackTables[s.AttackTable]
+	v.Check(ok, "settings.attackTable", "unknown attack table")
+	v.Check(s.Board.Width >= 4 && s.Board.Width <= 16, "settings.board.width", "must be between 4 and 16")
+	v.Check(s.Board.Height >= 8 && s.Board.Height <= 40, "settings.board.height", "must be between 8 and 40")
+	v.Check(s.Board.Hidden >= 0 && s.Board.Hidden <= 4, "settings.board.hidden", "must be between 0 and 4")
+	v.Check(s.SeriesLength >= 1 && s.SeriesLength <= 7, "settings.seriesLength", "must be between 1 and 7")
+	v.Check(!s.Ranked, "settings.ranked", "only matchmaking rooms are ranked")
+}
+
+// GenerateList fill the piece list with the room's randomizer
+func (s RoomSettings) GenerateList(list []int, n int) []int {
+	if s.Randomizer == RandomizerClassic {
+		return GenerateList_Classic(list, n)
+	}
+	return GenerateList_7bag(list, n)
+}
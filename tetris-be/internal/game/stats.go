@@ -0,0 +1,140 @@
+package game
+
+import "sync"
+
+const SprintLines = 40 // lines of a solo sprint, the frame they are cleared on is the sprint time
+
+// Stats what a player did in a match, counted on final frames only so a rollback never counts
+// a piece twice. The last ROLLBACK_WINDOW frames of a match are left out
+type Stats struct {
+	Frames         int    `json:"frames"`
+	Pieces         int    `json:"pieces"`
+	Lines          int    `json:"lines"`
+	Attack         int    `json:"attack"`   // garbage lines sent, after canceling
+	Received       int    `json:"received"` // garbage lines that rose on the board
+	GarbageCleared int    `json:"garbageCleared"`
+	MaxCombo       int    `json:"maxCombo"` // clears in a row after the first one
+	MaxB2B         int    `json:"maxB2B"`   // tetrises and T-spin clears in a row after the first one
+	TSpins         TSpins `json:"tSpins"`
+	Finesse        int    `json:"finesse"` // inputs wasted over the fewest that reach the same spot
+	SprintFrames   int    `json:"sprintFrames,omitempty"`
+
+	combo, b2b int // current chains, -1 when broken
+}
+
+// TSpins by lines cleared, a mini counts in Mini whatever it cleared
+type TSpins struct {
+	Zero   int `json:"zero"`
+	Single int `json:"single"`
+	Double int `json:"double"`
+	Triple int `json:"triple"`
+	Mini   int `json:"mini"`
+}
+
+// StatsDTO what the "stats" message carries, the rates are derived from the counts
+type StatsDTO struct {
+	Stats
+	PPS float64 `json:"pps"`
+	APM float64 `json:"apm"`
+	VS  float64 `json:"vs"`
+}
+
+func (s Stats) ToDTO() StatsDTO {
+	s.combo, s.b2b = 0, 0
+	return StatsDTO{Stats: s, PPS: s.PPS(), APM: s.APM(), VS: s.VS()}
+}
+
+// PPS pieces per second
+func (s Stats) PPS() float64 {
+	if s.Frames == 0 {
+		return 0
+	}
+	return float64(s.Pieces) * TICK / float64(s.Frames)
+}
+
+// APM attack per minute
+func (s Stats) APM() float64 {
+	if s.Frames == 0 {
+		return 0
+	}
+	return float64(s.Attack) * 60 * TICK / float64(s.Frames)
+}
+
+// VS attack plus garbage cleared, per 100 seconds
+func (s Stats) VS() float64 {
+	if s.Frames == 0 {
+		return 0
+	}
+	return float64(s.Attack+s.GarbageCleared) * 100 * TICK / float64(s.Frames)
+}
+
+// statsTally read by the match once the loops stopped, but a loop may still be finishing its frame
+type statsTally struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func newStatsTally() statsTally {
+	return statsTally{stats: Stats{combo: -1, b2b: -1}}
+}
+
+// count a final frame, called from flushAttacks
+func (t *statsTally) count(frame int, events frameEvents, mode string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &t.stats
+	s.Frames = frame
+	s.Attack += events.attack
+	s.Received += events.landed
+	if !events.locked {
+		return
+	}
+	s.Pieces++
+	s.Lines += events.cleared
+	s.GarbageCleared += events.garbage
+	s.Finesse += max(events.finesse, 0)
+	switch {
+	case events.tSpin == "mini":
+		s.TSpins.Mini++
+	case events.tSpin == "full" && events.cleared == 0:
+		s.TSpins.Zero++
+	case events.tSpin == "full" && events.cleared == 1:
+		s.TSpins.Single++
+	case events.tSpin == "full" && events.cleared == 2:
+		s.TSpins.Double++
+	case events.tSpin == "full":
+		s.TSpins.Triple++
+	}
+	if events.cleared == 0 {
+		s.combo = -1
+	} else {
+		s.combo++
+		s.MaxCombo = max(s.MaxCombo, s.combo)
+		if events.cleared == 4 || events.tSpin != "" {
+			s.b2b++
+			s.MaxB2B = max(s.MaxB2B, s.b2b)
+		} else {
+			s.b2b = -1
+		}
+	}
+	if mode == ModeSolo && s.SprintFrames == 0 && s.Lines >= SprintLines {
+		s.SprintFrames = frame
+	}
+}
+
+func (t *statsTally) get() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// sendStats to everyone in the room, PlayerId is whose stats they are
+func (exec *FrameExecutor) sendStats(broadcast chan Packet) {
+	dto := exec.stats.get().ToDTO()
+	msg := NewMessage("stats")
+	msg.PlayerId = exec.playerId
+	msg.Payload.Stats = &dto
+	var packet Packet
+	packet.msg = msg
+	broadcast <- packet
+}
@@ -0,0 +1,143 @@
+package game
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinesse(t *testing.T) {
+	settings := DefaultRoomSettings()
+	spawn := func(id int) int { return settings.Board.SpawnCol(Tetromino[id].shape) }
+	t.Run("fewest inputs to a spot", func(t *testing.T) {
+		tShape, iShape, oShape := Tetromino[3].shape, Tetromino[1].shape, Tetromino[2].shape
+		cases := []struct {
+			name  string
+			id    int
+			shape [][]int
+			col   int
+			want  int
+		}{
+			{"T where it spawns", 3, tShape, spawn(3), 0},
+			{"T one tap", 3, tShape, spawn(3) - 1, 1},
+			{"T DAS to the wall", 3, tShape, 0, 1},
+			{"T rotated", 3, RotateRight(tShape), spawn(3), 1},
+			{"I DAS right", 1, iShape, settings.Board.Width - 4, 1},
+			{"I standing on the left wall", 1, RotateRight(iShape), -2, 2},
+			{"O one tap", 2, oShape, spawn(2) + 1, 1},
+		}
+		for _, c := range cases {
+			assert.Equal(t, c.want, finesseOptimal(c.id, c.shape, c.col, settings), c.name)
+		}
+	})
+	t.Run("wasted taps are faults, a DAS run is one input", func(t *testing.T) {
+		broadcast := make(chan Packet, 64)
+		listBlock := make([]int, 100)
+		for i := range listBlock {
+			listBlock[i] = 3
+		}
+		exec := newTestExecutor(t, listBlock)
+		keys := map[int][]string{1: {"left"}, 10: {"right"}, 20: {"left"}, 21: {"space"}, //3 taps for 1
+			30: {"left"}, 31: {"left"}, 32: {"left"}, 33: {"left"}, 40: {"space"}} //DAS run for 1
+		for frame := 1; frame <= 40; frame++ {
+			exec.recordInputs(0, []Input{{Frame: frame, Keys: keys[frame]}}, frame, broadcast)
+			assertNoErr(t, exec.computeBatchFrames(frame, frame, broadcast))
+			for len(broadcast) > 0 {
+				<-broadcast
+			}
+		}
+		first, _ := exec.frames.Get(21)
+		assert.True(t, first.events.locked)
+		assert.Equal(t, 2, first.events.finesse)
+		second, _ := exec.frames.Get(40)
+		assert.True(t, second.events.locked)
+		assert.Equal(t, 0, second.events.finesse)
+	})
+}
+
+func TestTSpin(t *testing.T) {
+	//T-spin double slot: overhang at column 3 of row 19
+	board := CreateEmptyBoard(DefaultBoardSize)
+	for c := range board[0] {
+		board[21][c] = 8
+		board[20][c] = 8
+	}
+	board[21][4] = 0
+	board[20][3], board[20][4], board[20][5] = 0, 0, 0
+	board[19][3] = 8
+	down := Block{shape: RotateRight(RotateRight(Tetromino[3].shape)), form: 2}
+
+	t.Run("T pointing into the slot after a rotation", func(t *testing.T) {
+		bs := &BoardState{board: board, block: down, cRow: 19, cCol: 3, moves: pieceMoves{rotated: true}}
+		assert.Equal(t, "full", tSpin(bs))
+		bs.moves.rotated = false
+		assert.Equal(t, "", tSpin(bs), "moved in last")
+	})
+	t.Run("only the back corners", func(t *testing.T) {
+		flat := CreateEmptyBoard(DefaultBoardSize)
+		flat[20][2] = 8
+		bs := &BoardState{board: flat, block: Tetromino[3], cRow: 20, cCol: 2, moves: pieceMoves{rotated: true}}
+		//the floor is two corners, the block at (20,2) only one of the front ones
+		assert.Equal(t, "mini", tSpin(bs))
+	})
+	t.Run("not a T", func(t *testing.T) {
+		bs := &BoardState{board: board, block: Tetromino[2], cRow: 19, cCol: 3, moves: pieceMoves{rotated: true}}
+		assert.Equal(t, "", tSpin(bs))
+	})
+}
+
+func TestStatsTally(t *testing.T) {
+	tally := newStatsTally()
+	frames := []frameEvents{
+		{locked: true, cleared: 1},                //combo 0
+		{locked: true, cleared: 4, garbage: 2},    //combo 1, b2b 0
+		{locked: true, finesse: 2, attack: 4},     //combo broken, b2b kept
+		{locked: true, cleared: 2, tSpin: "full"}, //b2b 1
+		{landed: 3},                                //not a lock
+		{locked: true, cleared: 1, finesse: -1},    //b2b broken
+		{locked: true, cleared: 0, tSpin: "mini"},  //combo broken
+		{locked: true, cleared: 33, tSpin: "full"}, //sprint done
+	}
+	for i, events := range frames {
+		tally.count(i+1, events, ModeSolo)
+	}
+	s := tally.get()
+	assert.Equal(t, 7, s.Pieces)
+	assert.Equal(t, 41, s.Lines)
+	assert.Equal(t, 4, s.Attack)
+	assert.Equal(t, 3, s.Received)
+	assert.Equal(t, 2, s.GarbageCleared)
+	assert.Equal(t, 1, s.MaxCombo)
+	assert.Equal(t, 1, s.MaxB2B)
+	assert.Equal(t, TSpins{Double: 1, Triple: 1, Mini: 1}, s.TSpins)
+	assert.Equal(t, 2, s.Finesse)
+	assert.Equal(t, 8, s.SprintFrames)
+	dto := s.ToDTO()
+	assert.InDelta(t, 7.0*TICK/8, dto.PPS, 0.001)
+	assert.InDelta(t, 6.0*100*TICK/8, dto.VS, 0.001)
+}
+
+func TestStatsStream(t *testing.T) {
+	h := newTetrisHarness(1, 1000)
+	h.Run(3 * TICK)
+	for _, pId := range []string{"player-1", "player-2"} {
+		var own []Message
+		for _, msg := range h.Messages("player-1", "stats") {
+			if msg.PlayerId == pId {
+				own = append(own, msg)
+			}
+		}
+		//every second, to everyone in the room
+		if assert.Len(t, own, 3, pId) {
+			assert.Greater(t, own[2].Payload.Stats.Frames, own[1].Payload.Stats.Frames, pId)
+			assert.Greater(t, own[1].Payload.Stats.Frames, own[0].Payload.Stats.Frames, pId)
+		}
+	}
+	last := h.Messages("player-2", "stats")
+	p1 := last[len(last)-1].Payload.Stats
+	if last[len(last)-1].PlayerId != "player-1" {
+		p1 = last[len(last)-2].Payload.Stats
+	}
+	assert.NotZero(t, p1.Pieces)
+	assert.Equal(t, p1.Stats.PPS(), p1.PPS)
+}
@@ -6,13 +6,16 @@ import (
 )
 
 const BOARD_WIDTH = 10
-const BOARD_HEIGHT = 22
+const BOARD_HEIGHT = 22 // including hidden rows
+const HIDDEN_ROWS = 2
 const SOFT_DROP = 100
 const LOCKDELAY float64 = 300
 const DROPSPEED float64 = 800
 const QUEUE_SIZE = 100
 const TICK = 30
 const INCOMING = 45
+const GARBAGE_CAP = 8      // lines of garbage rising per piece
+const ROLLBACK_WINDOW = 12 // frames a late input may rewind the simulation
 
 var INTERVAL = float64(time.Second.Milliseconds()) / float64(time.Duration(TICK))
 
@@ -81,6 +84,24 @@ var ReverseTetrominoMap = map[int]string{
 	1: "I", 2: "O", 3: "T", 4: "Z", 5: "L", 6: "S", 7: "J",
 }
 
+// BoardSize of the playfield, Hidden rows sit above the Height visible rows and pieces spawn there
+type BoardSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Hidden int `json:"hidden"`
+}
+
+var DefaultBoardSize = BoardSize{Width: BOARD_WIDTH, Height: BOARD_HEIGHT - HIDDEN_ROWS, Hidden: HIDDEN_ROWS}
+
+func (b BoardSize) Rows() int {
+	return b.Height + b.Hidden
+}
+
+// SpawnCol keep the classic column 4 on a 10-wide board, shifted so wide pieces still fit narrow boards
+func (b BoardSize) SpawnCol(shape [][]int) int {
+	return max(0, min(b.Width/2-1, b.Width-len(shape)))
+}
+
 type Block struct {
 	shape [][]int
 	form  int //0,1,2,3,
@@ -131,28 +152,57 @@ func GetWallKickData(size int, fromShape, toShape int) KickOffsets {
 	}
 	return WallKickJLSTZ[k]
 }
-func CalculateGarbageRows(lines int, hasSpin bool, combo int, b2b bool, perfect bool) int {
-	base := lines - 1
-	bonus := 0
+
+type AttackTable struct {
+	Lines   [5]int // base attack by number of cleared lines
+	Spin    [4]int // base attack of a spin clear by number of cleared lines
+	Combo   []int  // bonus by combo count, the last value repeats
+	B2B     int
+	B2BSpin int
+	Perfect int
+}
+
+var AttackTables = map[string]AttackTable{
+	AttackDefault: {
+		Lines:   [5]int{0, 0, 1, 2, 3},
+		Spin:    [4]int{0, 2, 3, 4},
+		Combo:   []int{0, 1},
+		B2B:     2,
+		B2BSpin: 3,
+		Perfect: 4,
+	},
+	AttackGuideline: {
+		Lines:   [5]int{0, 0, 1, 2, 4},
+		Spin:    [4]int{0, 2, 4, 6},
+		Combo:   []int{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 4, 5},
+		B2B:     1,
+		B2BSpin: 1,
+		Perfect: 10,
+	},
+}
+
+func (t AttackTable) CalculateGarbageRows(lines int, hasSpin bool, combo int, b2b bool, perfect bool) int {
 	if lines == 0 {
 		return 0
 	}
-
-	if combo > 0 {
-		bonus += 1
-	}
+	lines = min(lines, len(t.Lines)-1)
+	base := t.Lines[lines]
 	if hasSpin {
-		bonus += 2
+		base = t.Spin[min(lines, len(t.Spin)-1)]
+	}
+	bonus := 0
+	if len(t.Combo) > 0 {
+		bonus += t.Combo[min(combo, len(t.Combo)-1)]
 	}
 	if b2b {
 		if hasSpin {
-			bonus += 3
+			bonus += t.B2BSpin
 		} else {
-			bonus += 2
+			bonus += t.B2B
 		}
 	}
 	if perfect {
-		base += 4
+		base += t.Perfect
 	}
 	return base + bonus
 }
@@ -0,0 +1,395 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tetris-be/internal/game"
+)
+
+// Config of a run against a server that is already listening
+type Config struct {
+	URL           string        // http://host:port of the server
+	Clients       int           // simulated players, two per room
+	Duration      time.Duration // played by each match once it started
+	KeysPerSecond float64       // key presses per player, other frames only carry latestFrame
+	Seed          int64
+}
+
+// Report what every client saw, added up
+type Report struct {
+	Clients  int
+	Matches  int // rooms that got to "start"
+	Sent     int // messages sent
+	Received int
+	Acked    int // input batches acked by the server
+	Dropped  int // input batches never acked
+	Rejected int // input frames the server refused
+	Errors   map[string]int
+	Latency  []time.Duration // input batch to its ack, sorted
+	Elapsed  time.Duration
+}
+
+// Percentile of the ack latency, p in 0..100
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.Latency) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latency)-1) * p / 100)
+	return r.Latency[i]
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	secs := r.Elapsed.Seconds()
+	fmt.Fprintf(&b, "clients: %d  matches: %d  elapsed: %v\n", r.Clients, r.Matches, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "messages: sent %d (%.0f/s)  received %d (%.0f/s)\n", r.Sent, float64(r.Sent)/secs, r.Received, float64(r.Received)/secs)
+	fmt.Fprintf(&b, "inputs: acked %d  dropped %d  rejected frames %d\n", r.Acked, r.Dropped, r.Rejected)
+	fmt.Fprintf(&b, "ack latency: p50 %v  p90 %v  p99 %v  max %v\n",
+		r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	if len(r.Errors) == 0 {
+		b.WriteString("errors: none\n")
+		return b.String()
+	}
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	b.WriteString("errors:\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "  %s: %d\n", kind, r.Errors[kind])
+	}
+	return b.String()
+}
+
+// collector is shared by all clients, each one adds to it when it is done
+type collector struct {
+	mu     sync.Mutex
+	report Report
+}
+
+func (c *collector) fail(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report.Errors[kind]++
+}
+
+func (c *collector) add(cl *client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &c.report
+	r.Sent += cl.sent
+	r.Received += cl.received
+	r.Acked += cl.acked
+	r.Dropped += len(cl.sentAt)
+	r.Rejected += cl.rejected
+	r.Latency = append(r.Latency, cl.latency...)
+	for kind, n := range cl.errors {
+		r.Errors[kind] += n
+	}
+}
+
+// Run play Clients/2 matches at the same time and wait for all of them
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if cfg.Clients < 2 || cfg.Clients%2 != 0 {
+		return Report{}, errors.New("clients must be an even number, two per room")
+	}
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return Report{}, err
+	}
+	col := &collector{report: Report{Clients: cfg.Clients, Errors: map[string]int{}}}
+	began := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients/2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := playMatch(ctx, cfg, base, i, col); err != nil {
+				col.fail(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	col.report.Elapsed = time.Since(began)
+	slices.Sort(col.report.Latency)
+	return col.report, nil
+}
+
+// playMatch seat two clients in a new room the way the frontend does: the first one creates the
+// room over POST /rooms, the second joins it, the first one sends ready and both start
+func playMatch(ctx context.Context, cfg Config, base *url.URL, n int, col *collector) error {
+	rng := rand.New(rand.NewSource(cfg.Seed + int64(n)))
+	ids := []string{fmt.Sprintf("load-%d-a", n), fmt.Sprintf("load-%d-b", n)}
+	var clients []*client
+	defer func() {
+		for _, cl := range clients {
+			cl.conn.Close()
+		}
+	}()
+	roomID := ""
+	for _, id := range ids {
+		room, query, err := requestTicket(base, roomID, id)
+		if err != nil {
+			return err
+		}
+		roomID = room
+		cl, err := dial(base, query, id, rand.New(rand.NewSource(rng.Int63())))
+		if err != nil {
+			return err
+		}
+		clients = append(clients, cl)
+		if _, err := cl.readUntil("session"); err != nil {
+			return err
+		}
+	}
+	if err := clients[0].write(game.NewMessage("ready")); err != nil {
+		return err
+	}
+	starts := make([]game.Message, len(clients))
+	for i, cl := range clients {
+		start, err := cl.readUntil("start")
+		if err != nil {
+			return err
+		}
+		if start.Error != "" {
+			return fmt.Errorf("start: %s", start.Error)
+		}
+		starts[i] = start
+	}
+	col.mu.Lock()
+	col.report.Matches++
+	col.mu.Unlock()
+	if err := clients[0].write(game.NewMessage("start")); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i, cl := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl.play(ctx, cfg, starts[i])
+			col.add(cl)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func requestTicket(base *url.URL, roomID string, playerID string) (string, string, error) {
+	body, _ := json.Marshal(map[string]string{"playerID": playerID})
+	resp, err := http.Post(base.String()+"/rooms?roomid="+roomID, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", "", errors.New("http: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", "", fmt.Errorf("http: POST /rooms status %d", resp.StatusCode)
+	}
+	var out struct {
+		Room  game.RoomDTO `json:"room"`
+		WsURL string       `json:"ws_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", "", errors.New("http: " + err.Error())
+	}
+	u, err := url.Parse(out.WsURL)
+	if err != nil {
+		return "", "", errors.New("http: " + err.Error())
+	}
+	//ws_url has the host the server is configured with, behind a proxy or in tests it's not ours
+	return out.Room.ID, u.RawQuery, nil
+}
+
+// client one simulated player. Only play's goroutines touch it until play returns
+type client struct {
+	id   string
+	conn *websocket.Conn
+	rng  *rand.Rand
+
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	sentAt   map[int]time.Time // input batch seq -> send time, until acked
+	sent     int
+	received int
+	acked    int
+	rejected int
+	latency  []time.Duration
+	errors   map[string]int
+}
+
+func dial(base *url.URL, query string, id string, rng *rand.Rand) (*client, error) {
+	scheme := "ws"
+	if base.Scheme == "https" {
+		scheme = "wss"
+	}
+	u := url.URL{Scheme: scheme, Host: base.Host, Path: "/ws/match", RawQuery: query}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, errors.New("dial: " + err.Error())
+	}
+	return &client{id: id, conn: conn, rng: rng, sentAt: map[int]time.Time{}, errors: map[string]int{}}, nil
+}
+
+func (cl *client) write(msg game.Message) error {
+	cl.writeMu.Lock()
+	defer cl.writeMu.Unlock()
+	cl.conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	if err := cl.conn.WriteJSON(msg); err != nil {
+		return errors.New("write: " + err.Error())
+	}
+	cl.mu.Lock()
+	cl.sent++
+	cl.mu.Unlock()
+	return nil
+}
+
+// readUntil is for before the match, skipping everything else but clock sync pings
+func (cl *client) readUntil(msgType string) (game.Message, error) {
+	cl.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg game.Message
+		if err := cl.conn.ReadJSON(&msg); err != nil {
+			return msg, fmt.Errorf("waiting for %s: %v", msgType, err)
+		}
+		cl.received++
+		switch msg.Type {
+		case msgType:
+			return msg, nil
+		case "ping":
+			cl.pong(msg)
+		case "error":
+			return msg, errors.New("error: " + msg.Error)
+		}
+	}
+}
+
+func (cl *client) pong(ping game.Message) {
+	msg := game.NewMessage("pong")
+	msg.Timestamp = ping.Timestamp
+	msg.Payload.ClientTime = time.Now().UnixMilli()
+	cl.write(msg)
+}
+
+func (cl *client) fail(kind string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.errors[kind]++
+}
+
+var keys = []string{"left", "right", "rotate", "down", "left", "right", "space"}
+
+// play send a batch every frame from startAt until cfg.Duration is over or the match ends, the
+// keys are pressed at random at KeysPerSecond. Inputs are for frame+inputDelay like the frontend
+func (cl *client) play(ctx context.Context, cfg Config, start game.Message) {
+	done := make(chan struct{})
+	go cl.read(done)
+
+	startAt := time.UnixMilli(start.Payload.StartAt)
+	delay := start.Payload.InputDelay
+	frameDur := time.Second / game.TICK
+	end := startAt.Add(cfg.Duration)
+	ticker := time.NewTicker(frameDur)
+	defer ticker.Stop()
+	lastFrame, seq := 0, 0
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-done:
+			break loop
+		case now := <-ticker.C:
+			if now.After(end) {
+				break loop
+			}
+			frame := int(now.Sub(startAt) / frameDur)
+			if frame <= lastFrame {
+				continue
+			}
+			lastFrame = frame
+			seq++
+			msg := game.NewMessage("inputs")
+			msg.Payload.Seq = seq
+			msg.Payload.LatestFrame = frame + delay
+			if cl.rng.Float64() < cfg.KeysPerSecond/game.TICK {
+				msg.Payload.Inputs = []game.Input{{Frame: frame + delay, Keys: []string{keys[cl.rng.Intn(len(keys))]}}}
+			}
+			cl.mu.Lock()
+			cl.sentAt[seq] = now
+			cl.mu.Unlock()
+			if err := cl.write(msg); err != nil {
+				cl.fail("write")
+				break loop
+			}
+		}
+	}
+	//give the last batches a moment to be acked before counting them as dropped
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		cl.mu.Lock()
+		pending := len(cl.sentAt)
+		cl.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cl.conn.Close()
+	<-done
+}
+
+func (cl *client) read(done chan struct{}) {
+	defer close(done)
+	for {
+		cl.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg game.Message
+		if err := cl.conn.ReadJSON(&msg); err != nil {
+			return //closed by play, or the server went away
+		}
+		now := time.Now()
+		cl.mu.Lock()
+		cl.received++
+		switch msg.Type {
+		case "input-server":
+			cl.rejected += len(msg.Payload.Rejected)
+			//seq is cumulative, everything up to it arrived
+			for seq, at := range cl.sentAt {
+				if seq <= msg.Payload.Seq {
+					cl.latency = append(cl.latency, now.Sub(at))
+					cl.acked++
+					delete(cl.sentAt, seq)
+				}
+			}
+		case "error", "resync":
+			cl.errors[msg.Type+" "+msg.Error]++
+		case "gameover":
+			clear(cl.sentAt) //the loop stopped, nothing after this is acked
+		}
+		cl.mu.Unlock()
+		switch msg.Type {
+		case "ping":
+			cl.pong(msg)
+		case "opponent", "opponent-delta":
+			ack := game.NewMessage("opponent-ack")
+			ack.PlayerId = msg.PlayerId
+			ack.Payload.Seq = msg.Payload.Seq
+			cl.write(ack)
+		case "gameover":
+			return
+		}
+	}
+}
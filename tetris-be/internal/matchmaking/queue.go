@@ -0,0 +1,278 @@
+package matchmaking
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"tetris-be/internal/game"
+	"time"
+)
+
+var (
+	ErrAlreadyQueued = errors.New("already in the queue")
+	ErrNotQueued     = errors.New("not in the queue")
+)
+
+type Config struct {
+	// rating difference accepted right away, it grows by WidenBy every WidenEvery spent waiting
+	// up to MaxWindow
+	Window         float64
+	WidenBy        float64
+	WidenEvery     time.Duration
+	MaxWindow      float64
+	AnyRegionAfter time.Duration // once both waited this long the region tag doesn't matter
+	Timeout        time.Duration // still waiting after this, the player is dropped
+	Linger         time.Duration // how long a result is kept for players listening late or again
+	Interval       time.Duration // between two pairing passes
+}
+
+var DefaultConfig = Config{
+	Window:         100,
+	WidenBy:        50,
+	WidenEvery:     5 * time.Second,
+	MaxWindow:      400,
+	AnyRegionAfter: 20 * time.Second,
+	Timeout:        2 * time.Minute,
+	Linger:         time.Minute,
+	Interval:       500 * time.Millisecond,
+}
+
+type Request struct {
+	PlayerID string  `json:"playerID"`
+	Rating   float64 `json:"rating"`
+	Region   string  `json:"region,omitempty"` // region or latency tag, empty is paired with anyone
+}
+
+// Match what a player needs to join the room made for the pair
+type Match struct {
+	RoomID   string `json:"roomID"`
+	Opponent string `json:"opponent"`
+	WsURL    string `json:"ws_url"`
+}
+
+type Event struct {
+	Type  string `json:"type"` // matched, timeout, cancelled
+	Match *Match `json:"match,omitempty"`
+}
+
+// PairFunc create the room of a pair, the matches come back in the order of the players
+type PairFunc func(a, b Request) ([2]Match, error)
+
+type entry struct {
+	Request
+	id       string
+	joinedAt time.Time
+	doneAt   time.Time // zero while the player is queued
+	result   Event     // set with doneAt, kept until the entry expires
+	//one per Listen, each gets the result once. Buffered, finish never waits on a listener gone
+	listeners []chan Event
+}
+
+// Queue pair waiting players by rating and region. The pairing loop only runs while someone is
+// queued or a result is waiting to be picked up
+type Queue struct {
+	cfg   Config
+	clock game.Clock
+	pair  PairFunc
+
+	mu      sync.Mutex
+	entries map[string]*entry // by entry id, queued and done
+	waiting []*entry          // queued, oldest first
+	players map[string]string // map[playerID] entry id, queued or being paired
+	running bool
+}
+
+func NewQueue(cfg Config, clock game.Clock, pair PairFunc) *Queue {
+	if clock == nil {
+		clock = game.WallClock
+	}
+	return &Queue{
+		cfg:     cfg,
+		clock:   clock,
+		pair:    pair,
+		entries: make(map[string]*entry),
+		players: make(map[string]string),
+	}
+}
+
+// Enqueue return the entry id, it is the only handle on the entry so it isn't guessable
+func (q *Queue) Enqueue(req Request) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.players[req.PlayerID]; ok {
+		return "", ErrAlreadyQueued
+	}
+	e := &entry{Request: req, id: hex.EncodeToString(b), joinedAt: q.clock.Now()}
+	q.entries[e.id] = e
+	q.waiting = append(q.waiting, e)
+	q.players[req.PlayerID] = e.id
+	if !q.running {
+		q.running = true
+		go q.loop()
+	}
+	return e.id, nil
+}
+
+// Cancel leave the queue, too late once the pair is being made
+func (q *Queue) Cancel(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok || !e.doneAt.IsZero() || !slices.Contains(q.waiting, e) {
+		return ErrNotQueued
+	}
+	q.waiting = slices.DeleteFunc(q.waiting, func(w *entry) bool { return w == e })
+	q.finish(e, Event{Type: "cancelled"}, q.clock.Now())
+	return nil
+}
+
+// Listen the channel the result of the entry arrives on. Every listener gets it, one that comes
+// after the result too, until the entry expires
+func (q *Queue) Listen(id string) (<-chan Event, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.entries[id]
+	if !ok {
+		return nil, ErrNotQueued
+	}
+	events := make(chan Event, 1)
+	if !e.doneAt.IsZero() {
+		events <- e.result
+		return events, nil
+	}
+	e.listeners = append(e.listeners, events)
+	return events, nil
+}
+
+// Len players queued
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+// finish mutex lock ở nơi gọi
+func (q *Queue) finish(e *entry, ev Event, now time.Time) {
+	e.doneAt = now
+	e.result = ev
+	delete(q.players, e.PlayerID)
+	for _, events := range e.listeners {
+		events <- ev
+	}
+	e.listeners = nil
+}
+
+func (q *Queue) loop() {
+	ticker := q.clock.NewTicker(q.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		now := <-ticker.C()
+		if !q.pass(now) {
+			return
+		}
+	}
+}
+
+// pass one round: drop timeouts and forgotten results, pair who can be paired. Only the loop
+// calls it, so an entry taken out to be paired can't be paired twice. False when the loop can stop
+func (q *Queue) pass(now time.Time) bool {
+	q.mu.Lock()
+	for id, e := range q.entries {
+		if !e.doneAt.IsZero() && now.Sub(e.doneAt) >= q.cfg.Linger {
+			delete(q.entries, id)
+		}
+	}
+	q.waiting = slices.DeleteFunc(q.waiting, func(e *entry) bool {
+		if now.Sub(e.joinedAt) < q.cfg.Timeout {
+			return false
+		}
+		q.finish(e, Event{Type: "timeout"}, now)
+		return true
+	})
+	pairs := q.pairs(now)
+	q.mu.Unlock()
+
+	//creating a room hashes its key, slow, so not under the lock
+	var failed []*entry
+	for _, p := range pairs {
+		matches, err := q.pair(p[0].Request, p[1].Request)
+		if err != nil {
+			log.Printf("[matchmaking] pairing %s and %s: %v", p[0].PlayerID, p[1].PlayerID, err)
+			failed = append(failed, p[0], p[1])
+			continue
+		}
+		q.mu.Lock()
+		for i, e := range p {
+			q.finish(e, Event{Type: "matched", Match: &matches[i]}, now)
+		}
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(failed) > 0 {
+		//back in the queue at their place, they are tried again next pass
+		q.waiting = append(q.waiting, failed...)
+		slices.SortStableFunc(q.waiting, func(a, b *entry) int { return a.joinedAt.Compare(b.joinedAt) })
+	}
+	if len(q.entries) == 0 {
+		q.running = false
+		return false
+	}
+	return true
+}
+
+// pairs take out of waiting each player with the closest rating they both accept, the one waiting
+// longest chooses first. mutex lock ở nơi gọi
+func (q *Queue) pairs(now time.Time) [][2]*entry {
+	var pairs [][2]*entry
+	paired := make(map[*entry]bool)
+	for i, a := range q.waiting {
+		if paired[a] {
+			continue
+		}
+		var best *entry
+		for _, b := range q.waiting[i+1:] {
+			if paired[b] || !q.compatible(a, b, now) {
+				continue
+			}
+			if best == nil || math.Abs(a.Rating-b.Rating) < math.Abs(a.Rating-best.Rating) {
+				best = b
+			}
+		}
+		if best != nil {
+			paired[a], paired[best] = true, true
+			pairs = append(pairs, [2]*entry{a, best})
+		}
+	}
+	q.waiting = slices.DeleteFunc(q.waiting, func(e *entry) bool { return paired[e] })
+	return pairs
+}
+
+func (q *Queue) compatible(a, b *entry, now time.Time) bool {
+	diff := math.Abs(a.Rating - b.Rating)
+	if diff > q.window(a, now) || diff > q.window(b, now) {
+		return false
+	}
+	if a.Region == "" || b.Region == "" || a.Region == b.Region {
+		return true
+	}
+	return now.Sub(a.joinedAt) >= q.cfg.AnyRegionAfter && now.Sub(b.joinedAt) >= q.cfg.AnyRegionAfter
+}
+
+// window the rating difference e accepts after waiting until now
+func (q *Queue) window(e *entry, now time.Time) float64 {
+	widened := q.cfg.Window
+	if q.cfg.WidenEvery > 0 {
+		widened += q.cfg.WidenBy * float64(now.Sub(e.joinedAt)/q.cfg.WidenEvery)
+	}
+	return min(widened, max(q.cfg.MaxWindow, q.cfg.Window))
+}
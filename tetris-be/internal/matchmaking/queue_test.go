@@ -0,0 +1,218 @@
+package matchmaking
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"tetris-be/internal/game"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pairRecorder struct {
+	mu    sync.Mutex
+	pairs [][2]string
+	fail  bool
+}
+
+func (p *pairRecorder) pair(a, b Request) ([2]Match, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail {
+		return [2]Match{}, errors.New("server is busy")
+	}
+	p.pairs = append(p.pairs, [2]string{a.PlayerID, b.PlayerID})
+	room := a.PlayerID + "-" + b.PlayerID
+	return [2]Match{{RoomID: room, Opponent: b.PlayerID}, {RoomID: room, Opponent: a.PlayerID}}, nil
+}
+
+func (p *pairRecorder) recorded() [][2]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][2]string(nil), p.pairs...)
+}
+
+func newTestQueue(t *testing.T) (*Queue, *game.ManualClock, *pairRecorder) {
+	t.Helper()
+	clock := game.NewManualClock(time.Unix(0, 0))
+	rec := &pairRecorder{}
+	return NewQueue(DefaultConfig, clock, rec.pair), clock, rec
+}
+
+// tick run one pairing pass, the loop starts its ticker in its own goroutine
+func tick(t *testing.T, clock *game.ManualClock) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for clock.Tickers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pairing loop is not running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Tick()
+}
+
+// advance move the clock by d one pairing pass at a time
+func advance(t *testing.T, clock *game.ManualClock, d time.Duration) {
+	t.Helper()
+	for range d / DefaultConfig.Interval {
+		tick(t, clock)
+	}
+}
+
+func enqueue(t *testing.T, q *Queue, player string, rating float64, region string) <-chan Event {
+	t.Helper()
+	id, err := q.Enqueue(Request{PlayerID: player, Rating: rating, Region: region})
+	assertNoErr(t, err)
+	events, err := q.Listen(id)
+	assertNoErr(t, err)
+	return events
+}
+
+func result(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+		return Event{}
+	}
+}
+
+func assertWaiting(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case ev := <-events:
+		t.Fatalf("still queued, got %+v", ev)
+	default:
+	}
+}
+
+func assertNoErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("close ratings are paired on the next pass", func(t *testing.T) {
+		q, clock, _ := newTestQueue(t)
+		a := enqueue(t, q, "alice", 1500, "eu")
+		b := enqueue(t, q, "bob", 1550, "eu")
+		tick(t, clock)
+
+		ev := result(t, a)
+		assert.Equal(t, "matched", ev.Type)
+		assert.Equal(t, Match{RoomID: "alice-bob", Opponent: "bob"}, *ev.Match)
+		assert.Equal(t, "alice", result(t, b).Match.Opponent)
+		assert.Equal(t, 0, q.Len())
+	})
+	t.Run("every listener gets the result", func(t *testing.T) {
+		q, clock, _ := newTestQueue(t)
+		id, err := q.Enqueue(Request{PlayerID: "alice", Rating: 1500})
+		assertNoErr(t, err)
+		first, err := q.Listen(id)
+		assertNoErr(t, err)
+		second, err := q.Listen(id)
+		assertNoErr(t, err)
+		enqueue(t, q, "bob", 1500, "")
+		tick(t, clock)
+
+		assert.Equal(t, "bob", result(t, first).Match.Opponent)
+		assert.Equal(t, "bob", result(t, second).Match.Opponent)
+		//the stream dropped, the player listens again
+		again, err := q.Listen(id)
+		assertNoErr(t, err)
+		assert.Equal(t, "bob", result(t, again).Match.Opponent)
+	})
+	t.Run("the closest rating wins", func(t *testing.T) {
+		q, clock, rec := newTestQueue(t)
+		enqueue(t, q, "alice", 1500, "")
+		enqueue(t, q, "bob", 1590, "")
+		enqueue(t, q, "carol", 1480, "")
+		//the second tick waits for the first pass
+		tick(t, clock)
+		tick(t, clock)
+
+		assert.Equal(t, [][2]string{{"alice", "carol"}}, rec.recorded())
+		assert.Equal(t, 1, q.Len())
+	})
+	t.Run("the window widens while waiting", func(t *testing.T) {
+		q, clock, rec := newTestQueue(t)
+		a := enqueue(t, q, "alice", 1500, "")
+		b := enqueue(t, q, "bob", 1720, "")
+		advance(t, clock, 5*time.Second)
+		assertWaiting(t, a)
+
+		//100 + 50 per 5s, 220 apart needs 15s
+		advance(t, clock, 10*time.Second)
+		assert.Equal(t, "matched", result(t, a).Type)
+		assert.Equal(t, "matched", result(t, b).Type)
+		assert.Len(t, rec.recorded(), 1)
+	})
+	t.Run("other regions only after waiting", func(t *testing.T) {
+		q, clock, _ := newTestQueue(t)
+		a := enqueue(t, q, "alice", 1500, "eu")
+		b := enqueue(t, q, "bob", 1500, "asia")
+		advance(t, clock, 10*time.Second)
+		assertWaiting(t, a)
+
+		advance(t, clock, DefaultConfig.AnyRegionAfter)
+		assert.Equal(t, "matched", result(t, a).Type)
+		assert.Equal(t, "matched", result(t, b).Type)
+	})
+	t.Run("timeout and cancel", func(t *testing.T) {
+		q, clock, _ := newTestQueue(t)
+		a := enqueue(t, q, "alice", 100, "")
+		id, err := q.Enqueue(Request{PlayerID: "bob", Rating: 3000})
+		assertNoErr(t, err)
+		_, err = q.Enqueue(Request{PlayerID: "bob", Rating: 3000})
+		assert.ErrorIs(t, err, ErrAlreadyQueued)
+
+		assertNoErr(t, q.Cancel(id))
+		assert.ErrorIs(t, q.Cancel(id), ErrNotQueued)
+		b, err := q.Listen(id)
+		assertNoErr(t, err)
+		assert.Equal(t, "cancelled", result(t, b).Type)
+
+		advance(t, clock, DefaultConfig.Timeout)
+		assert.Equal(t, "timeout", result(t, a).Type)
+		//queue again once out
+		enqueue(t, q, "alice", 100, "")
+	})
+	t.Run("the loop stops once results are forgotten", func(t *testing.T) {
+		q, clock, _ := newTestQueue(t)
+		id, err := q.Enqueue(Request{PlayerID: "alice", Rating: 1500})
+		assertNoErr(t, err)
+		assertNoErr(t, q.Cancel(id))
+		advance(t, clock, DefaultConfig.Linger)
+
+		_, err = q.Listen(id)
+		assert.ErrorIs(t, err, ErrNotQueued)
+		deadline := time.Now().Add(time.Second)
+		for clock.Tickers() > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Zero(t, clock.Tickers())
+	})
+	t.Run("a failed pair goes back in the queue", func(t *testing.T) {
+		q, clock, rec := newTestQueue(t)
+		rec.fail = true
+		a := enqueue(t, q, "alice", 1500, "")
+		enqueue(t, q, "bob", 1500, "")
+		tick(t, clock)
+		tick(t, clock)
+		assertWaiting(t, a)
+
+		rec.mu.Lock()
+		rec.fail = false
+		rec.mu.Unlock()
+		tick(t, clock)
+		tick(t, clock)
+		assert.Equal(t, "matched", result(t, a).Type)
+		assert.Equal(t, [][2]string{{"alice", "bob"}}, rec.recorded())
+	})
+}
@@ -0,0 +1,163 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"tetris-be/internal/game"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Settings client side handling, kept here so they follow the player to another device
+type Settings struct {
+	DAS  int    `json:"das"` // ms before a held key repeats
+	ARR  int    `json:"arr"` // ms between repeats, 0 moves to the wall
+	Skin string `json:"skin"`
+}
+
+var DefaultSettings = Settings{DAS: 167, ARR: 33, Skin: "default"}
+
+type Stats struct {
+	GamesPlayed int `json:"gamesPlayed"`
+	Wins        int `json:"wins"`
+	Pieces      int `json:"pieces"`
+	Attack      int `json:"attack"`
+	Frames      int `json:"frames"`
+	BestSprint  int `json:"bestSprint,omitempty"` // ms, solo only
+}
+
+// PPS and APM over every game played
+func (s Stats) PPS() float64 { return game.Stats{Frames: s.Frames, Pieces: s.Pieces}.PPS() }
+func (s Stats) APM() float64 { return game.Stats{Frames: s.Frames, Attack: s.Attack}.APM() }
+
+type Profile struct {
+	ID          string    `json:"ID"`
+	DisplayName string    `json:"displayName"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Settings    Settings  `json:"settings"`
+	Stats       Stats     `json:"stats"`
+}
+
+// MatchRecord one finished match in the history of each of its players
+type MatchRecord struct {
+	ID      string        `json:"ID"`
+	RoomID  string        `json:"roomID"`
+	Mode    string        `json:"mode"`
+	Ranked  bool          `json:"ranked"`
+	Winner  string        `json:"winner,omitempty"`
+	Reason  string        `json:"reason"`
+	EndedAt time.Time     `json:"endedAt"`
+	Players []MatchPlayer `json:"players"`
+}
+
+type MatchPlayer struct {
+	PlayerID   string      `json:"playerID"`
+	Pieces     int         `json:"pieces"`
+	Lines      int         `json:"lines"`
+	Attack     int         `json:"attack"`
+	Received   int         `json:"received"`
+	MaxCombo   int         `json:"maxCombo"`
+	MaxB2B     int         `json:"maxB2B"`
+	TSpins     game.TSpins `json:"tSpins"`
+	Finesse    int         `json:"finesse"`
+	PPS        float64     `json:"pps"`
+	APM        float64     `json:"apm"`
+	VS         float64     `json:"vs"`
+	Sprint     int         `json:"sprint,omitempty"`     // ms
+	Violations int         `json:"violations,omitempty"` // inputs and messages refused, see game.Violation
+}
+
+// Store keep the profiles and the match history
+type Store interface {
+	Get(playerID string) (Profile, error) // ErrNotFound for a player never seen
+	Save(profiles ...Profile) error
+	AddMatch(m MatchRecord) error
+	Matches(playerID string, offset, limit int) ([]MatchRecord, int, error) // newest first, and the total
+}
+
+// Registry create profiles on first sight and fold finished matches into them. Updates go one at
+// a time so a match and a settings change can't overwrite each other's profile
+type Registry struct {
+	store Store
+	mu    sync.Mutex
+	now   func() time.Time
+}
+
+func NewRegistry(store Store) *Registry {
+	return &Registry{store: store, now: time.Now}
+}
+
+func (r *Registry) Get(playerID string) (Profile, error) {
+	return r.store.Get(playerID)
+}
+
+func (r *Registry) Matches(playerID string, offset, limit int) ([]MatchRecord, int, error) {
+	return r.store.Matches(playerID, offset, limit)
+}
+
+// Update the display name and settings of a player, registering them if needed
+func (r *Registry) Update(playerID, displayName string, settings Settings) (Profile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, err := r.getOrNew(playerID)
+	if err != nil {
+		return Profile{}, err
+	}
+	p.DisplayName = displayName
+	p.Settings = settings
+	return p, r.store.Save(p)
+}
+
+// Record write the history record of a match and add it to the stats of its players
+func (r *Registry) Record(res game.MatchResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := MatchRecord{
+		ID:      fmt.Sprintf("%s-%d", res.RoomID, res.EndedAt.UnixMilli()),
+		RoomID:  res.RoomID,
+		Mode:    res.Mode,
+		Ranked:  res.Ranked,
+		Winner:  res.Winner,
+		Reason:  res.Reason,
+		EndedAt: res.EndedAt,
+	}
+	var profiles []Profile
+	for pId, s := range res.Players {
+		sprint := s.SprintFrames * 1000 / game.TICK
+		m.Players = append(m.Players, MatchPlayer{
+			PlayerID: pId, Pieces: s.Pieces, Lines: s.Lines, Attack: s.Attack, Received: s.Received,
+			MaxCombo: s.MaxCombo, MaxB2B: s.MaxB2B, TSpins: s.TSpins, Finesse: s.Finesse,
+			PPS: s.PPS(), APM: s.APM(), VS: s.VS(), Sprint: sprint, Violations: res.Violations[pId],
+		})
+		p, err := r.getOrNew(pId)
+		if err != nil {
+			return err
+		}
+		p.Stats.GamesPlayed++
+		if pId == res.Winner {
+			p.Stats.Wins++
+		}
+		p.Stats.Pieces += s.Pieces
+		p.Stats.Attack += s.Attack
+		p.Stats.Frames += s.Frames
+		if sprint > 0 && (p.Stats.BestSprint == 0 || sprint < p.Stats.BestSprint) {
+			p.Stats.BestSprint = sprint
+		}
+		profiles = append(profiles, p)
+	}
+	if err := r.store.AddMatch(m); err != nil {
+		return err
+	}
+	return r.store.Save(profiles...)
+}
+
+// getOrNew mutex lock ở nơi gọi
+func (r *Registry) getOrNew(playerID string) (Profile, error) {
+	p, err := r.store.Get(playerID)
+	if errors.Is(err, ErrNotFound) {
+		return Profile{ID: playerID, DisplayName: playerID, CreatedAt: r.now(), Settings: DefaultSettings}, nil
+	}
+	return p, err
+}
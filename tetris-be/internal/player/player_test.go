@@ -0,0 +1,120 @@
+package player
+
+import (
+	"testing"
+	"tetris-be/internal/game"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func match(room string, at int64, winner string, players map[string]game.Stats) game.MatchResult {
+	mode := game.ModeVersus
+	if len(players) == 1 {
+		mode = game.ModeSolo
+	}
+	return game.MatchResult{RoomID: room, Mode: mode, Winner: winner, Players: players, EndedAt: time.Unix(at, 0)}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("matches add up in the profile", func(t *testing.T) {
+		r := NewRegistry(NewMemoryStore())
+		first := match("R1", 1, "alice", map[string]game.Stats{
+			"alice": {Frames: 1800, Pieces: 120, Attack: 30, MaxCombo: 4, TSpins: game.TSpins{Double: 2}},
+			"bob":   {Frames: 1800, Pieces: 90, Attack: 10, Received: 30, Finesse: 7},
+		})
+		first.Violations = map[string]int{"bob": 3}
+		assertNoErr(t, r.Record(first))
+		assertNoErr(t, r.Record(match("R2", 2, "", map[string]game.Stats{
+			"alice": {Frames: 3600, Pieces: 100, Lines: 40, SprintFrames: 1500},
+		})))
+		assertNoErr(t, r.Record(match("R3", 3, "", map[string]game.Stats{
+			"alice": {Frames: 3600, Pieces: 100, Lines: 40, SprintFrames: 1800},
+		})))
+
+		alice, err := r.Get("alice")
+		assertNoErr(t, err)
+		assert.Equal(t, "alice", alice.DisplayName)
+		assert.Equal(t, DefaultSettings, alice.Settings)
+		assert.Equal(t, Stats{GamesPlayed: 3, Wins: 1, Pieces: 320, Attack: 30, Frames: 9000, BestSprint: 50000}, alice.Stats)
+		assert.InDelta(t, 320.0/300, alice.Stats.PPS(), 0.001)
+		assert.InDelta(t, 6.0, alice.Stats.APM(), 0.001)
+		bob, err := r.Get("bob")
+		assertNoErr(t, err)
+		assert.Equal(t, 1, bob.Stats.GamesPlayed)
+		assert.Zero(t, bob.Stats.Wins)
+		_, err = r.Get("carol")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		matches, total, err := r.Matches("alice", 0, 2)
+		assertNoErr(t, err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []string{"R3", "R2"}, roomIDs(matches))
+		matches, total, err = r.Matches("bob", 0, 10)
+		assertNoErr(t, err)
+		assert.Equal(t, 1, total)
+		if assert.Len(t, matches, 1) {
+			assert.Equal(t, "alice", matches[0].Winner)
+			assert.Len(t, matches[0].Players, 2)
+			for _, mp := range matches[0].Players {
+				if mp.PlayerID == "bob" {
+					assert.Equal(t, 30, mp.Received)
+					assert.Equal(t, 7, mp.Finesse)
+					assert.Equal(t, 3, mp.Violations)
+				} else {
+					assert.Equal(t, 4, mp.MaxCombo)
+					assert.Equal(t, 2, mp.TSpins.Double)
+					assert.Zero(t, mp.Violations)
+				}
+			}
+		}
+	})
+	t.Run("settings keep the stats", func(t *testing.T) {
+		r := NewRegistry(NewMemoryStore())
+		assertNoErr(t, r.Record(match("R1", 1, "", map[string]game.Stats{"alice": {Frames: 30, Pieces: 2}})))
+		p, err := r.Update("alice", "Alice", Settings{DAS: 100, ARR: 0, Skin: "retro"})
+		assertNoErr(t, err)
+		assert.Equal(t, "Alice", p.DisplayName)
+		assert.Equal(t, 2, p.Stats.Pieces)
+		assert.Equal(t, Settings{DAS: 100, ARR: 0, Skin: "retro"}, p.Settings)
+	})
+	t.Run("file store keeps profiles and history across restarts", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := OpenFileStore(dir)
+		assertNoErr(t, err)
+		r := NewRegistry(store)
+		for i := range 3 {
+			assertNoErr(t, r.Record(match("R"+string(rune('1'+i)), int64(i), "alice", map[string]game.Stats{
+				"alice": {Frames: 600, Pieces: 20}, "bob": {Frames: 600, Pieces: 10},
+			})))
+		}
+		_, err = r.Update("bob", "Bob", DefaultSettings)
+		assertNoErr(t, err)
+
+		reopened, err := OpenFileStore(dir)
+		assertNoErr(t, err)
+		bob, err := reopened.Get("bob")
+		assertNoErr(t, err)
+		assert.Equal(t, "Bob", bob.DisplayName)
+		assert.Equal(t, 3, bob.Stats.GamesPlayed)
+		matches, total, err := reopened.Matches("bob", 1, 5)
+		assertNoErr(t, err)
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []string{"R2", "R1"}, roomIDs(matches))
+	})
+}
+
+func roomIDs(matches []MatchRecord) []string {
+	var ids []string
+	for _, m := range matches {
+		ids = append(ids, m.RoomID)
+	}
+	return ids
+}
+
+func assertNoErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
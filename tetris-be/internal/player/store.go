@@ -0,0 +1,164 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+type MemoryStore struct {
+	mu       sync.RWMutex
+	profiles map[string]Profile
+	matches  []MatchRecord // oldest first
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{profiles: make(map[string]Profile)}
+}
+
+func (m *MemoryStore) Get(playerID string) (Profile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.profiles[playerID]
+	if !ok {
+		return Profile{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryStore) Save(profiles ...Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range profiles {
+		m.profiles[p.ID] = p
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddMatch(match MatchRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.matches = append(m.matches, match)
+	return nil
+}
+
+func (m *MemoryStore) Matches(playerID string, offset, limit int) ([]MatchRecord, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var played []MatchRecord
+	for i := len(m.matches) - 1; i >= 0; i-- {
+		match := m.matches[i]
+		if slices.ContainsFunc(match.Players, func(p MatchPlayer) bool { return p.PlayerID == playerID }) {
+			played = append(played, match)
+		}
+	}
+	offset = min(max(offset, 0), len(played))
+	return played[offset:min(offset+max(limit, 0), len(played))], len(played), nil
+}
+
+// FileStore a MemoryStore kept in dir: profiles.json rewritten on every save, matches.jsonl only
+// appended to. Enough for a single server
+type FileStore struct {
+	*MemoryStore
+	dir string
+	mu  sync.Mutex // one writer of the files at a time
+}
+
+// OpenFileStore load dir, creating it if missing
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{MemoryStore: NewMemoryStore(), dir: dir}
+	data, err := os.ReadFile(s.profilesPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.profiles); err != nil {
+			return nil, fmt.Errorf("profiles file: %w", err)
+		}
+	}
+	f, err := os.Open(s.matchesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m MatchRecord
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			//a crash mid append leaves half a line, everything before it is fine
+			return s, nil
+		}
+		s.matches = append(s.matches, m)
+	}
+	return s, scanner.Err()
+}
+
+func (f *FileStore) profilesPath() string { return filepath.Join(f.dir, "profiles.json") }
+func (f *FileStore) matchesPath() string  { return filepath.Join(f.dir, "matches.jsonl") }
+
+func (f *FileStore) Save(profiles ...Profile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.MemoryStore.mu.RLock()
+	all := make(map[string]Profile, len(f.profiles)+len(profiles))
+	for id, p := range f.profiles {
+		all[id] = p
+	}
+	f.MemoryStore.mu.RUnlock()
+	for _, p := range profiles {
+		all[p.ID] = p
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write then rename, a crash mid write leaves the old file
+	tmp, err := os.CreateTemp(f.dir, "profiles.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.profilesPath()); err != nil {
+		return err
+	}
+	return f.MemoryStore.Save(profiles...)
+}
+
+func (f *FileStore) AddMatch(m MatchRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.matchesPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return f.MemoryStore.AddMatch(m)
+}
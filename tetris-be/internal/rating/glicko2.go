@@ -0,0 +1,106 @@
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+// Glicko-2 (Glickman, "Example of the Glicko-2 system"). Every ranked match is its own rating
+// period, so a player's deviation only shrinks with the matches they play
+const (
+	DefaultRating     = 1500
+	DefaultDeviation  = 350
+	DefaultVolatility = 0.06
+
+	tau     = 0.5 // how much the volatility may move, 0.3 to 1.2 in the paper
+	scale   = 173.7178
+	epsilon = 0.000001
+)
+
+type Rating struct {
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Matches    int       `json:"matches"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// New the rating of a player who never played ranked
+func New() Rating {
+	return Rating{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Outcome one game of the period, Score 1 win, 0.5 draw, 0 loss
+type Outcome struct {
+	Opponent Rating
+	Score    float64
+}
+
+// Update r after the games of one rating period, the opponents keep the ratings they had before
+func Update(r Rating, outcomes []Outcome) Rating {
+	mu, phi := (r.Rating-DefaultRating)/scale, r.Deviation/scale
+	if len(outcomes) == 0 {
+		phi = math.Sqrt(phi*phi + r.Volatility*r.Volatility)
+		r.Deviation = min(phi*scale, DefaultDeviation)
+		return r
+	}
+
+	var vInv, sum float64
+	for _, o := range outcomes {
+		muJ, phiJ := (o.Opponent.Rating-DefaultRating)/scale, o.Opponent.Deviation/scale
+		g := g(phiJ)
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInv += g * g * e * (1 - e)
+		sum += g * (o.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma := volatility(phi, r.Volatility, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	r.Rating = mu*scale + DefaultRating
+	r.Deviation = phi * scale
+	r.Volatility = sigma
+	r.Matches += len(outcomes)
+	return r
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// volatility step 5 of the paper, the Illinois algorithm
+func volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
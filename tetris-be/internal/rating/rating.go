@@ -0,0 +1,55 @@
+package rating
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Service apply match results to the store, one at a time so two results of the same player
+// never read the same old rating
+type Service struct {
+	store Store
+	mu    sync.Mutex
+	now   func() time.Time
+}
+
+func NewService(store Store) *Service {
+	return &Service{store: store, now: time.Now}
+}
+
+// Get the rating of a player, a new player has the default one
+func (s *Service) Get(playerID string) (Rating, error) {
+	r, err := s.store.Get(playerID)
+	if errors.Is(err, ErrNotFound) {
+		return New(), nil
+	}
+	return r, err
+}
+
+// Record a ranked win, both players are rated against what the other had before the match
+func (s *Service) Record(winner, loser string) (map[string]Rating, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, err := s.Get(winner)
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.Get(loser)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	newW := Update(w, []Outcome{{Opponent: l, Score: 1}})
+	newL := Update(l, []Outcome{{Opponent: w, Score: 0}})
+	newW.UpdatedAt, newL.UpdatedAt = now, now
+	updated := map[string]Rating{winner: newW, loser: newL}
+	if err := s.store.Save(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *Service) Leaderboard(offset, limit int) ([]Standing, error) {
+	return s.store.Top(offset, limit)
+}
@@ -0,0 +1,101 @@
+package rating
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	t.Run("example of the paper", func(t *testing.T) {
+		player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+		got := Update(player, []Outcome{
+			{Opponent: Rating{Rating: 1400, Deviation: 30}, Score: 1},
+			{Opponent: Rating{Rating: 1550, Deviation: 100}, Score: 0},
+			{Opponent: Rating{Rating: 1700, Deviation: 300}, Score: 0},
+		})
+		assert.InDelta(t, 1464.06, got.Rating, 0.01)
+		assert.InDelta(t, 151.52, got.Deviation, 0.01)
+		assert.InDelta(t, 0.05999, got.Volatility, 0.00001)
+		assert.Equal(t, 3, got.Matches)
+	})
+	t.Run("a period without games only widens the deviation", func(t *testing.T) {
+		player := Rating{Rating: 1500, Deviation: 50, Volatility: 0.06}
+		got := Update(player, nil)
+		assert.Equal(t, 1500.0, got.Rating)
+		assert.InDelta(t, 51.1, got.Deviation, 0.1)
+		assert.Equal(t, DefaultDeviation, int(Update(New(), nil).Deviation))
+	})
+}
+
+func TestService(t *testing.T) {
+	t.Run("winner goes up by what the loser goes down", func(t *testing.T) {
+		s := NewService(NewMemoryStore())
+		updated, err := s.Record("alice", "bob")
+		assertNoErr(t, err)
+		alice, bob := updated["alice"], updated["bob"]
+		assert.Greater(t, alice.Rating, float64(DefaultRating))
+		assert.InDelta(t, alice.Rating-DefaultRating, DefaultRating-bob.Rating, 0.001)
+		assert.Less(t, alice.Deviation, float64(DefaultDeviation))
+		assert.Equal(t, 1, bob.Matches)
+
+		got, err := s.Get("alice")
+		assertNoErr(t, err)
+		assert.Equal(t, alice, got)
+		got, err = s.Get("carol")
+		assertNoErr(t, err)
+		assert.Equal(t, New(), got)
+	})
+	t.Run("leaderboard", func(t *testing.T) {
+		s := NewService(NewMemoryStore())
+		for _, m := range [][2]string{{"alice", "bob"}, {"alice", "carol"}, {"carol", "bob"}} {
+			_, err := s.Record(m[0], m[1])
+			assertNoErr(t, err)
+		}
+		top, err := s.Leaderboard(0, 10)
+		assertNoErr(t, err)
+		assert.Equal(t, []string{"alice", "carol", "bob"}, playerIDs(top))
+		assert.Equal(t, []int{1, 2, 3}, []int{top[0].Rank, top[1].Rank, top[2].Rank})
+
+		page, err := s.Leaderboard(1, 1)
+		assertNoErr(t, err)
+		assert.Equal(t, []string{"carol"}, playerIDs(page))
+		assert.Equal(t, 2, page[0].Rank)
+		page, err = s.Leaderboard(5, 10)
+		assertNoErr(t, err)
+		assert.Empty(t, page)
+	})
+	t.Run("file store keeps ratings across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ratings.json")
+		store, err := OpenFileStore(path)
+		assertNoErr(t, err)
+		updated, err := NewService(store).Record("alice", "bob")
+		assertNoErr(t, err)
+
+		reopened, err := OpenFileStore(path)
+		assertNoErr(t, err)
+		got, err := reopened.Get("alice")
+		assertNoErr(t, err)
+		assert.Equal(t, updated["alice"].Rating, got.Rating)
+		assert.True(t, updated["alice"].UpdatedAt.Equal(got.UpdatedAt))
+		top, err := reopened.Top(0, 10)
+		assertNoErr(t, err)
+		assert.Equal(t, []string{"alice", "bob"}, playerIDs(top))
+	})
+}
+
+func playerIDs(standings []Standing) []string {
+	var ids []string
+	for _, s := range standings {
+		ids = append(ids, s.PlayerID)
+	}
+	return ids
+}
+
+func assertNoErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
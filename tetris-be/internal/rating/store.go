@@ -0,0 +1,131 @@
+package rating
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Store keep the rating of every player who played ranked
+type Store interface {
+	Get(playerID string) (Rating, error)  // ErrNotFound for a player who never played ranked
+	Save(ratings map[string]Rating) error // all of them or none
+	Top(offset, limit int) ([]Standing, error)
+}
+
+// Standing a row of the leaderboard
+type Standing struct {
+	Rank     int    `json:"rank"`
+	PlayerID string `json:"playerID"`
+	Rating
+}
+
+type MemoryStore struct {
+	mu      sync.RWMutex
+	ratings map[string]Rating
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{ratings: make(map[string]Rating)}
+}
+
+func (m *MemoryStore) Get(playerID string) (Rating, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.ratings[playerID]
+	if !ok {
+		return Rating{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (m *MemoryStore) Save(ratings map[string]Rating) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maps.Copy(m.ratings, ratings)
+	return nil
+}
+
+// Top highest rating first, ties go to the surer rating then the name
+func (m *MemoryStore) Top(offset, limit int) ([]Standing, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	standings := make([]Standing, 0, len(m.ratings))
+	for id, r := range m.ratings {
+		standings = append(standings, Standing{PlayerID: id, Rating: r})
+	}
+	slices.SortFunc(standings, func(a, b Standing) int {
+		return cmp.Or(
+			cmp.Compare(b.Rating.Rating, a.Rating.Rating),
+			cmp.Compare(a.Deviation, b.Deviation),
+			cmp.Compare(a.PlayerID, b.PlayerID),
+		)
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	offset = min(max(offset, 0), len(standings))
+	return standings[offset:min(offset+max(limit, 0), len(standings))], nil
+}
+
+// FileStore a MemoryStore written to a JSON file on every Save, enough to keep ratings across
+// restarts of a single server
+type FileStore struct {
+	*MemoryStore
+	path string
+	mu   sync.Mutex // one writer of the file at a time
+}
+
+// OpenFileStore load path, a missing file is an empty store
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.ratings); err != nil {
+		return nil, fmt.Errorf("ratings file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (f *FileStore) Save(ratings map[string]Rating) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.MemoryStore.mu.RLock()
+	all := maps.Clone(f.ratings)
+	f.MemoryStore.mu.RUnlock()
+	maps.Copy(all, ratings)
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write then rename, a crash mid write leaves the old file
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	return f.MemoryStore.Save(ratings)
+}